@@ -32,7 +32,6 @@ import (
 	subscriptionservice "github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/service/subscription"
 	wso2_server "github.com/wso2/apk/adapter/pkg/discovery/protocol/server/v3"
 	"github.com/wso2/apk/adapter/pkg/health"
-	healthservice "github.com/wso2/apk/adapter/pkg/health/api/wso2/health/service"
 	"github.com/wso2/apk/adapter/pkg/utils/tlsutils"
 
 	"context"
@@ -41,6 +40,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/wso2/apk/adapter/config"
@@ -49,6 +49,7 @@ import (
 	logging "github.com/wso2/apk/adapter/internal/logging"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 )
 
@@ -65,6 +66,9 @@ var (
 const (
 	ads          = "ads"
 	amqpProtocol = "amqp"
+
+	// drainTimeout is the time allowed to drain the enforcer xds watches on SIGTERM
+	drainTimeout = 10 * time.Second
 )
 
 func init() {
@@ -120,7 +124,7 @@ func runManagementServer(conf *config.Config, server xdsv3.Server, enforcerServe
 	apiservice.RegisterApiDiscoveryServiceServer(grpcServer, enforcerServer)
 	subscriptionservice.RegisterJWTIssuerDiscoveryServiceServer(grpcServer, enforcerJwtIssuerDsSrv)
 	// register health service
-	healthservice.RegisterHealthServer(grpcServer, &health.Server{})
+	grpc_health_v1.RegisterHealthServer(grpcServer, &health.StandardServer{Checker: xds.GetEnforcerCacheHealthChecker()})
 
 	logger.LoggerAPK.Info("port: ", port, " management server listening")
 	go func() {
@@ -135,7 +139,7 @@ func runManagementServer(conf *config.Config, server xdsv3.Server, enforcerServe
 // Run starts the XDS server and Rest API server.
 func Run(conf *config.Config) {
 	sig := make(chan os.Signal, 2)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	// TODO: (VirajSalaka) Support the REST API Configuration via flags only if it is a valid requirement
 	flag.Parse()
 
@@ -197,6 +201,15 @@ OUTER:
 			case os.Interrupt:
 				logger.LoggerAPK.Info("Shutting down...")
 				break OUTER
+			case syscall.SIGTERM:
+				logger.LoggerAPK.Info("Draining the enforcer xds watches before shutting down...")
+				drainCtx, drainCancel := context.WithTimeout(ctx, drainTimeout)
+				if err := xds.DrainEnforcerCaches(drainCtx); err != nil {
+					logger.LoggerAPK.Warn("Failed to drain the enforcer xds watches: ", err)
+				}
+				drainCancel()
+				logger.LoggerAPK.Info("Shutting down...")
+				break OUTER
 			}
 		}
 	}
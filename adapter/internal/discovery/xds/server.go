@@ -23,6 +23,7 @@ import (
 	crand "crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -87,6 +88,7 @@ var (
 
 	cache                           envoy_cachev3.SnapshotCache
 	enforcerCache                   wso2_cache.SnapshotCache
+	enforcerCacheHealthChecker      *wso2_cache.HealthChecker
 	enforcerJwtIssuerCache          wso2_cache.SnapshotCache
 	enforcerAPICache                wso2_cache.SnapshotCache
 	enforcerApplicationPolicyCache  wso2_cache.SnapshotCache
@@ -141,7 +143,8 @@ var _ envoy_cachev3.NodeHash = IDHash{}
 
 func init() {
 	cache = envoy_cachev3.NewSnapshotCache(false, IDHash{}, nil)
-	enforcerCache = wso2_cache.NewSnapshotCache(false, IDHash{}, nil)
+	enforcerCacheHealthChecker = wso2_cache.NewHealthChecker(wso2_cache.NewSnapshotCache(false, IDHash{}, nil))
+	enforcerCache = enforcerCacheHealthChecker
 	enforcerAPICache = wso2_cache.NewSnapshotCache(false, IDHash{}, nil)
 	enforcerApplicationPolicyCache = wso2_cache.NewSnapshotCache(false, IDHash{}, nil)
 	enforcerSubscriptionPolicyCache = wso2_cache.NewSnapshotCache(false, IDHash{}, nil)
@@ -171,6 +174,11 @@ func GetEnforcerCache() wso2_cache.SnapshotCache {
 	return enforcerCache
 }
 
+// GetEnforcerCacheHealthChecker returns the health checker of the enforcer xds server cache.
+func GetEnforcerCacheHealthChecker() *wso2_cache.HealthChecker {
+	return enforcerCacheHealthChecker
+}
+
 // GetEnforcerJWTIssuerCache returns xds server cache.
 func GetEnforcerJWTIssuerCache() wso2_cache.SnapshotCache {
 	return enforcerJwtIssuerCache
@@ -206,6 +214,20 @@ func GetEnforcerThrottleDataCache() wso2_cache.SnapshotCache {
 	return enforcerThrottleDataCache
 }
 
+// DrainEnforcerCaches closes the open watches of all enforcer xds caches, so that the
+// enforcers reconnect before the adapter shuts down.
+func DrainEnforcerCaches(ctx context.Context) error {
+	var errs []error
+	for _, enforcerXdsCache := range []wso2_cache.SnapshotCache{enforcerCache, enforcerJwtIssuerCache, enforcerAPICache,
+		enforcerApplicationPolicyCache, enforcerSubscriptionPolicyCache, enforcerKeyManagerCache,
+		enforcerRevokedTokensCache, enforcerThrottleDataCache} {
+		if err := enforcerXdsCache.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // DeleteAPI deletes API with the given UUID from the given gw environments
 func DeleteAPI(uuid string, gatewayNames map[string]struct{}) error {
 
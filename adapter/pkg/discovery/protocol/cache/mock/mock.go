@@ -0,0 +1,194 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package mock provides a snapshot cache which records the calls made to it, for the unit
+// tests of the code using a snapshot cache.
+package mock
+
+import (
+	"context"
+	"sync"
+
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/stretchr/testify/assert"
+	cache "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/v3"
+)
+
+// Names of the recorded methods
+const (
+	SetSnapshot   = "SetSnapshot"
+	GetSnapshot   = "GetSnapshot"
+	ClearSnapshot = "ClearSnapshot"
+	CreateWatch   = "CreateWatch"
+	Fetch         = "Fetch"
+)
+
+// Call is a recorded call of a method of the snapshot cache, with its arguments and return
+// values in the order of the method signature. The context arguments are not recorded.
+type Call struct {
+	Method  string
+	Args    []interface{}
+	Results []interface{}
+}
+
+// MockSnapshotCache is a snapshot cache which records the calls of SetSnapshot, GetSnapshot,
+// ClearSnapshot, CreateWatch and Fetch. The calls are made on the wrapped cache, so the
+// recorded return values are the ones of a working cache, and the other methods are not
+// recorded.
+type MockSnapshotCache struct {
+	cache.SnapshotCache
+
+	calls []Call
+	mu    sync.Mutex
+}
+
+// NewMockSnapshotCache creates a mock wrapping the cache, or a new sotw snapshot cache
+// hashing the node IDs if inner is nil.
+func NewMockSnapshotCache(inner cache.SnapshotCache) *MockSnapshotCache {
+	if inner == nil {
+		inner = cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	}
+	return &MockSnapshotCache{SnapshotCache: inner}
+}
+
+func (m *MockSnapshotCache) record(method string, args []interface{}, results []interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, Call{Method: method, Args: args, Results: results})
+}
+
+// SetSnapshot sets the snapshot in the wrapped cache and records the call.
+func (m *MockSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot cache.Snapshot) error {
+	err := m.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+	m.record(SetSnapshot, []interface{}{node, snapshot}, []interface{}{err})
+	return err
+}
+
+// GetSnapshot gets the snapshot from the wrapped cache and records the call.
+func (m *MockSnapshotCache) GetSnapshot(node string) (cache.Snapshot, error) {
+	snapshot, err := m.SnapshotCache.GetSnapshot(node)
+	m.record(GetSnapshot, []interface{}{node}, []interface{}{snapshot, err})
+	return snapshot, err
+}
+
+// ClearSnapshot clears the snapshot from the wrapped cache and records the call.
+func (m *MockSnapshotCache) ClearSnapshot(node string) {
+	m.SnapshotCache.ClearSnapshot(node)
+	m.record(ClearSnapshot, []interface{}{node}, nil)
+}
+
+// CreateWatch creates the watch in the wrapped cache and records the call. The cancel
+// function is not recorded, as functions cannot be compared.
+func (m *MockSnapshotCache) CreateWatch(request *envoy_cache.Request, state stream.StreamState, value chan envoy_cache.Response) func() {
+	cancel := m.SnapshotCache.CreateWatch(request, state, value)
+	m.record(CreateWatch, []interface{}{request, state, value}, nil)
+	return cancel
+}
+
+// Fetch fetches the resources from the wrapped cache and records the call.
+func (m *MockSnapshotCache) Fetch(ctx context.Context, request *envoy_cache.Request) (envoy_cache.Response, error) {
+	response, err := m.SnapshotCache.Fetch(ctx, request)
+	m.record(Fetch, []interface{}{request}, []interface{}{response, err})
+	return response, err
+}
+
+// Calls returns the recorded calls of a method, or of all methods if method is empty, in the
+// order they were made.
+func (m *MockSnapshotCache) Calls(method string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var calls []Call
+	for _, call := range m.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset discards the recorded calls. The snapshots of the wrapped cache are kept.
+func (m *MockSnapshotCache) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+}
+
+// AssertSetSnapshotCalledWith fails the test unless SetSnapshot was called for the node with
+// a snapshot of the same versions and resources.
+func (m *MockSnapshotCache) AssertSetSnapshotCalledWith(t assert.TestingT, node string, snapshot cache.Snapshot) bool {
+	for _, call := range m.Calls(SetSnapshot) {
+		if call.Args[0] == node && equalSnapshots(call.Args[1].(cache.Snapshot), snapshot) {
+			return true
+		}
+	}
+	return assert.Fail(t, "SetSnapshot was not called with the snapshot", "node: %q", node)
+}
+
+// AssertGetSnapshotCalledWith fails the test unless GetSnapshot was called for the node.
+func (m *MockSnapshotCache) AssertGetSnapshotCalledWith(t assert.TestingT, node string) bool {
+	return m.assertCalledForNode(t, GetSnapshot, node)
+}
+
+// AssertClearSnapshotCalledWith fails the test unless ClearSnapshot was called for the node.
+func (m *MockSnapshotCache) AssertClearSnapshotCalledWith(t assert.TestingT, node string) bool {
+	return m.assertCalledForNode(t, ClearSnapshot, node)
+}
+
+// AssertCreateWatchCalledWith fails the test unless CreateWatch was called with a request of
+// the node ID for the type.
+func (m *MockSnapshotCache) AssertCreateWatchCalledWith(t assert.TestingT, node string, typeURL string) bool {
+	return m.assertRequested(t, CreateWatch, node, typeURL)
+}
+
+// AssertFetchCalledWith fails the test unless Fetch was called with a request of the node ID
+// for the type.
+func (m *MockSnapshotCache) AssertFetchCalledWith(t assert.TestingT, node string, typeURL string) bool {
+	return m.assertRequested(t, Fetch, node, typeURL)
+}
+
+// AssertNotCalled fails the test if the method was called.
+func (m *MockSnapshotCache) AssertNotCalled(t assert.TestingT, method string) bool {
+	return assert.Empty(t, m.Calls(method), "%s was called", method)
+}
+
+func (m *MockSnapshotCache) assertCalledForNode(t assert.TestingT, method string, node string) bool {
+	for _, call := range m.Calls(method) {
+		if call.Args[0] == node {
+			return true
+		}
+	}
+	return assert.Fail(t, method+" was not called for the node", "node: %q", node)
+}
+
+func (m *MockSnapshotCache) assertRequested(t assert.TestingT, method string, node string, typeURL string) bool {
+	for _, call := range m.Calls(method) {
+		request := call.Args[0].(*envoy_cache.Request)
+		if request.GetNode().GetId() == node && request.GetTypeUrl() == typeURL {
+			return true
+		}
+	}
+	return assert.Fail(t, method+" was not called with a request of the node for the type", "node: %q, type: %s", node, typeURL)
+}
+
+// equalSnapshots reports whether the snapshots have the same versions and resources.
+func equalSnapshots(a, b cache.Snapshot) bool {
+	for i := range a.Resources {
+		if a.Resources[i].Version != b.Resources[i].Version {
+			return false
+		}
+	}
+	return cache.Diff(a, b).Empty()
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package mock
+
+import (
+	"context"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/api"
+	cache "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/v3"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+)
+
+// failures counts the failures of the assertions instead of failing the test.
+type failures struct {
+	count int
+}
+
+func (f *failures) Errorf(string, ...interface{}) {
+	f.count++
+}
+
+func TestMockSnapshotCache(t *testing.T) {
+	m := NewMockSnapshotCache(nil)
+	snapshot, err := cache.NewSnapshotBuilder("1").WithAPIs(&api.Api{Vhost: "localhost", BasePath: "/foo", Version: "v1"}).Build()
+	assert.Nil(t, err)
+
+	assert.Nil(t, m.SetSnapshot(context.Background(), "node", snapshot))
+	_, err = m.Fetch(context.Background(), &envoy_cache.Request{Node: &core.Node{Id: "node"}, TypeUrl: resource.APIType})
+	assert.Nil(t, err)
+	m.ClearSnapshot("node")
+
+	m.AssertSetSnapshotCalledWith(t, "node", snapshot)
+	m.AssertFetchCalledWith(t, "node", resource.APIType)
+	m.AssertClearSnapshotCalledWith(t, "node")
+	m.AssertNotCalled(t, GetSnapshot)
+	assert.Len(t, m.Calls(""), 3)
+
+	// the assertions fail for the calls which were not made
+	other := &failures{}
+	assert.False(t, m.AssertGetSnapshotCalledWith(other, "node"))
+	assert.False(t, m.AssertCreateWatchCalledWith(other, "node", resource.APIType))
+	assert.Equal(t, 2, other.count)
+
+	m.Reset()
+	assert.Empty(t, m.Calls(""))
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+const (
+	snapshotsPath = "/snapshots"
+	requestsPath  = "/requests/"
+
+	// defaultAdminMaxBodyBytes is the default limit of the size of a snapshot set through the
+	// admin server
+	defaultAdminMaxBodyBytes = 32 << 20
+)
+
+// AdminOption configures the admin server of a snapshot cache.
+type AdminOption func(*adminHandler)
+
+// WithAdminBearerToken requires the requests to the admin server to carry the token in an
+// "Authorization: Bearer" header.
+func WithAdminBearerToken(token string) AdminOption {
+	return func(h *adminHandler) {
+		h.token = token
+	}
+}
+
+// WithAdminMaxBodyBytes limits the size of the snapshots set through the admin server. The
+// default limit is 32 MiB.
+func WithAdminMaxBodyBytes(maxBytes int64) AdminOption {
+	return func(h *adminHandler) {
+		h.maxBodyBytes = maxBytes
+	}
+}
+
+type adminHandler struct {
+	cache        SnapshotCache
+	token        string
+	maxBodyBytes int64
+}
+
+// nodeStatus is the JSON form of the status of a node served by the admin server.
+type nodeStatus struct {
+	Node                      string                `json:"node"`
+	NumWatches                int                   `json:"numWatches"`
+	NumDeltaWatches           int                   `json:"numDeltaWatches"`
+	LastWatchRequestTime      *time.Time            `json:"lastWatchRequestTime,omitempty"`
+	LastDeltaWatchRequestTime *time.Time            `json:"lastDeltaWatchRequestTime,omitempty"`
+	WarmedUp                  bool                  `json:"warmedUp"`
+	NACKs                     map[string]nackStatus `json:"nacks,omitempty"`
+	Summary                   WatchSummaryInfo      `json:"summary"`
+}
+
+// nackStatus is the JSON form of the last rejection of the responses of a type by a node.
+type nackStatus struct {
+	Version string `json:"version"`
+	Error   string `json:"error"`
+	Count   int    `json:"count"`
+}
+
+// AdminServer creates an HTTP server on addr to inspect and modify the snapshot cache:
+//
+//	GET    /snapshots         lists the nodes which have a snapshot
+//	GET    /snapshots/{node}  gets the snapshot of a node, encoded with MarshalSnapshotJSON
+//	POST   /snapshots/{node}  sets the snapshot of a node, encoded with MarshalSnapshotJSON
+//	DELETE /snapshots/{node}  clears the snapshot of a node
+//	GET    /status            lists the status of all nodes
+//	GET    /stats             gets the statistics of the cache, as returned by Stats
+//	GET    /requests/{node}   lists the last requests of a node, as returned by RequestLog
+//
+// The endpoints are authenticated with the token set with WithAdminBearerToken. Without a
+// token, the requests from addresses other than loopback addresses are forbidden. The caller
+// starts the server.
+func AdminServer(addr string, cache SnapshotCache, opts ...AdminOption) *http.Server {
+	h := &adminHandler{cache: cache, maxBodyBytes: defaultAdminMaxBodyBytes}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(snapshotsPath, h.listSnapshots)
+	mux.HandleFunc(snapshotsPath+"/", h.snapshot)
+	mux.HandleFunc("/status", h.status)
+	mux.HandleFunc("/stats", h.stats)
+	mux.HandleFunc(requestsPath, h.requests)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// authenticate rejects the requests without the bearer token if one is set, and otherwise the
+// requests which are not from a loopback address.
+func (h *adminHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.token == "" && !loopback(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if h.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loopback reports whether the host of the address is a loopback IP address.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (h *adminHandler) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, h.cache.ListNodes())
+}
+
+func (h *adminHandler) snapshot(w http.ResponseWriter, r *http.Request) {
+	node := strings.TrimPrefix(r.URL.Path, snapshotsPath+"/")
+	if node == "" {
+		http.Error(w, "missing node", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		snapshot, err := h.cache.GetSnapshot(node)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		data, err := MarshalSnapshotJSON(snapshot)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case http.MethodPost:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snapshot, err := UnmarshalSnapshotJSON(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.cache.SetSnapshot(r.Context(), node, snapshot); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		h.cache.ClearSnapshot(node)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *adminHandler) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []nodeStatus{}
+	for _, node := range h.cache.GetStatusKeys() {
+		info := h.cache.GetStatusInfo(node)
+		if info == nil {
+			continue
+		}
+		status := nodeStatus{Node: node, WarmedUp: info.IsWarmedUp()}
+		status.NumWatches, status.NumDeltaWatches = info.WatchCount()
+		if t := info.GetLastWatchRequestTime(); !t.IsZero() {
+			status.LastWatchRequestTime = &t
+		}
+		if t := info.GetLastDeltaWatchRequestTime(); !t.IsZero() {
+			status.LastDeltaWatchRequestTime = &t
+		}
+		status.NACKs = nackStatuses(info)
+		status.Summary = info.WatchSummary()
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, statuses)
+}
+
+// nackStatuses returns the rejections of the responses of each supported type by a node, or
+// nil if the node has not rejected any response.
+func nackStatuses(info StatusInfo) map[string]nackStatus {
+	var nacks map[string]nackStatus
+	for i := wso2_types.ResponseType(0); i < wso2_types.UnknownType; i++ {
+		typeURL, err := GetResponseTypeURL(i)
+		if err != nil {
+			continue
+		}
+		if count := info.NACKCount(typeURL); count > 0 {
+			if nacks == nil {
+				nacks = make(map[string]nackStatus)
+			}
+			nacks[typeURL] = nackStatus{Version: info.LastNACKedVersion(typeURL), Error: info.LastNACKError(typeURL), Count: count}
+		}
+	}
+	return nacks
+}
+
+func (h *adminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, h.cache.Stats())
+}
+
+func (h *adminHandler) requests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requests, err := h.cache.RequestLog(strings.TrimPrefix(r.URL.Path, requestsPath))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, requests)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
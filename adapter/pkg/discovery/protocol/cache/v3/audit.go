@@ -0,0 +1,89 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// AuditEntry records a version of a type set for a node.
+type AuditEntry struct {
+	Version   string    `json:"version"`
+	TypeURL   string    `json:"type_url"`
+	Timestamp time.Time `json:"timestamp"`
+	// RequestedBy is the requester of the version carried by the context of the call which
+	// set it, or empty if the context carries none.
+	RequestedBy string `json:"requested_by,omitempty"`
+}
+
+// requestedByKey is the context key of the requester of a snapshot.
+type requestedByKey struct{}
+
+// WithRequestedBy returns a context carrying the requester of the snapshots set with it, which
+// is recorded in the audit log of the nodes.
+func WithRequestedBy(ctx context.Context, requestedBy string) context.Context {
+	return context.WithValue(ctx, requestedByKey{}, requestedBy)
+}
+
+// requestedBy returns the requester carried by the context.
+func requestedBy(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestedBy, _ := ctx.Value(requestedByKey{}).(string)
+	return requestedBy
+}
+
+// SetSnapshotWithAudit sets the snapshot of a node with SetSnapshot, recording the requester
+// in the audit log of the node.
+func (cache *snapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// GetAuditLog returns the versions set for a node, from the oldest to the newest.
+func (cache *snapshotCache) GetAuditLog(node string) []AuditEntry {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return append([]AuditEntry(nil), cache.auditLogs[node]...)
+}
+
+// recordAudit appends an entry to the audit log of a node for each type whose version changed.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) recordAudit(node string, requestedBy string, previous, current Snapshot) {
+	now := time.Now()
+	for i := range current.Resources {
+		version := current.Resources[i].Version
+		if version == "" || version == previous.Resources[i].Version {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			continue
+		}
+		if cache.auditLogs == nil {
+			cache.auditLogs = make(map[string][]AuditEntry)
+		}
+		cache.auditLogs[node] = append(cache.auditLogs[node], AuditEntry{
+			Version:     version,
+			TypeURL:     typeURL,
+			Timestamp:   now,
+			RequestedBy: requestedBy,
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// WithMaxSnapshotSizeBytes rejects the snapshots larger than limit bytes, measured as the
+// sum of the encoded sizes of all their resources. A zero limit disables the check.
+func WithMaxSnapshotSizeBytes(limit int64) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.maxSnapshotSize = limit
+	}
+}
+
+// snapshotSize returns the sum of the encoded sizes of all resources of the snapshot.
+func snapshotSize(snapshot Snapshot) int64 {
+	var size int64
+	for _, resources := range snapshot.Resources {
+		for _, item := range resources.Items {
+			size += int64(proto.Size(item.Resource))
+		}
+	}
+	return size
+}
+
+// checkSnapshotSize returns the size of the snapshot of a node, and an error if it exceeds
+// the size limit of the cache.
+func (cache *snapshotCache) checkSnapshotSize(node string, snapshot Snapshot) (int64, error) {
+	size := snapshotSize(snapshot)
+	if cache.maxSnapshotSize > 0 && size > cache.maxSnapshotSize {
+		return size, fmt.Errorf("snapshot for node %q is %d bytes, which exceeds the limit of %d bytes",
+			node, size, cache.maxSnapshotSize)
+	}
+	return size, nil
+}
+
+// trackSnapshotSize replaces the size of the snapshot of a node in the memory usage of the
+// cache. A negative size removes the node. The cache mutex must be held by the caller.
+func (cache *snapshotCache) trackSnapshotSize(node string, size int64) {
+	cache.memoryUsage -= cache.snapshotSizes[node]
+	if size < 0 {
+		delete(cache.snapshotSizes, node)
+	} else {
+		cache.snapshotSizes[node] = size
+		cache.memoryUsage += size
+	}
+	cache.metrics.SnapshotMemoryUsage(cache.memoryUsage)
+}
+
+// MemoryUsageBytes returns the sum of the sizes of the snapshots of all nodes.
+func (cache *snapshotCache) MemoryUsageBytes() int64 {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return cache.memoryUsage
+}
+
+// memoryUsageBytes returns the sum of the sizes of the snapshots of the nodes with the node ID
+// prefix.
+func (cache *snapshotCache) memoryUsageBytes(prefix string) int64 {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	var usage int64
+	for node, size := range cache.snapshotSizes {
+		if strings.HasPrefix(node, prefix) {
+			usage += size
+		}
+	}
+	return usage
+}
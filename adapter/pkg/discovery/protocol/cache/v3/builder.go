@@ -0,0 +1,94 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/api"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/config/enforcer"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/subscription"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// typeURLPrefix is the prefix of the type URLs of the resource messages.
+const typeURLPrefix = "type.googleapis.com/"
+
+// SnapshotBuilder builds a snapshot from typed resources, inferring the type URL of each
+// resource from its message type.
+type SnapshotBuilder struct {
+	version   string
+	resources map[resource.Type][]types.Resource
+	opts      []SnapshotOption
+}
+
+// NewSnapshotBuilder creates a builder of a snapshot with the version.
+func NewSnapshotBuilder(version string) *SnapshotBuilder {
+	return &SnapshotBuilder{
+		version:   version,
+		resources: make(map[resource.Type][]types.Resource),
+	}
+}
+
+// AddResources adds resources of a message type to the snapshot, under the type URL of the
+// message. The builder is returned so that the calls can be chained. It is a function rather
+// than a method, as methods cannot have type parameters.
+func AddResources[T proto.Message](b *SnapshotBuilder, resources ...T) *SnapshotBuilder {
+	var zero T
+	typeURL := typeURLPrefix + string(zero.ProtoReflect().Descriptor().FullName())
+	for _, r := range resources {
+		b.resources[typeURL] = append(b.resources[typeURL], r)
+	}
+	return b
+}
+
+// WithAPIs adds the APIs to the snapshot.
+func (b *SnapshotBuilder) WithAPIs(apis ...*api.Api) *SnapshotBuilder {
+	return AddResources(b, apis...)
+}
+
+// WithConfig adds the enforcer configuration to the snapshot.
+func (b *SnapshotBuilder) WithConfig(config *enforcer.Config) *SnapshotBuilder {
+	return AddResources(b, config)
+}
+
+// WithJWTIssuers adds the JWT issuers to the snapshot.
+func (b *SnapshotBuilder) WithJWTIssuers(issuers ...*subscription.JWTIssuer) *SnapshotBuilder {
+	return AddResources(b, issuers...)
+}
+
+// WithJWTIssuerLists adds the JWT issuer lists to the snapshot.
+func (b *SnapshotBuilder) WithJWTIssuerLists(lists ...*subscription.JWTIssuerList) *SnapshotBuilder {
+	return AddResources(b, lists...)
+}
+
+// WithOptions adds options applied to the snapshot when it is built.
+func (b *SnapshotBuilder) WithOptions(opts ...SnapshotOption) *SnapshotBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build creates the snapshot, and returns an error if a resource is of an unsupported type
+// or if the snapshot is not valid.
+func (b *SnapshotBuilder) Build() (Snapshot, error) {
+	snapshot, err := NewSnapshot(b.version, b.resources, b.opts...)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := snapshot.Validate(); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// cachedSnapshot is a snapshot read from the inner cache with the time it was read.
+type cachedSnapshot struct {
+	snapshot Snapshot
+	readAt   time.Time
+}
+
+type cachingSnapshotCacheProxy struct {
+	SnapshotCache
+
+	ttl time.Duration
+	// snapshots are the snapshots last read from the inner cache, indexed by node IDs
+	snapshots sync.Map
+	// invalidations counts the drops of the copies, so that a snapshot read before a drop is
+	// not kept after it
+	invalidations atomic.Uint64
+}
+
+// CachingSnapshotCacheProxy wraps a snapshot cache to serve GetSnapshot from a local copy of
+// the snapshot last read for the node, for up to the TTL after it was read. The copy of a node
+// is dropped when its snapshot is set or cleared through the proxy, and all copies are dropped
+// by the calls which change the snapshots of several nodes.
+//
+// The snapshots changed in the inner cache without going through the proxy, such as by the
+// snapshot TTL or the eviction of the inner cache, may be served for up to the TTL after.
+func CachingSnapshotCacheProxy(inner SnapshotCache, ttl time.Duration) SnapshotCache {
+	return &cachingSnapshotCacheProxy{
+		SnapshotCache: inner,
+		ttl:           ttl,
+	}
+}
+
+// GetSnapshot returns the local copy of the snapshot if it was read within the TTL, or reads
+// the snapshot from the inner cache and keeps a copy of it.
+func (cache *cachingSnapshotCacheProxy) GetSnapshot(node string) (Snapshot, error) {
+	if value, ok := cache.snapshots.Load(node); ok {
+		cached := value.(cachedSnapshot)
+		if time.Since(cached.readAt) < cache.ttl {
+			return cached.snapshot, nil
+		}
+	}
+
+	invalidations := cache.invalidations.Load()
+	readAt := time.Now()
+	snapshot, err := cache.SnapshotCache.GetSnapshot(node)
+	if err != nil {
+		return snapshot, err
+	}
+	cache.snapshots.Store(node, cachedSnapshot{snapshot: snapshot, readAt: readAt})
+	if cache.invalidations.Load() != invalidations {
+		// the snapshot may have been changed while it was read
+		cache.snapshots.Delete(node)
+	}
+	return snapshot, nil
+}
+
+// SetSnapshot sets the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) WarmupSnapshot(node string, snapshot Snapshot) error {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.WarmupSnapshot(node, snapshot)
+}
+
+// SetSnapshots sets the snapshots in the inner cache and drops the copies of the nodes.
+func (cache *cachingSnapshotCacheProxy) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	defer func() {
+		for node := range snapshots {
+			cache.invalidate(node)
+		}
+	}()
+	return cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *cachingSnapshotCacheProxy) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *cachingSnapshotCacheProxy) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// PatchSnapshot patches the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	defer cache.invalidate(node)
+	return cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+}
+
+// ClearSnapshot clears the snapshot from the inner cache and drops the copy of the node.
+func (cache *cachingSnapshotCacheProxy) ClearSnapshot(node string) {
+	defer cache.invalidate(node)
+	cache.SnapshotCache.ClearSnapshot(node)
+}
+
+// BulkClearSnapshot clears the snapshots from the inner cache and drops the copies of the nodes.
+func (cache *cachingSnapshotCacheProxy) BulkClearSnapshot(nodes []string) int {
+	defer func() {
+		for _, node := range nodes {
+			cache.invalidate(node)
+		}
+	}()
+	return cache.SnapshotCache.BulkClearSnapshot(nodes)
+}
+
+// SetSnapshotForSelector sets the selector snapshot in the inner cache and drops all copies.
+func (cache *cachingSnapshotCacheProxy) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	defer cache.invalidateAll()
+	return cache.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+}
+
+// EvictLRU evicts the nodes from the inner cache and drops all copies.
+func (cache *cachingSnapshotCacheProxy) EvictLRU(maxNodes int) int {
+	defer cache.invalidateAll()
+	return cache.SnapshotCache.EvictLRU(maxNodes)
+}
+
+// Reset resets the inner cache and drops all copies.
+func (cache *cachingSnapshotCacheProxy) Reset(ctx context.Context) error {
+	defer cache.invalidateAll()
+	return cache.SnapshotCache.Reset(ctx)
+}
+
+// invalidate drops the copy of a node.
+func (cache *cachingSnapshotCacheProxy) invalidate(node string) {
+	cache.invalidations.Add(1)
+	cache.snapshots.Delete(node)
+}
+
+// invalidateAll drops the copies of all nodes.
+func (cache *cachingSnapshotCacheProxy) invalidateAll() {
+	cache.invalidations.Add(1)
+	cache.snapshots.Range(func(node, _ any) bool {
+		cache.snapshots.Delete(node)
+		return true
+	})
+}
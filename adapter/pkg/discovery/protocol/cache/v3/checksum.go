@@ -0,0 +1,70 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// GetChecksum returns the hex encoded SHA-256 checksum of the resources of a type, so that
+// caches can verify they hold the same resources without exchanging them. The checksum is
+// computed over the name and the deterministic serialized bytes of each resource, in the
+// order of the names, so it does not depend on the versions or the TTLs. It returns an
+// empty string if a resource cannot be serialized.
+func (s *Snapshot) GetChecksum(typeURL string) string {
+	resources := s.GetResourcesAndTTL(typeURL)
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	var length [8]byte
+	for _, name := range names {
+		marshaled, err := envoy_cache.MarshalResource(resources[name].Resource)
+		if err != nil {
+			return ""
+		}
+		// the lengths are written so that the boundaries of the names and resources are unambiguous
+		for _, b := range [][]byte{[]byte(name), marshaled} {
+			binary.BigEndian.PutUint64(length[:], uint64(len(b)))
+			h.Write(length[:])
+			h.Write(b)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// CompareChecksums compares the checksums of each type of two snapshots, and returns whether
+// they match indexed by type URL.
+func CompareChecksums(a, b Snapshot) map[string]bool {
+	out := make(map[string]bool)
+	for i := range a.Resources {
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			continue
+		}
+		checksum := a.GetChecksum(typeURL)
+		out[typeURL] = checksum != "" && checksum == b.GetChecksum(typeURL)
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// CircuitState is the state of the circuit of a CircuitBreakerSnapshotCache.
+type CircuitState int
+
+// Circuit states
+const (
+	// CircuitClosed lets the snapshots be set.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects the snapshots with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single snapshot be set as a probe after the reset duration, and
+	// rejects the others with ErrCircuitOpen. The circuit is closed if the probe succeeds, and
+	// opened again if it fails.
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker is implemented by the caches which can reject snapshots with an open circuit.
+type circuitBreaker interface {
+	CircuitState() CircuitState
+}
+
+type circuitBreakerSnapshotCache struct {
+	SnapshotCache
+
+	threshold  int
+	resetAfter time.Duration
+
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// probing is set while the probe of the half-open circuit is running
+	probing bool
+	mu      sync.Mutex
+}
+
+// CircuitBreakerSnapshotCache wraps a snapshot cache to stop setting snapshots after threshold
+// consecutive failures, such as invalid snapshots set by a broken reconciler. While the circuit
+// is open the snapshots are rejected with ErrCircuitOpen, without reaching the inner cache and
+// the proxies. After resetAfter the circuit is half-open, and a single set is let through to
+// decide whether it is closed or opened again.
+//
+// A HealthChecker wrapping the cache reports NOT_SERVING while the circuit is open.
+func CircuitBreakerSnapshotCache(inner SnapshotCache, threshold int, resetAfter time.Duration) SnapshotCache {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &circuitBreakerSnapshotCache{
+		SnapshotCache: inner,
+		threshold:     threshold,
+		resetAfter:    resetAfter,
+	}
+}
+
+// CircuitState returns the current state of the circuit.
+func (cache *circuitBreakerSnapshotCache) CircuitState() CircuitState {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.state == CircuitOpen && time.Since(cache.openedAt) >= cache.resetAfter {
+		cache.state = CircuitHalfOpen
+	}
+	return cache.state
+}
+
+// SetSnapshot sets the snapshot in the inner cache unless the circuit is open.
+func (cache *circuitBreakerSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+	})
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache unless the circuit is open.
+func (cache *circuitBreakerSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.WarmupSnapshot(node, snapshot)
+	})
+}
+
+// SetSnapshots sets the snapshots in the inner cache unless the circuit is open.
+func (cache *circuitBreakerSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+	})
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *circuitBreakerSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *circuitBreakerSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache if the node has none, unless the
+// circuit is open.
+func (cache *circuitBreakerSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	var set bool
+	err := cache.guard(func() (err error) {
+		set, err = cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+		return err
+	})
+	return set, err
+}
+
+// PatchSnapshot patches the snapshot in the inner cache unless the circuit is open.
+func (cache *circuitBreakerSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+	})
+}
+
+// SetSnapshotForSelector sets the snapshot for the matching nodes in the inner cache unless
+// the circuit is open.
+func (cache *circuitBreakerSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+	})
+}
+
+// SetSnapshotVariant sets the snapshot variant in the inner cache unless the circuit is open.
+func (cache *circuitBreakerSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	return cache.guard(func() error {
+		return cache.SnapshotCache.SetSnapshotVariant(ctx, node, variant, snapshot)
+	})
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache unless the circuit is open.
+// A snapshot which is not swapped because it does not match is not a failure.
+func (cache *circuitBreakerSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	var swapped bool
+	err := cache.guard(func() (err error) {
+		swapped, err = cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+		return err
+	})
+	return swapped, err
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache unless the circuit is
+// open.
+func (cache *circuitBreakerSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	var snapshot Snapshot
+	err := cache.guard(func() (err error) {
+		snapshot, err = cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+		return err
+	})
+	return snapshot, err
+}
+
+// guard calls fn unless the circuit is open, and records its result. While the circuit is
+// half-open only one call is let through as a probe, and the others are rejected until the
+// result of the probe is recorded.
+func (cache *circuitBreakerSnapshotCache) guard(fn func() error) error {
+	if err := cache.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	cache.record(err)
+	return err
+}
+
+// allow reports with ErrCircuitOpen whether a call must be rejected, and lets the probe
+// through while the circuit is half-open.
+func (cache *circuitBreakerSnapshotCache) allow() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.state == CircuitOpen && time.Since(cache.openedAt) >= cache.resetAfter {
+		cache.state = CircuitHalfOpen
+	}
+	switch {
+	case cache.state == CircuitOpen, cache.state == CircuitHalfOpen && cache.probing:
+		return ErrCircuitOpen
+	case cache.state == CircuitHalfOpen:
+		cache.probing = true
+	}
+	return nil
+}
+
+// record updates the circuit with the result of a set.
+func (cache *circuitBreakerSnapshotCache) record(err error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.probing = false
+	if err == nil {
+		cache.state = CircuitClosed
+		cache.failures = 0
+		return
+	}
+	cache.failures++
+	if cache.state == CircuitHalfOpen || cache.failures >= cache.threshold {
+		cache.state = CircuitOpen
+		cache.openedAt = time.Now()
+	}
+}
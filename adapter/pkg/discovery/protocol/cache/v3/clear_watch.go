@@ -0,0 +1,66 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+// CreateClearWatch opens a watch on the snapshot of a node, which is responded once when the
+// snapshot is cleared, either with ClearSnapshot or when it expires. If the node has no
+// snapshot, the watch is responded immediately. The value is sent without blocking, so the
+// channel should have a capacity of one.
+func (cache *snapshotCache) CreateClearWatch(node string, value chan struct{}) func() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.snapshots.Load().get(node); !ok {
+		cache.notifyClearWatch(node, value)
+		return func() {}
+	}
+
+	watches, ok := cache.clearWatches[node]
+	if !ok {
+		watches = make(map[int64]chan struct{})
+		cache.clearWatches[node] = watches
+	}
+	watchID := cache.nextWatchID()
+	cache.log.Debug("open clear watch", nodeField(node), watchField(watchID))
+	watches[watchID] = value
+
+	return func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if watches, ok := cache.clearWatches[node]; ok {
+			delete(watches, watchID)
+			if len(watches) == 0 {
+				delete(cache.clearWatches, node)
+			}
+		}
+	}
+}
+
+// respondClearWatches responds to the clear watches of a node and discards them.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) respondClearWatches(node string) {
+	for _, value := range cache.clearWatches[node] {
+		cache.notifyClearWatch(node, value)
+	}
+	delete(cache.clearWatches, node)
+}
+
+func (cache *snapshotCache) notifyClearWatch(node string, value chan struct{}) {
+	select {
+	case value <- struct{}{}:
+	default:
+		cache.log.Warn("dropped clear watch notification as the channel is full", nodeField(node))
+	}
+}
@@ -0,0 +1,109 @@
+//go:build testing
+
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import "sync/atomic"
+
+// CloneableSnapshotCache is a snapshot cache which can be forked for the isolation of tests.
+// It is only built with the testing build tag, so that production code cannot use it.
+type CloneableSnapshotCache interface {
+	SnapshotCache
+
+	// Clone returns a standalone cache holding a deep copy of the snapshots of the cache.
+	Clone() SnapshotCache
+}
+
+// Clone returns a new cache with the configuration of the cache and deep copies of the
+// snapshots, snapshot variants and selector snapshots of all nodes. The status of the nodes,
+// the open watches, the histories and the request logs are not copied, and the clone has no
+// background goroutines: it does not heartbeat, expire snapshots or reap watches. The clone
+// does not share the metrics, the event bus or the listeners of the cache, and does not
+// deduplicate its resources.
+func (cache *snapshotCache) Clone() SnapshotCache {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	clone := &snapshotCache{
+		log:             cache.log,
+		ads:             cache.ads,
+		federation:      cache.federation,
+		ordering:        cache.ordering,
+		schemas:         cache.schemas,
+		variantSelector: cache.variantSelector,
+		lastSetTime:     make(map[string]*int64, len(cache.lastSetTime)),
+		history:         make(map[string]*ringBuffer[SnapshotHistoryEntry]),
+		historySize:     cache.historySize,
+		requestLogs:     make(map[string]*ringBuffer[RequestLogEntry]),
+		requestLogSize:  cache.requestLogSize,
+		autoVersion:     cache.autoVersion,
+		versionCounters: make(map[string]*int64, len(cache.versionCounters)),
+		snapshotSizes:   make(map[string]int64, len(cache.snapshotSizes)),
+		memoryUsage:     cache.memoryUsage,
+		maxNodes:        cache.maxNodes,
+		maxSnapshotSize: cache.maxSnapshotSize,
+		status:          make(map[string]*statusInfo),
+		resourceWatches: make(map[string]map[int64]resourceWatch),
+		clearWatches:    make(map[string]map[int64]chan struct{}),
+		globalWatches:   make(map[int64]globalWatch),
+		hash:            cache.hash,
+		ctx:             cache.ctx,
+		metrics:         nopMetrics{},
+		respondStrategy: cache.respondStrategy,
+		respondPriority: cache.respondPriority,
+		listeners: listenerPool{
+			workers: cache.listeners.workers,
+			timeout: cache.listeners.timeout,
+		},
+	}
+
+	cache.snapshots.Load().forEach(func(node string, snapshot Snapshot) bool {
+		clone.putSnapshot(node, CloneSnapshot(snapshot))
+		return true
+	})
+	for node, setTime := range cache.lastSetTime {
+		value := atomic.LoadInt64(setTime)
+		clone.lastSetTime[node] = &value
+	}
+	for node, size := range cache.snapshotSizes {
+		clone.snapshotSizes[node] = size
+	}
+	for node, counter := range cache.versionCounters {
+		value := atomic.LoadInt64(counter)
+		clone.versionCounters[node] = &value
+	}
+	if cache.namespaceHashes != nil {
+		clone.namespaceHashes = make(map[string]NodeHash, len(cache.namespaceHashes))
+		for prefix, hash := range cache.namespaceHashes {
+			clone.namespaceHashes[prefix] = hash
+		}
+	}
+	for _, s := range cache.selectors {
+		clone.selectors = append(clone.selectors, &selectorSnapshot{prefix: s.prefix, selector: s.selector, snapshot: CloneSnapshot(s.snapshot)})
+	}
+	if cache.variants != nil {
+		clone.variants = make(map[string]map[string]Snapshot, len(cache.variants))
+		for node, variants := range cache.variants {
+			clone.variants[node] = make(map[string]Snapshot, len(variants))
+			for variant, snapshot := range variants {
+				clone.variants[node][variant] = CloneSnapshot(snapshot)
+			}
+		}
+	}
+	return clone
+}
+
+var _ CloneableSnapshotCache = &snapshotCache{}
@@ -0,0 +1,43 @@
+//go:build testing
+
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+)
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	cache := newSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	clone := cache.Clone()
+	snapshot, err := clone.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	// the caches are independent of each other
+	assert.Nil(t, clone.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "other", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	snapshot, _ = cache.GetSnapshot(testNode)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, []string{testNode}, clone.ListNodes())
+}
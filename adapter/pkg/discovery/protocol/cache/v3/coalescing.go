@@ -0,0 +1,273 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+type coalescingSnapshotCache struct {
+	SnapshotCache
+
+	window time.Duration
+	log    log.Logger
+
+	// pending are the snapshots waiting for the window to expire, indexed by node IDs
+	pending map[string]Snapshot
+	// timers set the buffered snapshots when the windows expire, indexed by node IDs
+	timers map[string]*time.Timer
+	mu     sync.Mutex
+
+	// writeMu serializes the writes to the inner cache, so that a buffered snapshot is never
+	// set over a later write
+	writeMu sync.Mutex
+}
+
+// CoalescingSnapshotCache wraps a snapshot cache to merge the SetSnapshot calls for the same
+// node within a window. The first call for a node starts the window, and only the snapshot of
+// the last call is set in the inner cache when the window expires.
+//
+// SetSnapshot validates the snapshot with SetSnapshotDryRun of the inner cache before it is
+// buffered, and returns the validation errors. The snapshot is set with a background context,
+// and a failure to set it is logged. GetSnapshot returns the buffered
+// snapshot of a node if there is one. The other mutators write through, after the buffered
+// snapshot of the node is set in the inner cache, so the last write within the window wins.
+//
+// Logger is optional.
+func CoalescingSnapshotCache(inner SnapshotCache, window time.Duration, logger log.Logger) SnapshotCache {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+	return &coalescingSnapshotCache{
+		SnapshotCache: inner,
+		window:        window,
+		log:           logger,
+		pending:       make(map[string]Snapshot),
+		timers:        make(map[string]*time.Timer),
+	}
+}
+
+// SetSnapshot buffers the snapshot until the coalesce window of the node expires.
+func (cache *coalescingSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	if _, err := cache.SnapshotCache.SetSnapshotDryRun(ctx, node, snapshot); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.timers[node]; !ok {
+		cache.timers[node] = time.AfterFunc(cache.window, func() { cache.apply(node) })
+	}
+	cache.pending[node] = snapshot
+	return nil
+}
+
+// SetSnapshots buffers the snapshots until the coalesce windows of the nodes expire.
+func (cache *coalescingSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	for node, snapshot := range snapshots {
+		if err := cache.SetSnapshot(ctx, node, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSnapshotForNodes buffers the snapshot for the nodes until their coalesce windows expire.
+func (cache *coalescingSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// WarmupSnapshot sets the buffered snapshot of the node and warms up the inner cache.
+func (cache *coalescingSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	return cache.writeThrough(context.Background(), []string{node}, func() error {
+		return cache.SnapshotCache.WarmupSnapshot(node, snapshot)
+	})
+}
+
+// SetSnapshotWithAudit sets the buffered snapshot of the node and sets the snapshot in the
+// inner cache with an audit entry.
+func (cache *coalescingSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.writeThrough(ctx, []string{node}, func() error {
+		return cache.SnapshotCache.SetSnapshotWithAudit(ctx, node, snapshot, requestedBy)
+	})
+}
+
+// PatchSnapshot sets the buffered snapshot of the node and patches it in the inner cache.
+func (cache *coalescingSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	return cache.writeThrough(ctx, []string{node}, func() error {
+		return cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+	})
+}
+
+// CompareAndSwapSnapshot sets the buffered snapshot of the node and swaps it in the inner
+// cache, so the expected snapshot is compared with the last snapshot set for the node.
+func (cache *coalescingSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	var swapped bool
+	err := cache.writeThrough(ctx, []string{node}, func() (err error) {
+		swapped, err = cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+		return err
+	})
+	return swapped, err
+}
+
+// SetSnapshotIfAbsent sets the buffered snapshot of the node, and then sets the snapshot in
+// the inner cache if the node has none.
+func (cache *coalescingSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	var set bool
+	err := cache.writeThrough(ctx, []string{node}, func() (err error) {
+		set, err = cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+		return err
+	})
+	return set, err
+}
+
+// GetOrCreateSnapshot sets the buffered snapshot of the node, and then gets or creates the
+// snapshot in the inner cache.
+func (cache *coalescingSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	var snapshot Snapshot
+	err := cache.writeThrough(ctx, []string{node}, func() (err error) {
+		snapshot, err = cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+		return err
+	})
+	return snapshot, err
+}
+
+// SetSnapshotVariant sets the buffered snapshot of the node and sets the variant in the inner
+// cache.
+func (cache *coalescingSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	return cache.writeThrough(ctx, []string{node}, func() error {
+		return cache.SnapshotCache.SetSnapshotVariant(ctx, node, variant, snapshot)
+	})
+}
+
+// SetSnapshotForSelector sets all the buffered snapshots, as any node may match the selector,
+// and sets the snapshot for the matching nodes in the inner cache.
+func (cache *coalescingSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	return cache.writeThrough(ctx, nil, func() error {
+		return cache.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+	})
+}
+
+// GetSnapshot returns the buffered snapshot of the node, or the snapshot of the inner cache.
+func (cache *coalescingSnapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	cache.mu.Lock()
+	snapshot, ok := cache.pending[node]
+	cache.mu.Unlock()
+
+	if ok {
+		return snapshot, nil
+	}
+	return cache.SnapshotCache.GetSnapshot(node)
+}
+
+// ClearSnapshot drops the buffered snapshot of the node and clears it from the inner cache.
+func (cache *coalescingSnapshotCache) ClearSnapshot(node string) {
+	cache.writeMu.Lock()
+	defer cache.writeMu.Unlock()
+	cache.mu.Lock()
+	cache.drop(node)
+	cache.mu.Unlock()
+
+	cache.SnapshotCache.ClearSnapshot(node)
+}
+
+// BulkClearSnapshot drops the buffered snapshots of the nodes and clears them from the inner
+// cache.
+func (cache *coalescingSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cache.writeMu.Lock()
+	defer cache.writeMu.Unlock()
+	cache.mu.Lock()
+	for _, node := range nodes {
+		cache.drop(node)
+	}
+	cache.mu.Unlock()
+
+	return cache.SnapshotCache.BulkClearSnapshot(nodes)
+}
+
+// Reset drops the buffered snapshots and resets the inner cache.
+func (cache *coalescingSnapshotCache) Reset(ctx context.Context) error {
+	cache.writeMu.Lock()
+	defer cache.writeMu.Unlock()
+	cache.mu.Lock()
+	for node := range cache.pending {
+		cache.drop(node)
+	}
+	cache.mu.Unlock()
+
+	return cache.SnapshotCache.Reset(ctx)
+}
+
+// drop removes the buffered snapshot of the node and stops the timer of its window. The cache
+// mutex must be held by the caller.
+func (cache *coalescingSnapshotCache) drop(node string) {
+	if timer, ok := cache.timers[node]; ok {
+		timer.Stop()
+		delete(cache.timers, node)
+	}
+	delete(cache.pending, node)
+}
+
+// apply sets the buffered snapshot of the node in the inner cache.
+func (cache *coalescingSnapshotCache) apply(node string) {
+	cache.writeMu.Lock()
+	defer cache.writeMu.Unlock()
+
+	if err := cache.flush(context.Background(), node); err != nil {
+		cache.log.Errorf("failed to set coalesced snapshot for node %q: %v", node, err)
+	}
+}
+
+// writeThrough sets the buffered snapshots of the nodes, or of all the nodes if nodes is nil,
+// in the inner cache before it calls fn, so that a buffered snapshot is not set over the write
+// of fn when its window expires.
+func (cache *coalescingSnapshotCache) writeThrough(ctx context.Context, nodes []string, fn func() error) error {
+	cache.writeMu.Lock()
+	defer cache.writeMu.Unlock()
+
+	if nodes == nil {
+		cache.mu.Lock()
+		for node := range cache.pending {
+			nodes = append(nodes, node)
+		}
+		cache.mu.Unlock()
+	}
+	for _, node := range nodes {
+		if err := cache.flush(ctx, node); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
+
+// flush removes the buffered snapshot of the node and sets it in the inner cache. The write
+// mutex must be held by the caller.
+func (cache *coalescingSnapshotCache) flush(ctx context.Context, node string) error {
+	cache.mu.Lock()
+	snapshot, ok := cache.pending[node]
+	cache.drop(node)
+	cache.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return cache.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type configMapSnapshotCache struct {
+	SnapshotCache
+
+	client corev1client.ConfigMapInterface
+	name   string
+	log    log.Logger
+
+	// dirty requests the saving of the snapshots, coalescing the requests made while the
+	// snapshots are being saved
+	dirty chan struct{}
+}
+
+// ConfigMapBackedSnapshotCache wraps a snapshot cache to keep the snapshots of all nodes in a
+// Kubernetes ConfigMap. The snapshots in the ConfigMap are loaded into the inner cache on
+// creation, with the StaleVersionSuffix appended to their versions, and the snapshots of all
+// nodes are written back to the ConfigMap in the background whenever a snapshot is set or
+// cleared, until the context is cancelled.
+//
+// The snapshots are stored in the binary data of the ConfigMap with the proto binary encoding,
+// which is created if it does not exist. As a ConfigMap holds at most 1 MiB, this suits small
+// deployments only. A failure to write the ConfigMap is logged, and does not fail the call
+// which set the snapshot.
+//
+// Logger is optional.
+func ConfigMapBackedSnapshotCache(ctx context.Context, client corev1client.ConfigMapInterface, name string, inner SnapshotCache, logger log.Logger) (SnapshotCache, error) {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+
+	cache := &configMapSnapshotCache{
+		SnapshotCache: inner,
+		client:        client,
+		name:          name,
+		log:           logger,
+		dirty:         make(chan struct{}, 1),
+	}
+	if err := cache.load(ctx); err != nil {
+		return nil, err
+	}
+	go cache.run(ctx)
+	return cache, nil
+}
+
+// SetSnapshot sets the snapshot in the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.SetSnapshot(ctx, node, snapshot); err != nil {
+		return err
+	}
+	cache.markDirty()
+	return nil
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.WarmupSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	cache.markDirty()
+	return nil
+}
+
+// SetSnapshots sets the snapshots in the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	err := cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+	cache.markDirty()
+	return err
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *configMapSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *configMapSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// PatchSnapshot patches the snapshot in the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	if err := cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version); err != nil {
+		return err
+	}
+	cache.markDirty()
+	return nil
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	snapshot, err := cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+	if err == nil {
+		cache.markDirty()
+	}
+	return snapshot, err
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache and saves the snapshots if set.
+func (cache *configMapSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	set, err := cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+	if set {
+		cache.markDirty()
+	}
+	return set, err
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache and saves the snapshots if swapped.
+func (cache *configMapSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	swapped, err := cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+	if swapped {
+		cache.markDirty()
+	}
+	return swapped, err
+}
+
+// ClearSnapshot clears the snapshot from the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) ClearSnapshot(node string) {
+	cache.SnapshotCache.ClearSnapshot(node)
+	cache.markDirty()
+}
+
+// BulkClearSnapshot clears the snapshots from the inner cache and saves the snapshots.
+func (cache *configMapSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cleared := cache.SnapshotCache.BulkClearSnapshot(nodes)
+	cache.markDirty()
+	return cleared
+}
+
+// markDirty requests the saving of the snapshots without waiting for it.
+func (cache *configMapSnapshotCache) markDirty() {
+	select {
+	case cache.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// run saves the snapshots whenever they are marked dirty, until the context is cancelled.
+func (cache *configMapSnapshotCache) run(ctx context.Context) {
+	for {
+		select {
+		case <-cache.dirty:
+			if err := cache.save(ctx); err != nil {
+				cache.log.Errorf("failed to save snapshots to config map %q: %v", cache.name, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// load sets the snapshots in the ConfigMap in the inner cache. A missing ConfigMap is empty.
+func (cache *configMapSnapshotCache) load(ctx context.Context) error {
+	configMap, err := cache.client.Get(ctx, cache.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, data := range configMap.BinaryData {
+		node, err := configMapNode(key)
+		if err != nil {
+			cache.log.Warnf("skipping snapshot %q of config map %q: %v", key, cache.name, err)
+			continue
+		}
+		snapshot, err := UnmarshalSnapshot(data)
+		if err != nil {
+			cache.log.Warnf("skipping snapshot for node %q of config map %q: %v", node, cache.name, err)
+			continue
+		}
+
+		for i := range snapshot.Resources {
+			if snapshot.Resources[i].Version != "" {
+				snapshot.Resources[i].Version += StaleVersionSuffix
+			}
+		}
+		if err := cache.SnapshotCache.SetSnapshot(ctx, node, snapshot); err != nil {
+			cache.log.Warnf("skipping snapshot for node %q of config map %q: %v", node, cache.name, err)
+			continue
+		}
+		cache.log.Infof("loaded snapshot for node %q from config map %q", node, cache.name)
+	}
+	return nil
+}
+
+// save writes the snapshots of all nodes in the inner cache to the ConfigMap, replacing its
+// binary data.
+func (cache *configMapSnapshotCache) save(ctx context.Context) error {
+	data := make(map[string][]byte)
+	var err error
+	cache.SnapshotCache.ReadView().ForEach(func(node string, snapshot Snapshot) {
+		encoded, encodeErr := MarshalSnapshot(snapshot)
+		if encodeErr != nil {
+			err = encodeErr
+			return
+		}
+		data[configMapKey(node)] = encoded
+	})
+	if err != nil {
+		return err
+	}
+
+	configMap, err := cache.client.Get(ctx, cache.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = cache.client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cache.name},
+			BinaryData: data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	configMap.BinaryData = data
+	_, err = cache.client.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// configMapKey encodes a node ID as a ConfigMap key, which only allows alphanumerics, '-',
+// '_' and '.'.
+func configMapKey(node string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(node)) + snapshotFileExtension
+}
+
+func configMapNode(key string) (string, error) {
+	node, err := base64.RawURLEncoding.DecodeString(strings.TrimSuffix(key, snapshotFileExtension))
+	return string(node), err
+}
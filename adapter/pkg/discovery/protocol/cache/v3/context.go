@@ -0,0 +1,41 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext carries the values of its parent context, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// responseContext returns the context set in a response to a watch. The response keeps the
+// values of the context of the caller which set the snapshot, such as its trace span, so that
+// the server callbacks of the response continue the trace of the caller. The cancellation of
+// the context is not kept, as the server processes the response after the call returns.
+func responseContext(ctx context.Context) context.Context {
+	if ctx == nil || ctx == context.Background() || ctx == context.TODO() {
+		return context.Background()
+	}
+	return detachedContext{parent: ctx}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// internedResource is a resource shared by the snapshots of the nodes.
+type internedResource struct {
+	resource types.Resource
+	size     int64
+	// refs is the number of snapshot resources which refer to the resource
+	refs int
+}
+
+// resourceStore holds a single copy of the resources with the same type and content across
+// the snapshots of all nodes.
+type resourceStore struct {
+	// resources are indexed by the type URL and the hash of the resource
+	resources map[string]*internedResource
+	// keys are the keys of the resources referred to by the snapshot of each node
+	keys map[string][]string
+}
+
+func newResourceStore() *resourceStore {
+	return &resourceStore{
+		resources: make(map[string]*internedResource),
+		keys:      make(map[string][]string),
+	}
+}
+
+// WithResourceDeduplication shares a single copy of the resources with the same content
+// across the snapshots of all nodes, so that nodes with identical configuration do not hold
+// copies of the same resources. Each resource is marshaled to be hashed when it is set.
+func WithResourceDeduplication() SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.resources = newResourceStore()
+	}
+}
+
+// intern returns a copy of the snapshot of a node with each resource replaced by the shared
+// resource of the same content, and releases the resources of the previous snapshot of the
+// node. The snapshot passed in is not modified.
+func (s *resourceStore) intern(node string, snapshot Snapshot) (Snapshot, error) {
+	var keys []string
+	out := snapshot
+	for i, resources := range snapshot.Resources {
+		if len(resources.Items) == 0 {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			s.releaseKeys(keys)
+			return snapshot, err
+		}
+
+		items := make(map[string]types.ResourceWithTTL, len(resources.Items))
+		for name, item := range resources.Items {
+			marshaled, err := envoy_cache.MarshalResource(item.Resource)
+			if err != nil {
+				s.releaseKeys(keys)
+				return snapshot, err
+			}
+			key := typeURL + "/" + envoy_cache.HashResource(marshaled)
+			interned, ok := s.resources[key]
+			if !ok {
+				interned = &internedResource{resource: item.Resource, size: int64(len(marshaled))}
+				s.resources[key] = interned
+			}
+			interned.refs++
+			keys = append(keys, key)
+			items[name] = types.ResourceWithTTL{Resource: interned.resource, TTL: item.TTL}
+		}
+		out.Resources[i] = envoy_cache.Resources{Version: resources.Version, Items: items}
+	}
+
+	// the previous resources are released after the new ones are referred, so that the
+	// resources in both snapshots are kept
+	s.release(node)
+	s.keys[node] = keys
+	return out, nil
+}
+
+// release releases the resources of the snapshot of a node.
+func (s *resourceStore) release(node string) {
+	s.releaseKeys(s.keys[node])
+	delete(s.keys, node)
+}
+
+func (s *resourceStore) releaseKeys(keys []string) {
+	for _, key := range keys {
+		interned := s.resources[key]
+		interned.refs--
+		if interned.refs == 0 {
+			delete(s.resources, key)
+		}
+	}
+}
+
+// sharedBytes returns the size of the resource copies which are saved by sharing them.
+func (s *resourceStore) sharedBytes() int64 {
+	var saved int64
+	for _, interned := range s.resources {
+		saved += interned.size * int64(interned.refs-1)
+	}
+	return saved
+}
+
+// SharedBytes returns the size of the resource copies which are saved by sharing them across
+// the snapshots of the nodes, or zero if the resources are not deduplicated.
+func (cache *snapshotCache) SharedBytes() int64 {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	if cache.resources == nil {
+		return 0
+	}
+	return cache.resources.sharedBytes()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// ResourceDiff holds the names of the resources of a type which differ between two snapshots.
+// The names are sorted.
+type ResourceDiff struct {
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+// SnapshotDiff holds the resource differences between two snapshots, indexed by type URL.
+// Types without differences are not included.
+type SnapshotDiff map[string]ResourceDiff
+
+// Diff computes the resources added, modified and removed in snapshot b compared to snapshot a.
+// A resource is modified if its serialized bytes differ.
+func Diff(a, b Snapshot) SnapshotDiff {
+	diff := make(SnapshotDiff)
+	for i := range a.Resources {
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			continue
+		}
+		resourceDiff := diffResources(a.Resources[i].Items, b.Resources[i].Items)
+		if len(resourceDiff.Added)+len(resourceDiff.Modified)+len(resourceDiff.Removed) > 0 {
+			diff[typeURL] = resourceDiff
+		}
+	}
+	return diff
+}
+
+func diffResources(a, b map[string]types.ResourceWithTTL) ResourceDiff {
+	var diff ResourceDiff
+	for name, resource := range b {
+		old, exists := a[name]
+		if !exists {
+			diff.Added = append(diff.Added, name)
+		} else if !equalResources(old.Resource, resource.Resource) {
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+	for name := range a {
+		if _, exists := b[name]; !exists {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Modified)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// equalResources compares the serialized bytes of two resources. Resources which cannot be
+// serialized are reported as different.
+func equalResources(a, b types.Resource) bool {
+	aBytes, err := envoy_cache.MarshalResource(a)
+	if err != nil {
+		return false
+	}
+	bBytes, err := envoy_cache.MarshalResource(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// Empty reports whether there are no differences.
+func (diff SnapshotDiff) Empty() bool {
+	return len(diff) == 0
+}
+
+// String renders the differences with one line per type URL.
+func (diff SnapshotDiff) String() string {
+	if diff.Empty() {
+		return "no changes"
+	}
+
+	typeURLs := make([]string, 0, len(diff))
+	for typeURL := range diff {
+		typeURLs = append(typeURLs, typeURL)
+	}
+	sort.Strings(typeURLs)
+
+	var b strings.Builder
+	for _, typeURL := range typeURLs {
+		resourceDiff := diff[typeURL]
+		fmt.Fprintf(&b, "%s: added %v, modified %v, removed %v\n",
+			typeURL, resourceDiff.Added, resourceDiff.Modified, resourceDiff.Removed)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strings"
+
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+)
+
+// Drain closes all open watches so that the Envoy nodes reconnect, and rejects the watches
+// created afterwards. The response channels of the sotw watches of the types with a dedicated
+// channel are closed, which ends their streams with an unavailable error. The watches of the
+// other types share the muxed response channel of the stream, which must never be closed as
+// the server does not end the stream on it, and the delta server does not end a stream on a
+// closed channel either, so those watches are only removed.
+//
+// Drain returns once all open watches are closed, or with the context error if the context
+// expires before the cache lock is acquired.
+func (cache *snapshotCache) Drain(ctx context.Context) error {
+	return cache.drain(ctx, "")
+}
+
+// drain closes the open watches of the nodes with the node ID prefix, and rejects the watches
+// they create afterwards, as Drain does for all nodes.
+func (cache *snapshotCache) drain(ctx context.Context, prefix string) error {
+	if err := cache.lockContext(ctx); err != nil {
+		return err
+	}
+	defer cache.mu.Unlock()
+
+	if cache.draining == nil {
+		cache.draining = make(map[string]bool)
+	}
+	cache.draining[prefix] = true
+	cache.closeWatches(prefix)
+	return nil
+}
+
+// lockContext acquires the cache lock, or returns the context error if the context expires
+// before the lock is acquired.
+func (cache *snapshotCache) lockContext(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		cache.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// release the lock once it is acquired, as the caller has given up on it
+		go func() {
+			<-locked
+			cache.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// closeWatches closes the open sotw watches of the nodes with the node ID prefix and removes
+// their delta watches. The cache mutex must be held by the caller.
+func (cache *snapshotCache) closeWatches(prefix string) {
+	for node, info := range cache.status {
+		if !strings.HasPrefix(node, prefix) {
+			continue
+		}
+		cache.closeNodeWatches(node, info)
+		cache.log.Info("closed the open watches", nodeField(node))
+	}
+}
+
+// closeNodeWatches closes the open sotw watches of a node and removes its delta watches.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) closeNodeWatches(node string, info *statusInfo) {
+	info.mu.Lock()
+	// watches of the same stream may share a response channel, which must be closed once
+	closed := make(map[chan envoy_cache.Response]bool)
+	for id, watch := range info.watches {
+		if closeable(watch.Request.TypeUrl) && !closed[watch.Response] {
+			close(watch.Response)
+			closed[watch.Response] = true
+		}
+		delete(info.watches, id)
+		cache.metrics.WatchCancelled(node, watch.Request.TypeUrl)
+		cache.metrics.WatchClosed(node, watch.Request.TypeUrl)
+	}
+	for id := range info.deltaWatches {
+		delete(info.deltaWatches, id)
+	}
+	info.mu.Unlock()
+}
+
+// dedicatedChannelTypes are the types for which the sotw server creates a response channel
+// per watch, and ends the stream when the channel is closed. The watches of the other types,
+// such as ApplicationType, SubscriptionType and JWTIssuerType, are given the muxed channel
+// shared by the stream.
+var dedicatedChannelTypes = map[string]bool{
+	resource.ConfigType:                    true,
+	resource.APIType:                       true,
+	resource.SubscriptionListType:          true,
+	resource.APIListType:                   true,
+	resource.ApplicationListType:           true,
+	resource.JWTIssuerListType:             true,
+	resource.ApplicationPolicyListType:     true,
+	resource.SubscriptionPolicyListType:    true,
+	resource.ApplicationKeyMappingListType: true,
+	resource.ApplicationMappingListType:    true,
+	resource.KeyManagerType:                true,
+	resource.RevokedTokensType:             true,
+	resource.ThrottleDataType:              true,
+	resource.APKMgtApplicationType:         true,
+}
+
+// closeable reports whether the response channel of a watch for the type is dedicated to the
+// watch, so that closing it ends the stream. The shared channel of the other types must not be
+// closed, as the server would spin on it without ending the stream.
+func closeable(typeURL string) bool {
+	return dedicatedChannelTypes[typeURL]
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+)
+
+// DryRunResult describes what setting a snapshot of a node would change, returned by
+// SetSnapshotDryRun.
+type DryRunResult struct {
+	Node string `json:"node"`
+	// Diff holds the resources added, modified and removed compared to the current snapshot
+	// of the node, indexed by type URL.
+	Diff SnapshotDiff `json:"diff,omitempty"`
+	// Watches are the open watches of the node which would be responded.
+	Watches []DryRunWatch `json:"watches,omitempty"`
+}
+
+// DryRunWatch is an open watch which would be responded with a new version.
+type DryRunWatch struct {
+	WatchID int64  `json:"watch_id"`
+	TypeURL string `json:"type_url"`
+	// CurrentVersion is the version the watch was opened with.
+	CurrentVersion string `json:"current_version"`
+	Version        string `json:"version"`
+}
+
+// SetSnapshotDryRun validates the snapshot of a node as SetSnapshot does, and returns the
+// resource changes and the watches which setting it would respond to, without setting it.
+// The versions the cache would assign with automatic versioning are not predicted.
+func (cache *snapshotCache) SetSnapshotDryRun(ctx context.Context, node string, snapshot Snapshot) (DryRunResult, error) {
+	if err := ctx.Err(); err != nil {
+		return DryRunResult{}, err
+	}
+	if err := cache.validateSnapshot(node, snapshot); err != nil {
+		return DryRunResult{}, err
+	}
+	if _, err := cache.checkSnapshotSize(node, snapshot); err != nil {
+		return DryRunResult{}, err
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	current, _ := cache.snapshots.Load().get(node)
+	result := DryRunResult{Node: node, Diff: Diff(current, snapshot)}
+	responses := cache.pendingResponses(node, snapshot)
+	if len(responses) == 0 {
+		return result, nil
+	}
+
+	info := cache.status[node]
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	for _, response := range responses {
+		watch := info.watches[response.WatchID]
+		result.Watches = append(result.Watches, DryRunWatch{
+			WatchID:        response.WatchID,
+			TypeURL:        watch.Request.TypeUrl,
+			CurrentVersion: watch.Request.VersionInfo,
+			Version:        response.Version,
+		})
+	}
+	return result, nil
+}
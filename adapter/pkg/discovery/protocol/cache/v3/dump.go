@@ -0,0 +1,108 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// CacheDump is the serializable state of a snapshot cache written by DumpState.
+type CacheDump struct {
+	Timestamp time.Time            `json:"timestamp"`
+	Nodes     map[string]*NodeDump `json:"nodes"`
+}
+
+// NodeDump is the serializable state of a single node in the cache.
+type NodeDump struct {
+	// Resources holds the version and resource count of the snapshot, indexed by type URL.
+	Resources                 map[string]ResourceTypeDump `json:"resources,omitempty"`
+	LastSnapshotSetTime       *time.Time                  `json:"lastSnapshotSetTime,omitempty"`
+	NumWatches                int                         `json:"numWatches"`
+	NumDeltaWatches           int                         `json:"numDeltaWatches"`
+	LastWatchRequestTime      *time.Time                  `json:"lastWatchRequestTime,omitempty"`
+	LastDeltaWatchRequestTime *time.Time                  `json:"lastDeltaWatchRequestTime,omitempty"`
+}
+
+// ResourceTypeDump is the serializable state of the resources of a type in a snapshot.
+type ResourceTypeDump struct {
+	Version       string `json:"version"`
+	ResourceCount int    `json:"resourceCount"`
+}
+
+// DumpState writes the snapshots and the status of all nodes as JSON. The open watches
+// are reported by count only, as the response channels cannot be serialized.
+func (cache *snapshotCache) DumpState(w io.Writer) error {
+	return cache.dumpState(w, "")
+}
+
+// dumpState writes the snapshots and the status of the nodes with the node ID prefix as JSON,
+// indexed by their node IDs without the prefix.
+func (cache *snapshotCache) dumpState(w io.Writer, prefix string) error {
+	cache.mu.RLock()
+	dump := CacheDump{
+		Timestamp: time.Now(),
+		Nodes:     make(map[string]*NodeDump),
+	}
+	node := func(id string) *NodeDump {
+		id = strings.TrimPrefix(id, prefix)
+		if _, ok := dump.Nodes[id]; !ok {
+			dump.Nodes[id] = &NodeDump{}
+		}
+		return dump.Nodes[id]
+	}
+
+	cache.snapshots.Load().forEach(func(id string, snapshot Snapshot) bool {
+		if !strings.HasPrefix(id, prefix) {
+			return true
+		}
+		nodeDump := node(id)
+		nodeDump.Resources = make(map[string]ResourceTypeDump)
+		for i, resources := range snapshot.Resources {
+			typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+			if err != nil || len(resources.Items) == 0 {
+				continue
+			}
+			nodeDump.Resources[typeURL] = ResourceTypeDump{Version: resources.Version, ResourceCount: len(resources.Items)}
+		}
+		if setTime, ok := cache.setTime(id); ok {
+			nodeDump.LastSnapshotSetTime = &setTime
+		}
+		return true
+	})
+
+	for id, info := range cache.status {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		nodeDump := node(id)
+		nodeDump.NumWatches, nodeDump.NumDeltaWatches = info.WatchCount()
+		if t := info.GetLastWatchRequestTime(); !t.IsZero() {
+			nodeDump.LastWatchRequestTime = &t
+		}
+		if t := info.GetLastDeltaWatchRequestTime(); !t.IsZero() {
+			nodeDump.LastDeltaWatchRequestTime = &t
+		}
+	}
+	cache.mu.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
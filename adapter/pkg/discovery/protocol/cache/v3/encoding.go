@@ -0,0 +1,158 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// MarshalSnapshot encodes a snapshot in the proto binary format. Each resource type is written
+// as a length-delimited DeltaDiscoveryResponse holding the version and the resources of the type.
+func MarshalSnapshot(snapshot Snapshot) ([]byte, error) {
+	messages, err := snapshotMessages(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, message := range messages {
+		if _, err := protodelim.MarshalTo(&buf, message); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalSnapshot decodes a snapshot encoded with MarshalSnapshot. The message types of the
+// resources must be registered in the global proto registry.
+func UnmarshalSnapshot(data []byte) (Snapshot, error) {
+	snapshot := Snapshot{}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		message := &discovery.DeltaDiscoveryResponse{}
+		if err := protodelim.UnmarshalFrom(reader, message); err != nil {
+			if errors.Is(err, io.EOF) {
+				return snapshot, nil
+			}
+			return snapshot, err
+		}
+		if err := setSnapshotMessage(&snapshot, message); err != nil {
+			return snapshot, err
+		}
+	}
+}
+
+// MarshalSnapshotJSON encodes a snapshot as a JSON array, with each resource type written as
+// a DeltaDiscoveryResponse in the protojson format.
+func MarshalSnapshotJSON(snapshot Snapshot) ([]byte, error) {
+	messages, err := snapshotMessages(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		data, err := protojson.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalSnapshotJSON decodes a snapshot encoded with MarshalSnapshotJSON. The message types
+// of the resources must be registered in the global proto registry.
+func UnmarshalSnapshotJSON(data []byte) (Snapshot, error) {
+	snapshot := Snapshot{}
+	var messages []json.RawMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return snapshot, err
+	}
+	for _, data := range messages {
+		message := &discovery.DeltaDiscoveryResponse{}
+		if err := protojson.Unmarshal(data, message); err != nil {
+			return snapshot, err
+		}
+		if err := setSnapshotMessage(&snapshot, message); err != nil {
+			return snapshot, err
+		}
+	}
+	return snapshot, nil
+}
+
+// snapshotMessages converts the resource types of a snapshot which have a version or resources
+// to DeltaDiscoveryResponse messages.
+func snapshotMessages(snapshot Snapshot) ([]*discovery.DeltaDiscoveryResponse, error) {
+	var messages []*discovery.DeltaDiscoveryResponse
+	for i, resources := range snapshot.Resources {
+		if len(resources.Items) == 0 && resources.Version == "" {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			return nil, err
+		}
+
+		message := &discovery.DeltaDiscoveryResponse{TypeUrl: typeURL, SystemVersionInfo: resources.Version}
+		for name, item := range resources.Items {
+			payload, err := anypb.New(item.Resource)
+			if err != nil {
+				return nil, err
+			}
+			r := &discovery.Resource{Name: name, Resource: payload}
+			if item.TTL != nil {
+				r.Ttl = durationpb.New(*item.TTL)
+			}
+			message.Resources = append(message.Resources, r)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// setSnapshotMessage sets the resource type of a DeltaDiscoveryResponse message in the snapshot.
+func setSnapshotMessage(snapshot *Snapshot, message *discovery.DeltaDiscoveryResponse) error {
+	index := GetResponseType(message.TypeUrl)
+	if index == wso2_types.UnknownType {
+		return errors.New("unknown resource type: " + message.TypeUrl)
+	}
+	items := make(map[string]types.ResourceWithTTL, len(message.Resources))
+	for _, r := range message.Resources {
+		resource, err := r.Resource.UnmarshalNew()
+		if err != nil {
+			return err
+		}
+		item := types.ResourceWithTTL{Resource: resource}
+		if r.Ttl != nil {
+			ttl := r.Ttl.AsDuration()
+			item.TTL = &ttl
+		}
+		items[r.Name] = item
+	}
+	snapshot.Resources[index] = envoy_cache.Resources{Version: message.SystemVersionInfo, Items: items}
+	return nil
+}
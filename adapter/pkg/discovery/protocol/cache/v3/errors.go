@@ -0,0 +1,44 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNodeNotFound matches a NodeNotFoundError with errors.Is.
+var ErrNodeNotFound = errors.New("node not found")
+
+// ErrCircuitOpen is returned by a CircuitBreakerSnapshotCache while its circuit is open.
+var ErrCircuitOpen = errors.New("circuit open: too many consecutive snapshot failures")
+
+// ErrRateLimited is returned by a RateLimitedSnapshotCache when too many calls wait to set the
+// snapshot of a node.
+var ErrRateLimited = errors.New("rate limited: too many snapshot updates waiting for the node")
+
+// NodeNotFoundError is returned by Fetch when there is no snapshot for the node of the request.
+type NodeNotFoundError struct {
+	Node string
+}
+
+func (e *NodeNotFoundError) Error() string {
+	return fmt.Sprintf("missing snapshot for %q", e.Node)
+}
+
+// Is reports whether the target is ErrNodeNotFound.
+func (e *NodeNotFoundError) Is(target error) bool {
+	return target == ErrNodeNotFound
+}
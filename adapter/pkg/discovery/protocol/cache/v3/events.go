@@ -0,0 +1,143 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// SnapshotEventType is the kind of change a snapshot event describes.
+type SnapshotEventType int
+
+// Snapshot event types
+const (
+	// SnapshotSet is published when a snapshot is set for a node.
+	SnapshotSet SnapshotEventType = iota
+	// SnapshotCleared is published when the snapshot of a node is cleared.
+	SnapshotCleared
+)
+
+// SnapshotEvent describes a change of the snapshot of a node.
+type SnapshotEvent struct {
+	Type SnapshotEventType
+	Node string
+	// Snapshot is the new snapshot of the node, or nil if the snapshot was cleared.
+	Snapshot  *Snapshot
+	Timestamp time.Time
+}
+
+// EventBus delivers snapshot events to subscribed handlers.
+type EventBus interface {
+	// Subscribe registers a handler which is called for every event published
+	// after the subscription, and returns a function which unsubscribes the handler.
+	Subscribe(handler func(event SnapshotEvent)) func()
+
+	// Publish delivers an event to all subscribers. It must not block the caller.
+	Publish(event SnapshotEvent)
+
+	// Close unsubscribes all the handlers. The events published after Close are dropped.
+	Close()
+}
+
+type eventBus struct {
+	bufferSize  int
+	log         log.Logger
+	metrics     Metrics
+	subscribers map[int]chan SnapshotEvent
+	nextID      int
+	closed      bool
+	mu          sync.RWMutex
+}
+
+// NewEventBus creates an event bus which calls each handler asynchronously in its own
+// goroutine, until the handler is unsubscribed or the bus is closed. Every subscriber has a
+// buffer of bufferSize events, and events published while the buffer of a slow subscriber is
+// full are dropped for that subscriber. Each dropped event is logged and recorded in the
+// metrics.
+//
+// Logger and metrics are optional.
+func NewEventBus(bufferSize int, logger log.Logger, metrics Metrics) EventBus {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	return &eventBus{
+		bufferSize:  bufferSize,
+		log:         logger,
+		metrics:     metrics,
+		subscribers: make(map[int]chan SnapshotEvent),
+	}
+}
+
+// Subscribe starts a goroutine delivering the published events to the handler. The goroutine
+// ends once the handler is unsubscribed and the buffered events are delivered.
+func (bus *eventBus) Subscribe(handler func(event SnapshotEvent)) func() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.closed {
+		return func() {}
+	}
+
+	events := make(chan SnapshotEvent, bus.bufferSize)
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+
+	id := bus.nextID
+	bus.nextID++
+	bus.subscribers[id] = events
+	return func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		if events, ok := bus.subscribers[id]; ok {
+			delete(bus.subscribers, id)
+			close(events)
+		}
+	}
+}
+
+// Publish queues the event for every subscriber without blocking.
+func (bus *eventBus) Publish(event SnapshotEvent) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	for _, events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+			bus.log.Warnf("dropping snapshot event for node %q: subscriber buffer is full", event.Node)
+			bus.metrics.SnapshotEventDropped(event.Node)
+		}
+	}
+}
+
+// Close unsubscribes all the handlers.
+func (bus *eventBus) Close() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for id, events := range bus.subscribers {
+		delete(bus.subscribers, id)
+		close(events)
+	}
+	bus.closed = true
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import "strings"
+
+// federatedNamePrefix is the scheme of the federated xDS resource names, which have the form
+// xdstp://{authority}/{resource type}/{id}.
+const federatedNamePrefix = "xdstp://"
+
+// WithFederation enables matching federated resource names in the requests against the
+// resource names of the snapshots. The authority and the resource type are stripped from a
+// requested xdstp:// name, along with any context parameters, so that the remaining id is
+// matched with the snapshot.
+func WithFederation(enabled bool) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.federation = enabled
+	}
+}
+
+// stripAuthority returns the id of a federated resource name. Other names are returned as is.
+func stripAuthority(name string) string {
+	if !strings.HasPrefix(name, federatedNamePrefix) {
+		return name
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, federatedNamePrefix), "/", 3)
+	if len(parts) < 3 {
+		return name
+	}
+	id, _, _ := strings.Cut(parts[2], "?")
+	return id
+}
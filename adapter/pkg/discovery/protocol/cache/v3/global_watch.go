@@ -0,0 +1,90 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// GlobalWatchEvent is sent to the global watches when the resources of a type change in the
+// snapshot of any node.
+type GlobalWatchEvent struct {
+	Node     string
+	Response envoy_cache.Response
+}
+
+// globalWatch is an open watch on a type across all nodes, or across the nodes of the
+// namespace with the node ID prefix.
+type globalWatch struct {
+	prefix  string
+	typeURL string
+	value   chan GlobalWatchEvent
+}
+
+// CreateGlobalWatch opens a watch on a type across all nodes, for admin tooling. An event
+// is sent each time a snapshot is set for a node with a new version of the type. The watch
+// stays open until it is cancelled.
+//
+// The events are sent without blocking, so that a slow reader cannot delay the responses to
+// the Envoy watches. An event is dropped if the value channel is full.
+func (cache *snapshotCache) CreateGlobalWatch(typeURL string, value chan GlobalWatchEvent) func() {
+	return cache.createGlobalWatch("", typeURL, value)
+}
+
+// createGlobalWatch opens a watch on a type across the nodes with the node ID prefix. The
+// events are sent with the node IDs without the prefix.
+func (cache *snapshotCache) createGlobalWatch(prefix string, typeURL string, value chan GlobalWatchEvent) func() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	watchID := cache.nextWatchID()
+	cache.log.Debug("open global watch", watchField(watchID), typeField(typeURL))
+	cache.globalWatches[watchID] = globalWatch{prefix: prefix, typeURL: typeURL, value: value}
+
+	return func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		delete(cache.globalWatches, watchID)
+	}
+}
+
+// notifyGlobalWatches sends the resources of the snapshot to the global watches of the types
+// whose version differs from the previous snapshot of the node. The cache mutex must be held
+// by the caller.
+func (cache *snapshotCache) notifyGlobalWatches(node string, previous, snapshot Snapshot) {
+	for id, watch := range cache.globalWatches {
+		if !strings.HasPrefix(node, watch.prefix) {
+			continue
+		}
+		version := snapshot.GetVersion(watch.typeURL)
+		if version == previous.GetVersion(watch.typeURL) {
+			continue
+		}
+		request := &envoy_cache.Request{Node: &core.Node{Id: strings.TrimPrefix(node, watch.prefix)}, TypeUrl: watch.typeURL}
+		event := GlobalWatchEvent{
+			Node:     request.Node.Id,
+			Response: createResponse(context.Background(), request, snapshot.GetResourcesAndTTL(watch.typeURL), version, false, false, cache.ordering),
+		}
+		select {
+		case watch.value <- event:
+		default:
+			cache.log.Warn("dropping event for global watch as its channel is full", nodeField(node), watchField(id), typeField(watch.typeURL))
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthWatchInterval is how often the status is checked for the health watch streams.
+const healthWatchInterval = time.Second
+
+// HealthChecker wraps a snapshot cache to report its health with the gRPC health protocol.
+// The status is NOT_SERVING if the circuit of a wrapped CircuitBreakerSnapshotCache is open,
+// UNKNOWN if the last call to a mutator of the snapshots failed, NOT_SERVING if the cache has
+// no snapshots, and SERVING otherwise.
+//
+// The snapshots must be set through the HealthChecker for the failures to be tracked.
+//
+// The HealthChecker is a grpc_health_v1.HealthServer, and is registered on the xDS gRPC server
+// for the adapter.internal.EnforcerXdsCacheService by the health.StandardServer.
+type HealthChecker struct {
+	SnapshotCache
+	grpc_health_v1.UnimplementedHealthServer
+
+	lastErr error
+	mu      sync.RWMutex
+}
+
+var _ grpc_health_v1.HealthServer = &HealthChecker{}
+
+// NewHealthChecker creates a health checker for the snapshot cache.
+func NewHealthChecker(cache SnapshotCache) *HealthChecker {
+	return &HealthChecker{SnapshotCache: cache}
+}
+
+// SetSnapshot sets the snapshot in the wrapped cache and records the result.
+func (h *HealthChecker) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	err := h.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+	h.record(err)
+	return err
+}
+
+// SetSnapshots sets the snapshots in the wrapped cache and records the result.
+func (h *HealthChecker) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	err := h.SnapshotCache.SetSnapshots(ctx, snapshots)
+	h.record(err)
+	return err
+}
+
+// WarmupSnapshot warms up the snapshot in the wrapped cache and records the result.
+func (h *HealthChecker) WarmupSnapshot(node string, snapshot Snapshot) error {
+	err := h.SnapshotCache.WarmupSnapshot(node, snapshot)
+	h.record(err)
+	return err
+}
+
+// SetSnapshotForNodes sets the snapshot for the nodes in the wrapped cache and records the result.
+func (h *HealthChecker) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	err := h.SnapshotCache.SetSnapshotForNodes(ctx, nodes, snapshot)
+	h.record(err)
+	return err
+}
+
+// SetSnapshotForSelector sets the snapshot for the selector in the wrapped cache and records
+// the result.
+func (h *HealthChecker) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	err := h.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+	h.record(err)
+	return err
+}
+
+// SetSnapshotWithAudit sets the snapshot with an audit entry in the wrapped cache and records
+// the result.
+func (h *HealthChecker) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	err := h.SnapshotCache.SetSnapshotWithAudit(ctx, node, snapshot, requestedBy)
+	h.record(err)
+	return err
+}
+
+// SetSnapshotVariant sets the variant in the wrapped cache and records the result.
+func (h *HealthChecker) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	err := h.SnapshotCache.SetSnapshotVariant(ctx, node, variant, snapshot)
+	h.record(err)
+	return err
+}
+
+// PatchSnapshot patches the snapshot in the wrapped cache and records the result.
+func (h *HealthChecker) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	err := h.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+	h.record(err)
+	return err
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the wrapped cache and records the result.
+func (h *HealthChecker) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	swapped, err := h.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+	h.record(err)
+	return swapped, err
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the wrapped cache if the node has none, and records
+// the result.
+func (h *HealthChecker) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	set, err := h.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+	h.record(err)
+	return set, err
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the wrapped cache and records the result.
+func (h *HealthChecker) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	snapshot, err := h.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+	h.record(err)
+	return snapshot, err
+}
+
+func (h *HealthChecker) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastErr = err
+}
+
+// Status returns the current serving status of the cache.
+func (h *HealthChecker) Status() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	h.mu.RLock()
+	lastErr := h.lastErr
+	h.mu.RUnlock()
+
+	if breaker, ok := h.SnapshotCache.(circuitBreaker); ok && breaker.CircuitState() == CircuitOpen {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	if lastErr != nil {
+		return grpc_health_v1.HealthCheckResponse_UNKNOWN
+	}
+	if len(h.ListNodes()) == 0 {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// Check responds with the current serving status of the cache.
+func (h *HealthChecker) Check(context.Context, *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	return &grpc_health_v1.HealthCheckResponse{Status: h.Status()}, nil
+}
+
+// Watch sends the current serving status of the cache, and then each change of the status
+// until the stream ends.
+func (h *HealthChecker) Watch(_ *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	t := time.NewTicker(healthWatchInterval)
+	defer t.Stop()
+
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if status := h.Status(); status != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+			last = status
+		}
+		select {
+		case <-t.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
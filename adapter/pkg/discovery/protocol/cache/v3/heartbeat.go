@@ -0,0 +1,215 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+// HeartbeatingSnapshotCache is a snapshot cache which sends periodic heartbeat responses,
+// returned by NewSnapshotCacheWithHeartbeating.
+type HeartbeatingSnapshotCache interface {
+	SnapshotCache
+
+	// SetHeartbeatInterval changes how often the heartbeats are sent. The heartbeats scheduled
+	// later than the new interval from the call are brought forward to it. The interval must
+	// be positive.
+	SetHeartbeatInterval(interval time.Duration)
+}
+
+type heartbeatingSnapshotCache struct {
+	*snapshotCache
+
+	// intervals passes the new heartbeat intervals to the heartbeat routine
+	intervals chan time.Duration
+	// done is closed when the heartbeating context is cancelled, which stops the routine
+	done <-chan struct{}
+	// pending are the nodes whose snapshots were set, or which opened watches, since the
+	// heartbeat routine last rescheduled them
+	pending   map[string]struct{}
+	pendingMu sync.Mutex
+	// wake tells the heartbeat routine that nodes are pending
+	wake chan struct{}
+}
+
+// SetHeartbeatInterval sends the interval to the heartbeat routine, which resets its timer
+// to the interval. It returns without effect once the heartbeating context is cancelled.
+func (cache *heartbeatingSnapshotCache) SetHeartbeatInterval(interval time.Duration) {
+	select {
+	case cache.intervals <- interval:
+	case <-cache.done:
+	}
+}
+
+// CreateWatch creates the watch, and schedules the node so that a node connecting after its
+// snapshot is set is scheduled for the TTLs of the snapshot.
+func (cache *heartbeatingSnapshotCache) CreateWatch(request *envoy_cache.Request, state stream.StreamState, value chan envoy_cache.Response) func() {
+	cancel := cache.snapshotCache.CreateWatch(request, state, value)
+	cache.schedule(cache.nodeID(request.Node))
+	return cancel
+}
+
+// schedule adds the node to the pending nodes, which the heartbeat routine reschedules for the
+// TTLs of their snapshots. It is called by the snapshot cache for each snapshot set, with the
+// cache mutex held, so it never blocks: the nodes pending more than once are rescheduled once.
+func (cache *heartbeatingSnapshotCache) schedule(node string) {
+	cache.pendingMu.Lock()
+	cache.pending[node] = struct{}{}
+	cache.pendingMu.Unlock()
+
+	select {
+	case cache.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takePending removes and returns the pending nodes.
+func (cache *heartbeatingSnapshotCache) takePending() map[string]struct{} {
+	cache.pendingMu.Lock()
+	defer cache.pendingMu.Unlock()
+
+	pending := cache.pending
+	cache.pending = make(map[string]struct{})
+	return pending
+}
+
+// run sends the heartbeats of the nodes as they are due, until the context is cancelled.
+func (cache *heartbeatingSnapshotCache) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	deadline := time.Now().Add(interval)
+	reset := func(next time.Time) {
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		deadline = next
+		t.Reset(time.Until(next))
+	}
+
+	for {
+		select {
+		case interval = <-cache.intervals:
+			next := time.Now().Add(interval)
+			cache.bringForward(next)
+			reset(next)
+			cache.log.Info("changed the heartbeat interval", Field{Key: "interval", Value: interval})
+		case <-cache.wake:
+			for node := range cache.takePending() {
+				if due, ok := cache.reschedule(node, interval); ok && due.Before(deadline) {
+					reset(due)
+				}
+			}
+		case now := <-t.C:
+			deadline = cache.heartbeat(ctx, now, interval)
+			t.Reset(deadline.Sub(now))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reschedule brings the next heartbeat of a node forward if the TTLs of its snapshot require
+// an earlier heartbeat, and returns the time of the heartbeat if the node has one scheduled.
+func (cache *heartbeatingSnapshotCache) reschedule(node string, interval time.Duration) (time.Time, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	info, ok := cache.status[node]
+	if !ok {
+		return time.Time{}, false
+	}
+	snapshot, _ := cache.snapshots.Load().get(node)
+	due := time.Now().Add(nodeHeartbeatInterval(snapshot, interval))
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.nextHeartbeat.IsZero() || info.nextHeartbeat.After(due) {
+		info.nextHeartbeat = due
+	}
+	return info.nextHeartbeat, true
+}
+
+// bringForward moves the heartbeats of the nodes scheduled after a time to the time.
+func (cache *heartbeatingSnapshotCache) bringForward(next time.Time) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	for _, info := range cache.status {
+		info.mu.Lock()
+		if info.nextHeartbeat.After(next) {
+			info.nextHeartbeat = next
+		}
+		info.mu.Unlock()
+	}
+}
+
+// heartbeat sends the heartbeats of the nodes which are due, schedules their next heartbeats,
+// and returns the time of the earliest heartbeat, which is at most the interval from now. A
+// node without a scheduled heartbeat is due.
+func (cache *heartbeatingSnapshotCache) heartbeat(ctx context.Context, now time.Time, interval time.Duration) time.Time {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	next := now.Add(interval)
+	for node, info := range cache.status {
+		info.mu.RLock()
+		due := info.nextHeartbeat
+		info.mu.RUnlock()
+
+		if due.After(now) {
+			if due.Before(next) {
+				next = due
+			}
+			continue
+		}
+		// TODO(snowp): Omit heartbeats if a real response has been sent recently.
+		cache.sendHeartbeats(ctx, node)
+
+		snapshot, _ := cache.snapshots.Load().get(node)
+		due = now.Add(nodeHeartbeatInterval(snapshot, interval))
+		info.mu.Lock()
+		info.nextHeartbeat = due
+		info.mu.Unlock()
+		if due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// nodeHeartbeatInterval returns the heartbeat interval of a snapshot, which is half of the
+// shortest TTL of its resources if that is below the interval.
+func nodeHeartbeatInterval(snapshot Snapshot, interval time.Duration) time.Duration {
+	var ttl *time.Duration
+	for _, resources := range snapshot.Resources {
+		for _, item := range resources.Items {
+			ttl = minTTL(ttl, item.TTL)
+		}
+	}
+	if ttl != nil && *ttl/2 > 0 && *ttl/2 < interval {
+		return *ttl / 2
+	}
+	return interval
+}
+
+var _ HeartbeatingSnapshotCache = &heartbeatingSnapshotCache{}
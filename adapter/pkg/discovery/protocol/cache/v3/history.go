@@ -0,0 +1,94 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultHistorySize is the number of previous snapshots kept per node by default.
+const defaultHistorySize = 5
+
+// SnapshotHistoryEntry is a previous snapshot of a node and the time it was set.
+type SnapshotHistoryEntry struct {
+	Snapshot Snapshot
+	SetTime  time.Time
+}
+
+// ringBuffer holds the last entries added to it, up to the size given when adding.
+type ringBuffer[T any] struct {
+	entries []T
+	// next is the index the next entry is written to
+	next int
+}
+
+func (h *ringBuffer[T]) add(entry T, size int) {
+	if len(h.entries) < size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % size
+}
+
+// list returns the entries from the oldest to the newest.
+func (h *ringBuffer[T]) list() []T {
+	out := make([]T, 0, len(h.entries))
+	out = append(out, h.entries[h.next:]...)
+	return append(out, h.entries[:h.next]...)
+}
+
+// WithSnapshotHistory sets the number of previous snapshots kept per node for
+// GetSnapshotHistory. Zero disables the history. The default is 5.
+func WithSnapshotHistory(size int) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if size >= 0 {
+			cache.historySize = size
+		}
+	}
+}
+
+// GetSnapshotHistory returns the previous snapshots of a node from the oldest to the newest.
+// The current snapshot of the node is not included.
+func (cache *snapshotCache) GetSnapshotHistory(node string) ([]SnapshotHistoryEntry, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	history, ok := cache.history[node]
+	if !ok {
+		if _, exists := cache.snapshots.Load().get(node); !exists {
+			return nil, fmt.Errorf("no snapshot found for node %s", node)
+		}
+		return []SnapshotHistoryEntry{}, nil
+	}
+	return history.list(), nil
+}
+
+// recordHistory adds the current snapshot of a node to its history before it is replaced.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) recordHistory(node string) {
+	current, exists := cache.snapshots.Load().get(node)
+	if !exists || cache.historySize == 0 {
+		return
+	}
+	history, ok := cache.history[node]
+	if !ok {
+		history = &ringBuffer[SnapshotHistoryEntry]{}
+		cache.history[node] = history
+	}
+	setTime, _ := cache.setTime(node)
+	history.add(SnapshotHistoryEntry{Snapshot: current, SetTime: setTime}, cache.historySize)
+}
@@ -0,0 +1,150 @@
+//go:build integration
+
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package integration tests the snapshot cache through the xDS gRPC server, as the enforcer
+// connects to it. Run with go test -tags integration.
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/api"
+	apiservice "github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/service/api"
+	cache "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/v3"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+	server "github.com/wso2/apk/adapter/pkg/discovery/protocol/server/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	testNode    = "enforcer"
+	recvTimeout = 5 * time.Second
+)
+
+// startServer serves the cache over the xDS gRPC server on a local port, and returns a client
+// of the API discovery service connected to it.
+func startServer(t *testing.T, ctx context.Context, snapshots cache.SnapshotCache) apiservice.ApiDiscoveryServiceClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	apiservice.RegisterApiDiscoveryServiceServer(grpcServer, server.NewServer(ctx, snapshots, nil))
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return apiservice.NewApiDiscoveryServiceClient(conn)
+}
+
+func newSnapshot(t *testing.T, version string, basePaths ...string) cache.Snapshot {
+	resources := make([]types.Resource, 0, len(basePaths))
+	for _, basePath := range basePaths {
+		resources = append(resources, &api.Api{Vhost: "localhost", BasePath: basePath, Version: "v1"})
+	}
+	snapshot, err := cache.NewSnapshot(version, map[resource.Type][]types.Resource{resource.APIType: resources})
+	require.Nil(t, err)
+	return snapshot
+}
+
+// recv receives the next response of the stream, failing the test if none arrives in time.
+func recv(t *testing.T, stream apiservice.ApiDiscoveryService_StreamApisClient) *discovery.DiscoveryResponse {
+	responses := make(chan *discovery.DiscoveryResponse, 1)
+	errs := make(chan error, 1)
+	go func() {
+		response, err := stream.Recv()
+		if err != nil {
+			errs <- err
+			return
+		}
+		responses <- response
+	}()
+	select {
+	case response := <-responses:
+		return response
+	case err := <-errs:
+		t.Fatalf("failed to receive a response: %v", err)
+	case <-time.After(recvTimeout):
+		t.Fatal("no response received")
+	}
+	return nil
+}
+
+// basePaths returns the base paths of the APIs in the response.
+func basePaths(t *testing.T, response *discovery.DiscoveryResponse) []string {
+	out := make([]string, 0, len(response.Resources))
+	for _, r := range response.Resources {
+		a := &api.Api{}
+		require.Nil(t, r.UnmarshalTo(a))
+		out = append(out, a.BasePath)
+	}
+	return out
+}
+
+func TestStreamApis(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	client := startServer(t, ctx, snapshots)
+	require.Nil(t, snapshots.SetSnapshot(ctx, testNode, newSnapshot(t, "1", "/foo")))
+
+	stream, err := client.StreamApis(ctx)
+	require.Nil(t, err)
+	node := &core.Node{Id: testNode}
+	require.Nil(t, stream.Send(&discovery.DiscoveryRequest{Node: node, TypeUrl: resource.APIType}))
+	response := recv(t, stream)
+	assert.Equal(t, "1", response.VersionInfo)
+	assert.Equal(t, []string{"/foo"}, basePaths(t, response))
+
+	// the acknowledged version is only replaced by a new snapshot
+	require.Nil(t, stream.Send(&discovery.DiscoveryRequest{Node: node, TypeUrl: resource.APIType,
+		VersionInfo: response.VersionInfo, ResponseNonce: response.Nonce}))
+	require.Nil(t, snapshots.SetSnapshot(ctx, testNode, newSnapshot(t, "2", "/foo", "/bar")))
+	response = recv(t, stream)
+	assert.Equal(t, "2", response.VersionInfo)
+	assert.ElementsMatch(t, []string{"/foo", "/bar"}, basePaths(t, response))
+
+	// a rejected version is recorded for the node
+	require.Nil(t, stream.Send(&discovery.DiscoveryRequest{Node: node, TypeUrl: resource.APIType,
+		VersionInfo: "1", ResponseNonce: response.Nonce, ErrorDetail: &status.Status{Message: "invalid API"}}))
+	assert.Eventually(t, func() bool {
+		return snapshots.GetStatusInfo(testNode).NACKCount(resource.APIType) == 1
+	}, recvTimeout, 10*time.Millisecond)
+	assert.Equal(t, "2", snapshots.GetStatusInfo(testNode).LastNACKedVersion(resource.APIType))
+}
+
+func TestFetchApis(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshots := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
+	client := startServer(t, ctx, snapshots)
+	require.Nil(t, snapshots.SetSnapshot(ctx, testNode, newSnapshot(t, "1", "/foo")))
+
+	response, err := client.FetchApis(ctx, &discovery.DiscoveryRequest{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType})
+	require.Nil(t, err)
+	assert.Equal(t, "1", response.VersionInfo)
+	assert.Equal(t, []string{"/foo"}, basePaths(t, response))
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// EvictionPolicy selects the node evicted when a LimitedSnapshotCache exceeds its capacity.
+type EvictionPolicy int
+
+// Eviction policies
+const (
+	// LRUEviction evicts the node whose snapshot was least recently used.
+	LRUEviction EvictionPolicy = iota
+	// LFUEviction evicts the node whose snapshot was least frequently used.
+	LFUEviction
+)
+
+// nodeUsage tracks the use of the snapshot of a node. The fields are updated atomically, as the
+// snapshots are read under the read lock.
+type nodeUsage struct {
+	// lastUsed is a logical clock value, so that uses in the same instant are still ordered
+	lastUsed uint64
+	uses     uint64
+}
+
+type limitedSnapshotCache struct {
+	SnapshotCache
+
+	maxNodes int
+	policy   EvictionPolicy
+	metrics  Metrics
+
+	clock uint64
+	nodes map[string]*nodeUsage
+	// mu is held for writing while the nodes are tracked or evicted, and for reading while
+	// the snapshots are read
+	mu sync.RWMutex
+}
+
+// LimitedSnapshotCache wraps a snapshot cache to hold the snapshots of at most maxNodes nodes.
+// A node is tracked once its snapshot is set, and each set or get of the snapshot counts as a
+// use of the node. When a snapshot is set for a new node beyond the limit, another node is
+// selected by the eviction policy and cleared from the inner cache.
+//
+// Metrics is optional and records the evictions, deleting the metrics of the evicted nodes.
+func LimitedSnapshotCache(maxNodes int, evictionPolicy EvictionPolicy, inner SnapshotCache, metrics Metrics) SnapshotCache {
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	return &limitedSnapshotCache{
+		SnapshotCache: inner,
+		maxNodes:      maxNodes,
+		policy:        evictionPolicy,
+		metrics:       metrics,
+		nodes:         make(map[string]*nodeUsage),
+	}
+}
+
+// SetSnapshot sets the snapshot in the inner cache and evicts a node if the limit is exceeded.
+func (cache *limitedSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.SnapshotCache.SetSnapshot(ctx, node, snapshot); err != nil {
+		return err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return nil
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache and evicts a node if the limit is exceeded.
+func (cache *limitedSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.SnapshotCache.WarmupSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return nil
+}
+
+// SetSnapshots sets the snapshots in the inner cache and evicts nodes if the limit is exceeded.
+func (cache *limitedSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	err := cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+	for node := range snapshots {
+		cache.use(node)
+	}
+	for node := range snapshots {
+		cache.evict(node)
+	}
+	return err
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *limitedSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *limitedSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// GetSnapshot gets the snapshot from the inner cache and records the use of the node.
+func (cache *limitedSnapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	cache.mu.RLock()
+	snapshot, err := cache.SnapshotCache.GetSnapshot(node)
+	usage, tracked := cache.nodes[node]
+	if err != nil || tracked {
+		if tracked && err == nil {
+			cache.touch(usage)
+		}
+		cache.mu.RUnlock()
+		return snapshot, err
+	}
+	cache.mu.RUnlock()
+
+	// the node was set past this cache and is tracked from now on
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.use(node)
+	return snapshot, nil
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache and evicts a node if
+// the limit is exceeded.
+func (cache *limitedSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	snapshot, err := cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+	if err != nil {
+		return snapshot, err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return snapshot, nil
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache if the node has none, and evicts
+// a node if the limit is exceeded.
+func (cache *limitedSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	set, err := cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+	if !set {
+		return set, err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return true, nil
+}
+
+// PatchSnapshot patches the snapshot in the inner cache and evicts a node if the limit is
+// exceeded.
+func (cache *limitedSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version); err != nil {
+		return err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return nil
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache and evicts a node if the limit
+// is exceeded.
+func (cache *limitedSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	swapped, err := cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+	if !swapped {
+		return swapped, err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return true, nil
+}
+
+// SetSnapshotVariant sets the variant in the inner cache, which counts as a use of the node,
+// and evicts a node if the limit is exceeded.
+func (cache *limitedSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.SnapshotCache.SetSnapshotVariant(ctx, node, variant, snapshot); err != nil {
+		return err
+	}
+	cache.use(node)
+	cache.evict(node)
+	return nil
+}
+
+// SetSnapshotForSelector sets the snapshot for the selector in the inner cache. The snapshot
+// is served to the matching nodes without a snapshot of their own, and is not stored for
+// them, so no node is tracked.
+func (cache *limitedSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+}
+
+// ClearSnapshot clears the node from the inner cache and stops tracking it.
+func (cache *limitedSnapshotCache) ClearSnapshot(node string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.SnapshotCache.ClearSnapshot(node)
+	delete(cache.nodes, node)
+}
+
+// BulkClearSnapshot clears the nodes from the inner cache and stops tracking them.
+func (cache *limitedSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cleared := cache.SnapshotCache.BulkClearSnapshot(nodes)
+	for _, node := range nodes {
+		delete(cache.nodes, node)
+	}
+	return cleared
+}
+
+// use records a use of the node and tracks it if it is new. The cache mutex must be held for
+// writing by the caller.
+func (cache *limitedSnapshotCache) use(node string) {
+	usage, ok := cache.nodes[node]
+	if !ok {
+		usage = &nodeUsage{}
+		cache.nodes[node] = usage
+	}
+	cache.touch(usage)
+}
+
+// touch records a use of a tracked node. The cache mutex must be held by the caller, at least
+// for reading.
+func (cache *limitedSnapshotCache) touch(usage *nodeUsage) {
+	atomic.StoreUint64(&usage.lastUsed, atomic.AddUint64(&cache.clock, 1))
+	atomic.AddUint64(&usage.uses, 1)
+}
+
+// evict clears nodes until the number of nodes is within the limit. The node which was just
+// used is never selected, so that a new node is not evicted right away under LFU.
+// The cache mutex must be held by the caller.
+func (cache *limitedSnapshotCache) evict(current string) {
+	for len(cache.nodes) > cache.maxNodes {
+		victim := ""
+		var victimUsage *nodeUsage
+		for node, usage := range cache.nodes {
+			if node == current {
+				continue
+			}
+			if victimUsage == nil || cache.less(usage, victimUsage) {
+				victim, victimUsage = node, usage
+			}
+		}
+		if victimUsage == nil {
+			return
+		}
+
+		cache.SnapshotCache.ClearSnapshot(victim)
+		delete(cache.nodes, victim)
+		cache.metrics.SnapshotEvicted(victim)
+		cache.metrics.NodeRemoved(victim)
+	}
+}
+
+// less reports whether node usage a should be evicted before b.
+func (cache *limitedSnapshotCache) less(a, b *nodeUsage) bool {
+	if cache.policy == LFUEviction && a.uses != b.uses {
+		return a.uses < b.uses
+	}
+	return a.lastUsed < b.lastUsed
+}
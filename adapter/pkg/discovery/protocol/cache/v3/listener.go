@@ -0,0 +1,158 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultListenerWorkers is the number of goroutines calling the listeners by default.
+	defaultListenerWorkers = 4
+	// defaultListenerTimeout is the time a listener is given for a change by default.
+	defaultListenerTimeout = 5 * time.Second
+	// listenerQueueSize is the number of listener calls queued for the workers, beyond which
+	// the calls are dropped.
+	listenerQueueSize = 1024
+)
+
+// SnapshotChangeListener observes the changes of the snapshots of a cache, registered with
+// SnapshotCache.RegisterListener. The methods are called asynchronously once the change is
+// made, with a context which is cancelled when the listener timeout of the cache expires.
+type SnapshotChangeListener interface {
+	// OnSet is called when the snapshot of a node is set. The previous snapshot is empty if
+	// the node had none.
+	OnSet(ctx context.Context, node string, previous, current Snapshot)
+
+	// OnClear is called when the snapshot of a node is cleared.
+	OnClear(ctx context.Context, node string)
+}
+
+// WithListenerWorkers sets the number of goroutines calling the snapshot change listeners.
+// The default is 4.
+func WithListenerWorkers(workers int) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if workers > 0 {
+			cache.listeners.workers = workers
+		}
+	}
+}
+
+// WithListenerTimeout sets the time after which the context passed to a snapshot change
+// listener is cancelled. The default is 5 seconds.
+func WithListenerTimeout(timeout time.Duration) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if timeout > 0 {
+			cache.listeners.timeout = timeout
+		}
+	}
+}
+
+// listenerPool calls the snapshot change listeners from a pool of workers, which is started
+// when the first listener is registered.
+type listenerPool struct {
+	workers int
+	timeout time.Duration
+
+	listeners     []SnapshotChangeListener
+	stateHandlers []func(nodeID string, event NodeStateEvent)
+	calls         chan func(ctx context.Context)
+	start         sync.Once
+	mu            sync.RWMutex
+}
+
+// RegisterListener registers a listener called after each change of a snapshot. The listener
+// must be comparable, such as a pointer, to be unregistered.
+func (cache *snapshotCache) RegisterListener(listener SnapshotChangeListener) {
+	pool := &cache.listeners
+	pool.startWorkers(cache.ctx.Done())
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.listeners = append(pool.listeners, listener)
+}
+
+// UnregisterListener stops calling a registered listener. The calls already queued for the
+// listener are still made.
+func (cache *snapshotCache) UnregisterListener(listener SnapshotChangeListener) {
+	pool := &cache.listeners
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for i, l := range pool.listeners {
+		if l == listener {
+			pool.listeners = append(pool.listeners[:i:i], pool.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// startWorkers starts the workers of the pool once, which run until done is closed.
+func (pool *listenerPool) startWorkers(done <-chan struct{}) {
+	pool.start.Do(func() {
+		pool.calls = make(chan func(ctx context.Context), listenerQueueSize)
+		for i := 0; i < pool.workers; i++ {
+			go pool.run(done)
+		}
+	})
+}
+
+// run makes the queued listener calls, each with a context cancelled after the timeout, until
+// done is closed.
+func (pool *listenerPool) run(done <-chan struct{}) {
+	for {
+		select {
+		case call := <-pool.calls:
+			ctx, cancel := context.WithTimeout(context.Background(), pool.timeout)
+			call(ctx)
+			cancel()
+		case <-done:
+			return
+		}
+	}
+}
+
+// notifySet queues the calls of the listeners for a snapshot set for a node.
+func (cache *snapshotCache) notifySet(node string, previous, current Snapshot) {
+	cache.notifyListeners(node, func(ctx context.Context, listener SnapshotChangeListener) {
+		listener.OnSet(ctx, node, previous, current)
+	})
+}
+
+// notifyClear queues the calls of the listeners for the snapshot cleared for a node.
+func (cache *snapshotCache) notifyClear(node string) {
+	cache.notifyListeners(node, func(ctx context.Context, listener SnapshotChangeListener) {
+		listener.OnClear(ctx, node)
+	})
+}
+
+// notifyListeners queues a call for each listener without blocking, as the cache mutex is
+// held by the callers. The calls are dropped if the queue is full.
+func (cache *snapshotCache) notifyListeners(node string, fn func(ctx context.Context, listener SnapshotChangeListener)) {
+	pool := &cache.listeners
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	for _, listener := range pool.listeners {
+		listener := listener
+		select {
+		case pool.calls <- func(ctx context.Context) { fn(ctx, listener) }:
+		default:
+			cache.log.Warn("dropping snapshot change for a listener as the queue is full", nodeField(node))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// The keys of the fields logged by the snapshot cache.
+const (
+	FieldNodeID        = "node_id"
+	FieldTypeURL       = "type_url"
+	FieldVersion       = "version"
+	FieldWatchID       = "watch_id"
+	FieldResourceNames = "resource_names"
+	FieldError         = "error"
+)
+
+// Field is a key-value pair of a structured log event.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger logs events as a message with key-value fields, so that the fields can be
+// emitted as discrete JSON fields by a structured logging backend.
+type StructuredLogger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+type formattedLogger struct {
+	logger log.Logger
+}
+
+// NewStructuredLogger adapts a format string logger to a StructuredLogger. The fields are
+// appended to the message as key=value pairs. A nil logger defaults to the default logger.
+func NewStructuredLogger(logger log.Logger) StructuredLogger {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+	return formattedLogger{logger: logger}
+}
+
+func (l formattedLogger) Debug(msg string, fields ...Field) {
+	l.logger.Debugf("%s", formatFields(msg, fields))
+}
+
+func (l formattedLogger) Info(msg string, fields ...Field) {
+	l.logger.Infof("%s", formatFields(msg, fields))
+}
+
+func (l formattedLogger) Warn(msg string, fields ...Field) {
+	l.logger.Warnf("%s", formatFields(msg, fields))
+}
+
+func (l formattedLogger) Error(msg string, fields ...Field) {
+	l.logger.Errorf("%s", formatFields(msg, fields))
+}
+
+func formatFields(msg string, fields []Field) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		if s, ok := f.Value.(string); ok {
+			fmt.Fprintf(&b, " %s=%q", f.Key, s)
+		} else {
+			fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+		}
+	}
+	return b.String()
+}
+
+// WithStructuredLogger sets the logger of the cache, replacing the logger passed to the
+// constructor. Passing nil keeps the constructor logger.
+func WithStructuredLogger(logger StructuredLogger) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if logger != nil {
+			cache.log = logger
+		}
+	}
+}
+
+func nodeField(node string) Field {
+	return Field{Key: FieldNodeID, Value: node}
+}
+
+func typeField(typeURL string) Field {
+	return Field{Key: FieldTypeURL, Value: typeURL}
+}
+
+func versionField(version string) Field {
+	return Field{Key: FieldVersion, Value: version}
+}
+
+func watchField(watchID int64) Field {
+	return Field{Key: FieldWatchID, Value: watchID}
+}
+
+func namesField(names []string) Field {
+	return Field{Key: FieldResourceNames, Value: names}
+}
+
+func errorField(err error) Field {
+	return Field{Key: FieldError, Value: err}
+}
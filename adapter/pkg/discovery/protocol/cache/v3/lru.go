@@ -0,0 +1,106 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// WithMaxNodes evicts the least recently accessed nodes as EvictLRU does whenever SetSnapshot
+// sets the snapshot of a new node beyond maxNodes nodes. The new node itself is never evicted.
+// Zero disables the limit.
+func WithMaxNodes(maxNodes int) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.maxNodes = maxNodes
+	}
+}
+
+// EvictLRU clears the snapshots and the status of the least recently accessed nodes until the
+// cache holds the snapshots of at most maxNodes nodes, and returns the number of evicted nodes.
+// A node is accessed when its snapshot is read with GetSnapshot or Fetch, or when it creates a
+// watch. The accesses are recorded once the node has created a watch, until which the node is
+// ranked by the time its snapshot was set.
+func (cache *snapshotCache) EvictLRU(maxNodes int) int {
+	return cache.evictLRUPrefix(maxNodes, "")
+}
+
+// evictLRUPrefix evicts the least recently accessed nodes with the node ID prefix until at
+// most maxNodes of them have a snapshot, as EvictLRU does for all nodes.
+func (cache *snapshotCache) evictLRUPrefix(maxNodes int, prefix string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.evictLRU(maxNodes, "", prefix)
+}
+
+// evictLRU evicts the least recently accessed nodes with the node ID prefix other than the
+// current node until the number of their snapshots is within the limit. The cache mutex must
+// be held by the caller.
+func (cache *snapshotCache) evictLRU(maxNodes int, current string, prefix string) int {
+	nodes := 0
+	cache.snapshots.Load().forEach(func(node string, _ Snapshot) bool {
+		if strings.HasPrefix(node, prefix) {
+			nodes++
+		}
+		return true
+	})
+
+	evicted := 0
+	for ; nodes > maxNodes; nodes-- {
+		victim := ""
+		var victimAccess time.Time
+		cache.snapshots.Load().forEach(func(node string, _ Snapshot) bool {
+			if node == current || !strings.HasPrefix(node, prefix) {
+				return true
+			}
+			if access := cache.lastAccessTime(node); victim == "" || access.Before(victimAccess) {
+				victim, victimAccess = node, access
+			}
+			return true
+		})
+		if victim == "" {
+			break
+		}
+
+		cache.log.Info("evicting least recently accessed node", nodeField(victim), Field{Key: "last_access_time", Value: victimAccess})
+		cache.clearSnapshot(victim)
+		cache.metrics.SnapshotEvicted(victim)
+		evicted++
+	}
+	return evicted
+}
+
+// lastAccessTime returns the time a node was last accessed, or the time its snapshot was set
+// if it has not been accessed. The cache mutex must be held by the caller.
+func (cache *snapshotCache) lastAccessTime(node string) time.Time {
+	if info, ok := cache.status[node]; ok {
+		if access := info.GetLastAccessTime(); !access.IsZero() {
+			return access
+		}
+	}
+	setTime, _ := cache.setTime(node)
+	return setTime
+}
+
+// recordAccess records an access of a node which has a status entry. The cache mutex must be
+// held by the caller, for reading at least.
+func (cache *snapshotCache) recordAccess(node string) {
+	if info, ok := cache.status[node]; ok {
+		info.mu.Lock()
+		info.lastAccessTime = time.Now()
+		info.mu.Unlock()
+	}
+}
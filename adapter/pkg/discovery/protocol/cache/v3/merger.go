@@ -0,0 +1,102 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// MergedVersionDelimiter separates the versions of the sources in the version of a merged
+// resource type.
+const MergedVersionDelimiter = "+"
+
+// SnapshotSource provides the snapshots of the nodes. A SnapshotCache is a snapshot source.
+type SnapshotSource interface {
+	// GetSnapshot returns the snapshot of a node, or an error if there is none.
+	GetSnapshot(node string) (Snapshot, error)
+}
+
+// SnapshotMerger combines the snapshots of a node from several sources, such as the caches
+// fed by different upstream registries. It is a snapshot source itself, so mergers can be
+// nested.
+type SnapshotMerger []SnapshotSource
+
+// GetSnapshot merges the snapshots of a node from the sources in order. A resource of a later
+// source replaces the resource with the same name and type of an earlier source, and its TTL
+// is the minimum of their TTLs. The version of each resource type is the versions of the
+// sources joined with MergedVersionDelimiter.
+//
+// The sources which return an error are skipped, and their errors are returned only if none
+// of the sources has a snapshot of the node.
+func (m SnapshotMerger) GetSnapshot(node string) (Snapshot, error) {
+	var out Snapshot
+	var versions [len(out.Resources)][]string
+	var errs []error
+	found := false
+	for _, source := range m {
+		snapshot, err := source.GetSnapshot(node)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found = true
+
+		for i, resources := range snapshot.Resources {
+			if resources.Version != "" {
+				versions[i] = append(versions[i], resources.Version)
+			}
+			if len(resources.Items) == 0 {
+				continue
+			}
+			if out.Resources[i].Items == nil {
+				out.Resources[i].Items = make(map[string]types.ResourceWithTTL, len(resources.Items))
+			}
+			for name, item := range resources.Items {
+				if previous, ok := out.Resources[i].Items[name]; ok {
+					item.TTL = minTTL(previous.TTL, item.TTL)
+				}
+				out.Resources[i].Items[name] = item
+			}
+		}
+	}
+	if !found {
+		errs = append([]error{fmt.Errorf("no snapshot found for node %s in %d sources", node, len(m))}, errs...)
+		return Snapshot{}, errors.Join(errs...)
+	}
+
+	for i := range out.Resources {
+		out.Resources[i] = envoy_cache.Resources{
+			Version: strings.Join(versions[i], MergedVersionDelimiter),
+			Items:   out.Resources[i].Items,
+		}
+	}
+	return out, nil
+}
+
+// minTTL returns the shorter TTL, where a nil TTL never expires.
+func minTTL(a, b *time.Duration) *time.Duration {
+	if a == nil || (b != nil && *b < *a) {
+		return b
+	}
+	return a
+}
+
+var _ SnapshotSource = SnapshotMerger{}
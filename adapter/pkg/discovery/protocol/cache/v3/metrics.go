@@ -0,0 +1,224 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records the watch lifecycle events of a snapshot cache.
+type Metrics interface {
+	// WatchOpened is called when an open watch is registered for a node.
+	WatchOpened(node string, typeURL string)
+
+	// WatchClosed is called when an open watch is removed from the cache, either
+	// after being responded, cancelled or cleared.
+	WatchClosed(node string, typeURL string)
+
+	// WatchCancelled is called when an open watch is cancelled by the server.
+	WatchCancelled(node string, typeURL string)
+
+	// WatchResponded is called when a response is sent to a watch.
+	WatchResponded(node string, typeURL string)
+
+	// WatchDuration is called with the time an open watch was open for, when it is
+	// removed from the cache after being responded.
+	WatchDuration(node string, typeURL string, duration time.Duration)
+
+	// SnapshotEvicted is called when the snapshot of a node is evicted from the cache.
+	SnapshotEvicted(node string)
+
+	// SnapshotUpdateSkipped is called when a snapshot set for a node is skipped, as it is
+	// equal to the current snapshot of the node.
+	SnapshotUpdateSkipped(node string)
+
+	// NACKReceived is called when a node rejects the last response of a type with an error.
+	NACKReceived(node string, typeURL string)
+
+	// SnapshotEventDropped is called when a snapshot event of a node is dropped for a
+	// subscriber of an event bus, as the buffer of the subscriber is full.
+	SnapshotEventDropped(node string)
+
+	// SnapshotMemoryUsage is called with the sum of the sizes of the snapshots of all nodes
+	// in bytes, whenever a snapshot is set or cleared.
+	SnapshotMemoryUsage(bytes int64)
+
+	// NodeRemoved is called when a node is removed from the cache, either as its snapshot is
+	// cleared or evicted, or as its status is garbage collected, so that the metrics of the
+	// node can be deleted.
+	NodeRemoved(node string)
+}
+
+// nopMetrics is used when the cache is created without metrics.
+type nopMetrics struct{}
+
+func (nopMetrics) WatchOpened(string, string)                  {}
+func (nopMetrics) WatchClosed(string, string)                  {}
+func (nopMetrics) WatchCancelled(string, string)               {}
+func (nopMetrics) WatchResponded(string, string)               {}
+func (nopMetrics) WatchDuration(string, string, time.Duration) {}
+func (nopMetrics) SnapshotEvicted(string)                      {}
+func (nopMetrics) SnapshotUpdateSkipped(string)                {}
+func (nopMetrics) NACKReceived(string, string)                 {}
+func (nopMetrics) SnapshotEventDropped(string)                 {}
+func (nopMetrics) SnapshotMemoryUsage(int64)                   {}
+func (nopMetrics) NodeRemoved(string)                          {}
+
+var _ Metrics = nopMetrics{}
+
+// PrometheusMetrics is a Metrics implementation backed by prometheus collectors.
+type PrometheusMetrics struct {
+	watchesOpened    *prometheus.CounterVec
+	watchesCancelled *prometheus.CounterVec
+	watchesResponded *prometheus.CounterVec
+	openWatches      *prometheus.GaugeVec
+	watchDurations   *prometheus.HistogramVec
+	nacks            *prometheus.CounterVec
+	evictions        prometheus.Counter
+	skippedUpdates   prometheus.Counter
+	droppedEvents    prometheus.Counter
+	memoryUsage      prometheus.Gauge
+}
+
+// NewPrometheusMetrics creates the snapshot cache collectors and registers them in the
+// provided registerer. The namespace is used as the prefix of the metric names.
+func NewPrometheusMetrics(namespace string, registerer prometheus.Registerer) (*PrometheusMetrics, error) {
+	labels := []string{"node", "type_url"}
+	m := &PrometheusMetrics{
+		watchesOpened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_watches_opened_total",
+			Help:      "Number of open watches created in the snapshot cache.",
+		}, labels),
+		watchesCancelled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_watches_cancelled_total",
+			Help:      "Number of open watches cancelled before a response was sent.",
+		}, labels),
+		watchesResponded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_watches_responded_total",
+			Help:      "Number of responses sent to watches by the snapshot cache.",
+		}, labels),
+		openWatches: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_open_watches",
+			Help:      "Number of currently open watches in the snapshot cache.",
+		}, labels),
+		watchDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_watch_open_duration_seconds",
+			Help:      "Time the watches were open in the snapshot cache before being responded.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600},
+		}, labels),
+		nacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_nacks_total",
+			Help:      "Number of responses rejected by the nodes with an error.",
+		}, labels),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_snapshots_evicted_total",
+			Help:      "Number of node snapshots evicted from the snapshot cache.",
+		}),
+		skippedUpdates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_skipped_updates_total",
+			Help:      "Number of snapshot updates skipped as the snapshot was unchanged.",
+		}),
+		droppedEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_snapshot_events_dropped_total",
+			Help:      "Number of snapshot events dropped for the slow subscribers of an event bus.",
+		}),
+		memoryUsage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "xds_cache_snapshot_memory_bytes",
+			Help:      "Sum of the encoded sizes of the snapshots of all nodes in the snapshot cache.",
+		}),
+	}
+	for _, collector := range []prometheus.Collector{m.watchesOpened, m.watchesCancelled, m.watchesResponded, m.openWatches, m.watchDurations, m.nacks, m.evictions, m.skippedUpdates, m.droppedEvents, m.memoryUsage} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WatchOpened increments the opened watch counter and the open watch gauge.
+func (m *PrometheusMetrics) WatchOpened(node string, typeURL string) {
+	m.watchesOpened.WithLabelValues(node, typeURL).Inc()
+	m.openWatches.WithLabelValues(node, typeURL).Inc()
+}
+
+// WatchClosed decrements the open watch gauge.
+func (m *PrometheusMetrics) WatchClosed(node string, typeURL string) {
+	m.openWatches.WithLabelValues(node, typeURL).Dec()
+}
+
+// WatchCancelled increments the cancelled watch counter.
+func (m *PrometheusMetrics) WatchCancelled(node string, typeURL string) {
+	m.watchesCancelled.WithLabelValues(node, typeURL).Inc()
+}
+
+// WatchResponded increments the responded watch counter.
+func (m *PrometheusMetrics) WatchResponded(node string, typeURL string) {
+	m.watchesResponded.WithLabelValues(node, typeURL).Inc()
+}
+
+// WatchDuration observes the open duration of the watch in seconds.
+func (m *PrometheusMetrics) WatchDuration(node string, typeURL string, duration time.Duration) {
+	m.watchDurations.WithLabelValues(node, typeURL).Observe(duration.Seconds())
+}
+
+// NACKReceived increments the NACK counter.
+func (m *PrometheusMetrics) NACKReceived(node string, typeURL string) {
+	m.nacks.WithLabelValues(node, typeURL).Inc()
+}
+
+// SnapshotEvicted increments the evicted snapshot counter.
+func (m *PrometheusMetrics) SnapshotEvicted(string) {
+	m.evictions.Inc()
+}
+
+// SnapshotUpdateSkipped increments the skipped update counter.
+func (m *PrometheusMetrics) SnapshotUpdateSkipped(string) {
+	m.skippedUpdates.Inc()
+}
+
+// SnapshotEventDropped increments the dropped event counter.
+func (m *PrometheusMetrics) SnapshotEventDropped(string) {
+	m.droppedEvents.Inc()
+}
+
+// SnapshotMemoryUsage sets the snapshot memory gauge.
+func (m *PrometheusMetrics) SnapshotMemoryUsage(bytes int64) {
+	m.memoryUsage.Set(float64(bytes))
+}
+
+// NodeRemoved deletes the series of the node from the collectors labelled with the node.
+func (m *PrometheusMetrics) NodeRemoved(node string) {
+	labels := prometheus.Labels{"node": node}
+	m.watchesOpened.DeletePartialMatch(labels)
+	m.watchesCancelled.DeletePartialMatch(labels)
+	m.watchesResponded.DeletePartialMatch(labels)
+	m.openWatches.DeletePartialMatch(labels)
+	m.watchDurations.DeletePartialMatch(labels)
+	m.nacks.DeletePartialMatch(labels)
+}
+
+var _ Metrics = &PrometheusMetrics{}
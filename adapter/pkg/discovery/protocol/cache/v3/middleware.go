@@ -0,0 +1,91 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Middleware wraps a snapshot cache with additional behaviour. The returned cache usually
+// embeds the wrapped cache and overrides only the methods it needs.
+type Middleware func(SnapshotCache) SnapshotCache
+
+// NewMiddlewareChain wraps the base cache with the middlewares. The first middleware is the
+// outermost, so it is the first to see each call.
+func NewMiddlewareChain(base SnapshotCache, middlewares ...Middleware) SnapshotCache {
+	cache := base
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		cache = middlewares[i](cache)
+	}
+	return cache
+}
+
+// PassthroughMiddleware returns the wrapped cache unchanged. It is useful as a placeholder
+// in tests.
+func PassthroughMiddleware(next SnapshotCache) SnapshotCache {
+	return next
+}
+
+type prometheusMiddleware struct {
+	SnapshotCache
+
+	durations *prometheus.HistogramVec
+}
+
+// NewPrometheusMiddleware creates a middleware which records the duration of the SetSnapshot
+// and GetSnapshot calls, and registers the collector in the provided registerer. The namespace
+// is used as the prefix of the metric name.
+func NewPrometheusMiddleware(namespace string, registerer prometheus.Registerer) (Middleware, error) {
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "xds_cache_operation_duration_seconds",
+		Help:      "Duration of the snapshot cache operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "success"})
+	if err := registerer.Register(durations); err != nil {
+		return nil, err
+	}
+
+	return func(next SnapshotCache) SnapshotCache {
+		return &prometheusMiddleware{SnapshotCache: next, durations: durations}
+	}, nil
+}
+
+// SetSnapshot records the duration of setting the snapshot in the wrapped cache.
+func (m *prometheusMiddleware) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	start := time.Now()
+	err := m.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+	m.observe("SetSnapshot", start, err)
+	return err
+}
+
+// GetSnapshot records the duration of getting the snapshot from the wrapped cache.
+func (m *prometheusMiddleware) GetSnapshot(node string) (Snapshot, error) {
+	start := time.Now()
+	snapshot, err := m.SnapshotCache.GetSnapshot(node)
+	m.observe("GetSnapshot", start, err)
+	return snapshot, err
+}
+
+func (m *prometheusMiddleware) observe(operation string, start time.Time, err error) {
+	success := "true"
+	if err != nil {
+		success = "false"
+	}
+	m.durations.WithLabelValues(operation, success).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// namespaceScoper scopes the methods of a snapshot cache which apply to all nodes to the nodes
+// with a node ID prefix. It is implemented by the caches created by NewSnapshotCache.
+type namespaceScoper interface {
+	registerNamespace(prefix string)
+	reset(ctx context.Context, prefix string) error
+	drain(ctx context.Context, prefix string) error
+	dumpState(w io.Writer, prefix string) error
+	stats(prefix string) SnapshotCacheStats
+	memoryUsageBytes(prefix string) int64
+	evictLRUPrefix(maxNodes int, prefix string) int
+	garbageCollectStatus(prefix string) int
+	setNodeHash(prefix string, hash NodeHash)
+	ready(prefix string) bool
+	setSnapshotForSelector(ctx context.Context, prefix string, selector map[string]string, snapshot Snapshot) error
+	createGlobalWatch(prefix string, typeURL string, value chan GlobalWatchEvent) func()
+}
+
+type namespacedSnapshotCache struct {
+	*regionScopedSnapshotCache
+
+	scoper namespaceScoper
+}
+
+// NamespacedSnapshotCache wraps a snapshot cache to isolate the nodes of a tenant namespace
+// from the nodes of the other tenants sharing the inner cache, even if their node IDs are the
+// same. The node IDs are scoped to the namespace as RegionScopedSnapshotCache scopes them to a
+// region, for every method taking a node ID or an xDS request.
+//
+// Unlike a region, the namespace is isolated for the methods which apply to all nodes as well.
+// Reset, Drain, DumpState, Stats, MemoryUsageBytes, EvictLRU, GarbageCollectStatus and Ready
+// only apply to the nodes of the namespace. SetNodeHash sets the hash of the nodes of the
+// namespace, which are hashed without the namespace, with the hash of the inner cache until
+// it is set. The selector snapshots are only served to the nodes of the namespace, and the
+// global watches, the listeners and the node state handlers are only told of their changes.
+// SharedBytes returns zero, as the resources shared across the namespaces cannot be
+// attributed to one of them.
+//
+// The inner cache must be created by NewSnapshotCache or NewSnapshotCacheWithHeartbeating, so
+// that these methods can be scoped, and the namespace must not be empty or contain the
+// RegionSeparator, for StripNamespace to map the node IDs back.
+func NamespacedSnapshotCache(namespace string, inner SnapshotCache) (SnapshotCache, error) {
+	if namespace == "" || strings.Contains(namespace, RegionSeparator) {
+		return nil, fmt.Errorf("invalid namespace %q: it must not be empty or contain %q", namespace, RegionSeparator)
+	}
+	scoper, ok := inner.(namespaceScoper)
+	if !ok {
+		return nil, fmt.Errorf("cannot scope the snapshot cache %T to namespace %q", inner, namespace)
+	}
+
+	region := &regionScopedSnapshotCache{
+		SnapshotCache: inner,
+		prefix:        namespace + RegionSeparator,
+	}
+	scoper.registerNamespace(region.prefix)
+	return &namespacedSnapshotCache{
+		regionScopedSnapshotCache: region,
+		scoper:                    scoper,
+	}, nil
+}
+
+// StripNamespace returns the node ID of a node ID in the inner cache of a
+// NamespacedSnapshotCache, without its namespace. A node ID without a namespace is returned
+// as it is.
+func StripNamespace(nodeID string) string {
+	if i := strings.Index(nodeID, RegionSeparator); i >= 0 {
+		return nodeID[i+len(RegionSeparator):]
+	}
+	return nodeID
+}
+
+// registerNamespace hashes the nodes with the node ID prefix of a namespace without the
+// prefix, with the hash of the cache until the hash of the namespace is set.
+func (cache *snapshotCache) registerNamespace(prefix string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.namespaceHashes == nil {
+		cache.namespaceHashes = make(map[string]NodeHash)
+	}
+	if _, ok := cache.namespaceHashes[prefix]; !ok {
+		cache.namespaceHashes[prefix] = nil
+	}
+}
+
+// SetSnapshotForSelector sets the snapshot of the nodes of the namespace matching the selector.
+func (cache *namespacedSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	return cache.scoper.setSnapshotForSelector(ctx, cache.prefix, selector, snapshot)
+}
+
+// CreateGlobalWatch opens a watch on a type across the nodes of the namespace.
+func (cache *namespacedSnapshotCache) CreateGlobalWatch(typeURL string, value chan GlobalWatchEvent) func() {
+	return cache.scoper.createGlobalWatch(cache.prefix, typeURL, value)
+}
+
+// RegisterListener registers a listener called after each change of a snapshot of a node of
+// the namespace.
+func (cache *namespacedSnapshotCache) RegisterListener(listener SnapshotChangeListener) {
+	cache.SnapshotCache.RegisterListener(namespacedListener{prefix: cache.prefix, listener: listener})
+}
+
+// UnregisterListener stops calling a listener registered with RegisterListener.
+func (cache *namespacedSnapshotCache) UnregisterListener(listener SnapshotChangeListener) {
+	cache.SnapshotCache.UnregisterListener(namespacedListener{prefix: cache.prefix, listener: listener})
+}
+
+// Reset resets the nodes of the namespace.
+func (cache *namespacedSnapshotCache) Reset(ctx context.Context) error {
+	return cache.scoper.reset(ctx, cache.prefix)
+}
+
+// Drain closes the open watches of the nodes of the namespace, and rejects their new watches.
+func (cache *namespacedSnapshotCache) Drain(ctx context.Context) error {
+	return cache.scoper.drain(ctx, cache.prefix)
+}
+
+// DumpState writes the snapshots and the status of the nodes of the namespace as JSON.
+func (cache *namespacedSnapshotCache) DumpState(w io.Writer) error {
+	return cache.scoper.dumpState(w, cache.prefix)
+}
+
+// Stats returns the statistics of the nodes of the namespace. The counters of the watches
+// created and responded are only kept for the whole inner cache, and are zero.
+func (cache *namespacedSnapshotCache) Stats() SnapshotCacheStats {
+	return cache.scoper.stats(cache.prefix)
+}
+
+// MemoryUsageBytes returns the sum of the sizes of the snapshots of the nodes of the namespace.
+func (cache *namespacedSnapshotCache) MemoryUsageBytes() int64 {
+	return cache.scoper.memoryUsageBytes(cache.prefix)
+}
+
+// SharedBytes returns zero, as the resources shared across the namespaces cannot be
+// attributed to one of them.
+func (cache *namespacedSnapshotCache) SharedBytes() int64 {
+	return 0
+}
+
+// EvictLRU evicts the least recently accessed nodes of the namespace until at most maxNodes
+// of them have a snapshot.
+func (cache *namespacedSnapshotCache) EvictLRU(maxNodes int) int {
+	return cache.scoper.evictLRUPrefix(maxNodes, cache.prefix)
+}
+
+// GarbageCollectStatus removes the status of the nodes of the namespace without a snapshot or
+// open watches.
+func (cache *namespacedSnapshotCache) GarbageCollectStatus() int {
+	return cache.scoper.garbageCollectStatus(cache.prefix)
+}
+
+// SetNodeHash sets the hash of the nodes of the namespace.
+func (cache *namespacedSnapshotCache) SetNodeHash(hash NodeHash) {
+	cache.scoper.setNodeHash(cache.prefix, hash)
+}
+
+// Ready reports whether any node of the namespace has a snapshot with at least one resource.
+func (cache *namespacedSnapshotCache) Ready() bool {
+	return cache.scoper.ready(cache.prefix)
+}
+
+// namespacedListener calls a snapshot change listener for the changes of the nodes of a
+// namespace, with their node IDs without the prefix of the namespace. It is comparable if the
+// listener is, so that it can be unregistered.
+type namespacedListener struct {
+	prefix   string
+	listener SnapshotChangeListener
+}
+
+func (l namespacedListener) OnSet(ctx context.Context, node string, previous, current Snapshot) {
+	if strings.HasPrefix(node, l.prefix) {
+		l.listener.OnSet(ctx, node[len(l.prefix):], previous, current)
+	}
+}
+
+func (l namespacedListener) OnClear(ctx context.Context, node string) {
+	if strings.HasPrefix(node, l.prefix) {
+		l.listener.OnClear(ctx, node[len(l.prefix):])
+	}
+}
+
+var (
+	_ SnapshotCache   = &namespacedSnapshotCache{}
+	_ namespaceScoper = &snapshotCache{}
+)
@@ -0,0 +1,135 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+)
+
+// NodeState is the connection state of a node, derived from its sotw watches.
+type NodeState int
+
+// Node states
+const (
+	// NodeDisconnected is the state of a node with no open watch, which is the initial state.
+	NodeDisconnected NodeState = iota
+	// NodeConnected is the state of a node which opened a watch, and has not yet acknowledged
+	// every type of its snapshot.
+	NodeConnected
+	// NodeSynced is the state of a node which acknowledged the current version of every type
+	// of its snapshot.
+	NodeSynced
+	// NodeStale is the state of a synced node whose snapshot was set to versions it has not
+	// acknowledged yet, or which rejected a response.
+	NodeStale
+)
+
+func (state NodeState) String() string {
+	switch state {
+	case NodeDisconnected:
+		return "disconnected"
+	case NodeConnected:
+		return "connected"
+	case NodeSynced:
+		return "synced"
+	case NodeStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// NodeStateEvent is a transition of a node to another state.
+type NodeStateEvent struct {
+	State     NodeState
+	Previous  NodeState
+	Timestamp time.Time
+}
+
+// SubscribeNodeState registers a handler called asynchronously, from the snapshot change
+// listener workers, for each state transition of a node.
+//
+// A node is connected by its first watch and disconnected once its last open watch is
+// cancelled or reaped. The watches responded by the cache do not disconnect the node, as
+// it requests the type again. Only the sotw watches are tracked.
+func (cache *snapshotCache) SubscribeNodeState(handler func(nodeID string, event NodeStateEvent)) {
+	pool := &cache.listeners
+	pool.startWorkers(cache.ctx.Done())
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.stateHandlers = append(pool.stateHandlers, handler)
+}
+
+// setNodeState transitions a node to a state and queues the calls of the node state
+// handlers. The status info mutex must be held by the caller.
+func (cache *snapshotCache) setNodeState(node string, info *statusInfo, state NodeState) {
+	if info.state == state {
+		return
+	}
+	event := NodeStateEvent{State: state, Previous: info.state, Timestamp: time.Now()}
+	info.state = state
+	if state == NodeDisconnected {
+		info.acked = nil
+	}
+
+	pool := &cache.listeners
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	for _, handler := range pool.stateHandlers {
+		handler := handler
+		select {
+		case pool.calls <- func(context.Context) { handler(node, event) }:
+		default:
+			cache.log.Warn("dropping node state event as the listener queue is full", nodeField(node),
+				Field{Key: "state", Value: state.String()})
+		}
+	}
+}
+
+// recordACK records the version of a type acknowledged by a node, and transitions the node to
+// synced once every type of its snapshot is acknowledged. The status info mutex must be held
+// by the caller.
+func (cache *snapshotCache) recordACK(node string, info *statusInfo, snapshot Snapshot, typeURL string, version string) {
+	if info.acked == nil {
+		info.acked = make(map[string]string)
+	}
+	info.acked[typeURL] = version
+	if ackedAll(info, snapshot) {
+		cache.setNodeState(node, info, NodeSynced)
+	}
+}
+
+// ackedAll reports whether the node acknowledged the current version of every type of the
+// snapshot. The status info mutex must be held by the caller.
+func ackedAll(info *statusInfo, snapshot Snapshot) bool {
+	types := 0
+	for i, resources := range snapshot.Resources {
+		if resources.Version == "" {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			continue
+		}
+		if info.acked[typeURL] != resources.Version {
+			return false
+		}
+		types++
+	}
+	return types > 0
+}
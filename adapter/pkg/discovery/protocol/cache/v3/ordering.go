@@ -0,0 +1,59 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"sort"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// ResourceOrdering orders the resources of a type in the sotw responses of a snapshot cache.
+type ResourceOrdering interface {
+	// Order returns the resources of the type in the order they are sent. The slice may be
+	// sorted in place.
+	Order(typeURL string, resources []types.ResourceWithTTL) []types.ResourceWithTTL
+}
+
+// DefaultResourceOrdering keeps the resources in the iteration order of the snapshot resource
+// map, which is not deterministic.
+type DefaultResourceOrdering struct{}
+
+// Order returns the resources as they are.
+func (DefaultResourceOrdering) Order(_ string, resources []types.ResourceWithTTL) []types.ResourceWithTTL {
+	return resources
+}
+
+// AlphabeticResourceOrdering sorts the resources by name, so that the responses are
+// deterministic, for instance in tests.
+type AlphabeticResourceOrdering struct{}
+
+// Order sorts the resources by name.
+func (AlphabeticResourceOrdering) Order(_ string, resources []types.ResourceWithTTL) []types.ResourceWithTTL {
+	sort.Slice(resources, func(i, j int) bool {
+		return GetResourceName(resources[i].Resource) < GetResourceName(resources[j].Resource)
+	})
+	return resources
+}
+
+// WithResourceOrdering sets the ordering of the resources in the sotw responses. The
+// default is DefaultResourceOrdering.
+func WithResourceOrdering(ordering ResourceOrdering) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if ordering != nil {
+			cache.ordering = ordering
+		}
+	}
+}
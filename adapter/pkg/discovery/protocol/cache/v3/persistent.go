@@ -0,0 +1,250 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+const (
+	// StaleVersionSuffix is appended to the versions of the snapshots loaded from the disk, so
+	// that setting a fresh snapshot with the same versions still responds to the open watches.
+	StaleVersionSuffix = "-stale"
+
+	snapshotFileExtension = ".pb"
+)
+
+type persistentSnapshotCache struct {
+	SnapshotCache
+
+	dir string
+	log log.Logger
+}
+
+// PersistentSnapshotCache wraps a snapshot cache to write the snapshot of a node to a file in
+// the directory whenever it is set, and to remove the file when the snapshot is cleared. The
+// snapshots in the directory are loaded into the inner cache on creation, with the
+// StaleVersionSuffix appended to their versions.
+//
+// The resources are written with the proto binary encoding. A failure to write a snapshot is
+// logged, and does not fail the call which set it.
+//
+// Logger is optional.
+func PersistentSnapshotCache(dir string, inner SnapshotCache, logger log.Logger) (SnapshotCache, error) {
+	if logger == nil {
+		logger = log.NewDefaultLogger()
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	cache := &persistentSnapshotCache{
+		SnapshotCache: inner,
+		dir:           dir,
+		log:           logger,
+	}
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// SetSnapshot sets the snapshot in the inner cache and writes it to the disk.
+func (cache *persistentSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.SetSnapshot(ctx, node, snapshot); err != nil {
+		return err
+	}
+	cache.persist(node)
+	return nil
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache and writes it to the disk.
+func (cache *persistentSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.WarmupSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	cache.persist(node)
+	return nil
+}
+
+// SetSnapshots sets the snapshots in the inner cache and writes them to the disk.
+func (cache *persistentSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	err := cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+	for node := range snapshots {
+		cache.persist(node)
+	}
+	return err
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *persistentSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *persistentSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// PatchSnapshot patches the snapshot in the inner cache and writes it to the disk.
+func (cache *persistentSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	if err := cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version); err != nil {
+		return err
+	}
+	cache.persist(node)
+	return nil
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache and writes it to the disk.
+func (cache *persistentSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	snapshot, err := cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+	if err != nil {
+		return snapshot, err
+	}
+	cache.persist(node)
+	return snapshot, nil
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache and writes it to the disk if set.
+func (cache *persistentSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	set, err := cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+	if set {
+		cache.persist(node)
+	}
+	return set, err
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache and writes it to the disk.
+func (cache *persistentSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	swapped, err := cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+	if swapped {
+		cache.persist(node)
+	}
+	return swapped, err
+}
+
+// ForceRespondAll force responds the open watches in the inner cache and writes the snapshot,
+// with its forced versions, to the disk.
+func (cache *persistentSnapshotCache) ForceRespondAll(ctx context.Context, node string) error {
+	err := cache.SnapshotCache.ForceRespondAll(ctx, node)
+	cache.persist(node)
+	return err
+}
+
+// ClearSnapshot clears the snapshot from the inner cache and removes it from the disk.
+func (cache *persistentSnapshotCache) ClearSnapshot(node string) {
+	cache.SnapshotCache.ClearSnapshot(node)
+	cache.unpersist(node)
+}
+
+// BulkClearSnapshot clears the snapshots from the inner cache and removes them from the disk.
+func (cache *persistentSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cleared := cache.SnapshotCache.BulkClearSnapshot(nodes)
+	for _, node := range nodes {
+		cache.unpersist(node)
+	}
+	return cleared
+}
+
+// unpersist removes the persisted snapshot of the node, if there is one.
+func (cache *persistentSnapshotCache) unpersist(node string) {
+	if err := os.Remove(cache.path(node)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		cache.log.Errorf("failed to remove persisted snapshot for node %q: %v", node, err)
+	}
+}
+
+// load sets the snapshots in the directory in the inner cache.
+func (cache *persistentSnapshotCache) load() error {
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotFileExtension) {
+			continue
+		}
+		node, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), snapshotFileExtension))
+		if err != nil {
+			cache.log.Warnf("skipping persisted snapshot %q: %v", entry.Name(), err)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cache.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		snapshot, err := UnmarshalSnapshot(data)
+		if err != nil {
+			cache.log.Warnf("skipping persisted snapshot for node %q: %v", node, err)
+			continue
+		}
+
+		for i := range snapshot.Resources {
+			if snapshot.Resources[i].Version != "" {
+				snapshot.Resources[i].Version += StaleVersionSuffix
+			}
+		}
+		if err := cache.SnapshotCache.SetSnapshot(context.Background(), node, snapshot); err != nil {
+			cache.log.Warnf("skipping persisted snapshot for node %q: %v", node, err)
+			continue
+		}
+		cache.log.Infof("loaded persisted snapshot for node %q", node)
+	}
+	return nil
+}
+
+// persist writes the snapshot of the node in the inner cache to the disk. The snapshot is
+// written to a temporary file which replaces the previous file, so that a partially written
+// snapshot is never loaded.
+func (cache *persistentSnapshotCache) persist(node string) {
+	snapshot, err := cache.SnapshotCache.GetSnapshot(node)
+	if err != nil {
+		return
+	}
+	data, err := MarshalSnapshot(snapshot)
+	if err != nil {
+		cache.log.Errorf("failed to encode snapshot for node %q: %v", node, err)
+		return
+	}
+
+	file, err := os.CreateTemp(cache.dir, ".snapshot-*")
+	if err != nil {
+		cache.log.Errorf("failed to persist snapshot for node %q: %v", node, err)
+		return
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), cache.path(node))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		cache.log.Errorf("failed to persist snapshot for node %q: %v", node, err)
+	}
+}
+
+func (cache *persistentSnapshotCache) path(node string) string {
+	return filepath.Join(cache.dir, url.PathEscape(node)+snapshotFileExtension)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"sort"
+
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+)
+
+// DefaultRespondPriority is the priority of the type URLs whose watches are responded first
+// when a snapshot is set, where a lower value is responded earlier. The enforcer config is
+// responded first, then the key managers and JWT issuers the tokens are validated with, then
+// the subscription data, and the APIs last, so that an enforcer does not serve an API before
+// it can validate its requests.
+var DefaultRespondPriority = map[string]int{
+	resource.ConfigType:                    0,
+	resource.KeyManagerType:                1,
+	resource.JWTIssuerListType:             1,
+	resource.JWTIssuerType:                 1,
+	resource.RevokedTokensType:             1,
+	resource.ApplicationPolicyListType:     2,
+	resource.SubscriptionPolicyListType:    2,
+	resource.ApplicationListType:           2,
+	resource.SubscriptionListType:          2,
+	resource.ApplicationKeyMappingListType: 2,
+	resource.ApplicationMappingListType:    2,
+	resource.ApplicationType:               2,
+	resource.SubscriptionType:              2,
+	resource.APIListType:                   3,
+	resource.APIType:                       3,
+}
+
+// WithRespondPriority sets the priority of the type URLs whose watches are responded first
+// when a snapshot is set, replacing DefaultRespondPriority. A lower value is responded
+// earlier, and the type URLs without a priority are responded after all the others.
+//
+// The order is kept by the respond strategies which respond in sequence.
+func WithRespondPriority(priorities map[string]int) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.respondPriority = priorities
+	}
+}
+
+// sortResponses orders the responses by the priority of their type URLs, and then by their
+// type URLs and watch IDs, so that the watches are responded in a deterministic order.
+func (cache *snapshotCache) sortResponses(responses []WatchResponse) {
+	sort.Slice(responses, func(i, j int) bool {
+		a, b := responses[i].Watch.Request.TypeUrl, responses[j].Watch.Request.TypeUrl
+		if a != b {
+			pa, okA := cache.respondPriority[a]
+			pb, okB := cache.respondPriority[b]
+			if okA != okB {
+				return okA
+			}
+			if pa != pb {
+				return pa < pb
+			}
+			return a < b
+		}
+		return responses[i].WatchID < responses[j].WatchID
+	})
+}
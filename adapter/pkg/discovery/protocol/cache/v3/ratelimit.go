@@ -0,0 +1,247 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// nodeRateLimit is the token bucket of a node, holding a single token.
+type nodeRateLimit struct {
+	// next is the time the next token is available
+	next time.Time
+	// waiting is the number of calls waiting for a token
+	waiting int
+}
+
+type rateLimitedSnapshotCache struct {
+	SnapshotCache
+
+	interval   time.Duration
+	queueDepth int
+
+	nodes map[string]*nodeRateLimit
+	// selectors are the token buckets of the selector snapshots, indexed by the selectors
+	selectors map[string]*nodeRateLimit
+	mu        sync.Mutex
+}
+
+// RateLimitedSnapshotCache wraps a snapshot cache to set the snapshot of each node at most
+// maxUpdatesPerSecond times per second. A call beyond the rate waits for its turn, so that no
+// update is lost, and at most queueDepth calls wait for each node. The calls beyond the queue
+// depth are rejected with ErrRateLimited, and a waiting call returns the error of its context
+// if the context is done first. Each mutator of the snapshots is limited, and the snapshots of
+// a selector have a rate of their own.
+//
+// The snapshots are set in the order of the calls. A rate of zero or below does not limit the
+// updates, and a queue depth below zero is treated as zero.
+func RateLimitedSnapshotCache(inner SnapshotCache, maxUpdatesPerSecond float64, queueDepth int) SnapshotCache {
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+	return &rateLimitedSnapshotCache{
+		SnapshotCache: inner,
+		interval:      rateInterval(maxUpdatesPerSecond),
+		queueDepth:    queueDepth,
+		nodes:         make(map[string]*nodeRateLimit),
+		selectors:     make(map[string]*nodeRateLimit),
+	}
+}
+
+// rateInterval returns the interval between the updates at the rate, which is zero for a rate
+// of zero or below and at most the maximum duration for a tiny rate.
+func rateInterval(maxUpdatesPerSecond float64) time.Duration {
+	if !(maxUpdatesPerSecond > 0) {
+		return 0
+	}
+	interval := float64(time.Second) / maxUpdatesPerSecond
+	if interval >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(interval)
+}
+
+// SetSnapshot sets the snapshot in the inner cache once the node is within the rate.
+func (cache *rateLimitedSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	if err := cache.wait(ctx, cache.nodes, node); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache once the node is within the rate.
+func (cache *rateLimitedSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	if err := cache.wait(context.Background(), cache.nodes, node); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.WarmupSnapshot(node, snapshot)
+}
+
+// SetSnapshots sets the snapshots in the inner cache once all the nodes are within the rate.
+// The tokens of all the nodes are taken before waiting, so a call rejected for one node takes
+// no token of the others.
+func (cache *rateLimitedSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	nodes := make([]string, 0, len(snapshots))
+	for node := range snapshots {
+		nodes = append(nodes, node)
+	}
+	if err := cache.wait(ctx, cache.nodes, nodes...); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.SetSnapshots(ctx, snapshots)
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *rateLimitedSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *rateLimitedSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// PatchSnapshot patches the snapshot in the inner cache once the node is within the rate.
+func (cache *rateLimitedSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	if err := cache.wait(ctx, cache.nodes, node); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache once the node is within the
+// rate. The snapshots are compared after the wait.
+func (cache *rateLimitedSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	if err := cache.wait(ctx, cache.nodes, node); err != nil {
+		return false, err
+	}
+	return cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache if the node has none, once the node
+// is within the rate.
+func (cache *rateLimitedSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	if err := cache.wait(ctx, cache.nodes, node); err != nil {
+		return false, err
+	}
+	return cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+}
+
+// SetSnapshotVariant sets the variant in the inner cache once the node is within the rate.
+func (cache *rateLimitedSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	if err := cache.wait(ctx, cache.nodes, node); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.SetSnapshotVariant(ctx, node, variant, snapshot)
+}
+
+// SetSnapshotForSelector sets the snapshot for the selector in the inner cache once the
+// selector is within the rate.
+func (cache *rateLimitedSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	// the map keys are printed in sorted order, so the same selector has the same key
+	if err := cache.wait(ctx, cache.selectors, fmt.Sprint(selector)); err != nil {
+		return err
+	}
+	return cache.SnapshotCache.SetSnapshotForSelector(ctx, selector, snapshot)
+}
+
+// ClearSnapshot forgets the rate of the node, unless calls are waiting, and clears it from
+// the inner cache.
+func (cache *rateLimitedSnapshotCache) ClearSnapshot(node string) {
+	cache.mu.Lock()
+	if limit, ok := cache.nodes[node]; ok && limit.waiting == 0 {
+		delete(cache.nodes, node)
+	}
+	cache.mu.Unlock()
+
+	cache.SnapshotCache.ClearSnapshot(node)
+}
+
+// BulkClearSnapshot forgets the rates of the nodes without waiting calls, and clears them
+// from the inner cache.
+func (cache *rateLimitedSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cache.mu.Lock()
+	for _, node := range nodes {
+		if limit, ok := cache.nodes[node]; ok && limit.waiting == 0 {
+			delete(cache.nodes, node)
+		}
+	}
+	cache.mu.Unlock()
+
+	return cache.SnapshotCache.BulkClearSnapshot(nodes)
+}
+
+// wait takes the next tokens of the keys in the limits, and waits until the last of them is
+// available. No token is taken if a key has a full queue. The tokens are not returned if the
+// context is done while waiting.
+func (cache *rateLimitedSnapshotCache) wait(ctx context.Context, limits map[string]*nodeRateLimit, keys ...string) error {
+	cache.mu.Lock()
+	now := time.Now()
+	taken := make([]*nodeRateLimit, 0, len(keys))
+	for _, key := range keys {
+		limit, ok := limits[key]
+		if !ok {
+			limit = &nodeRateLimit{}
+			limits[key] = limit
+		}
+		if limit.next.Before(now) {
+			limit.next = now
+		}
+		if limit.next.After(now) && limit.waiting >= cache.queueDepth {
+			cache.mu.Unlock()
+			return ErrRateLimited
+		}
+		taken = append(taken, limit)
+	}
+
+	var delay time.Duration
+	var waiting []*nodeRateLimit
+	for _, limit := range taken {
+		if d := limit.next.Sub(now); d > 0 {
+			if d > delay {
+				delay = d
+			}
+			limit.waiting++
+			waiting = append(waiting, limit)
+		}
+		limit.next = limit.next.Add(cache.interval)
+	}
+	cache.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	var err error
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cache.mu.Lock()
+	for _, limit := range waiting {
+		limit.waiting--
+	}
+	cache.mu.Unlock()
+	return err
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// RegionSeparator separates the region from the node ID in the inner cache of a
+// RegionScopedSnapshotCache.
+const RegionSeparator = "/"
+
+type regionScopedSnapshotCache struct {
+	SnapshotCache
+
+	prefix string
+}
+
+// RegionScopedSnapshotCache wraps a snapshot cache to scope the node IDs to a region, so that
+// the caches of several regions can share the inner cache without their node IDs colliding.
+// The node IDs are prefixed with the region and the RegionSeparator in the inner cache, and
+// the prefix is stripped from the node IDs returned, which only include the nodes of the
+// region.
+//
+// The node IDs of the xDS requests are prefixed as well, so the inner cache must identify the
+// nodes by their IDs, as IDHash does. The methods which do not take a node, such as Stats,
+// Drain or Reset, apply to the whole inner cache.
+func RegionScopedSnapshotCache(region string, inner SnapshotCache) SnapshotCache {
+	return &regionScopedSnapshotCache{
+		SnapshotCache: inner,
+		prefix:        region + RegionSeparator,
+	}
+}
+
+// scope returns the node ID in the inner cache.
+func (cache *regionScopedSnapshotCache) scope(node string) string {
+	return cache.prefix + node
+}
+
+// unscope returns the node ID of a node ID in the inner cache, and whether the node is in
+// the region.
+func (cache *regionScopedSnapshotCache) unscope(node string) (string, bool) {
+	if !strings.HasPrefix(node, cache.prefix) {
+		return "", false
+	}
+	return node[len(cache.prefix):], true
+}
+
+// scopeNode returns a copy of the node metadata with the node ID in the inner cache.
+func (cache *regionScopedSnapshotCache) scopeNode(node *core.Node) *core.Node {
+	scoped := &core.Node{}
+	if node != nil {
+		scoped = proto.Clone(node).(*core.Node)
+	}
+	scoped.Id = cache.scope(scoped.GetId())
+	return scoped
+}
+
+// scopeRequest returns a copy of the request with the node ID in the inner cache.
+func (cache *regionScopedSnapshotCache) scopeRequest(request *envoy_cache.Request) *envoy_cache.Request {
+	scoped := proto.Clone(request).(*envoy_cache.Request)
+	scoped.Node = cache.scopeNode(request.GetNode())
+	return scoped
+}
+
+// CreateWatch creates the watch in the inner cache for the node of the region.
+func (cache *regionScopedSnapshotCache) CreateWatch(request *envoy_cache.Request, state stream.StreamState, value chan envoy_cache.Response) func() {
+	return cache.SnapshotCache.CreateWatch(cache.scopeRequest(request), state, value)
+}
+
+// CreateDeltaWatch creates the delta watch in the inner cache for the node of the region.
+func (cache *regionScopedSnapshotCache) CreateDeltaWatch(request *envoy_cache.DeltaRequest, state stream.StreamState, value chan envoy_cache.DeltaResponse) func() {
+	scoped := proto.Clone(request).(*envoy_cache.DeltaRequest)
+	scoped.Node = cache.scopeNode(request.GetNode())
+	return cache.SnapshotCache.CreateDeltaWatch(scoped, state, value)
+}
+
+// Fetch fetches from the inner cache for the node of the region.
+func (cache *regionScopedSnapshotCache) Fetch(ctx context.Context, request *envoy_cache.Request) (envoy_cache.Response, error) {
+	return cache.SnapshotCache.Fetch(ctx, cache.scopeRequest(request))
+}
+
+// SetSnapshot sets the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	return cache.SnapshotCache.SetSnapshot(ctx, cache.scope(node), snapshot)
+}
+
+// WarmupSnapshot warms up the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	return cache.SnapshotCache.WarmupSnapshot(cache.scope(node), snapshot)
+}
+
+// SetSnapshots sets the snapshots of the nodes of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	scoped := make(map[string]Snapshot, len(snapshots))
+	for node, snapshot := range snapshots {
+		scoped[cache.scope(node)] = snapshot
+	}
+	return cache.SnapshotCache.SetSnapshots(ctx, scoped)
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// PatchSnapshot patches the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	return cache.SnapshotCache.PatchSnapshot(ctx, cache.scope(node), typeURL, resources, version)
+}
+
+// GetSnapshot gets the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	return cache.SnapshotCache.GetSnapshot(cache.scope(node))
+}
+
+// ForceRespondAll responds to the open watches of the node of the region.
+func (cache *regionScopedSnapshotCache) ForceRespondAll(ctx context.Context, node string) error {
+	return cache.SnapshotCache.ForceRespondAll(ctx, cache.scope(node))
+}
+
+// GetSnapshotHistory returns the snapshot history of the node of the region.
+func (cache *regionScopedSnapshotCache) GetSnapshotHistory(node string) ([]SnapshotHistoryEntry, error) {
+	return cache.SnapshotCache.GetSnapshotHistory(cache.scope(node))
+}
+
+// RequestLog returns the request log of the node of the region.
+func (cache *regionScopedSnapshotCache) RequestLog(node string) ([]RequestLogEntry, error) {
+	return cache.SnapshotCache.RequestLog(cache.scope(node))
+}
+
+// SnapshotAge returns the age of the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) SnapshotAge(node string) (time.Duration, error) {
+	return cache.SnapshotCache.SnapshotAge(cache.scope(node))
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	return cache.SnapshotCache.GetOrCreateSnapshot(ctx, cache.scope(node), factory)
+}
+
+// SetSnapshotDryRun runs SetSnapshotDryRun for the node of the region, and returns the
+// result with the node ID of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshotDryRun(ctx context.Context, node string, snapshot Snapshot) (DryRunResult, error) {
+	result, err := cache.SnapshotCache.SetSnapshotDryRun(ctx, cache.scope(node), snapshot)
+	if err != nil {
+		return result, err
+	}
+	result.Node = node
+	return result, nil
+}
+
+// SetSnapshotWithAudit sets the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SnapshotCache.SetSnapshotWithAudit(ctx, cache.scope(node), snapshot, requestedBy)
+}
+
+// GetAuditLog returns the audit log of the node of the region.
+func (cache *regionScopedSnapshotCache) GetAuditLog(node string) []AuditEntry {
+	return cache.SnapshotCache.GetAuditLog(cache.scope(node))
+}
+
+// SetSnapshotIfAbsent sets the snapshot of the node of the region if it has none.
+func (cache *regionScopedSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	return cache.SnapshotCache.SetSnapshotIfAbsent(ctx, cache.scope(node), snapshot)
+}
+
+// SetSnapshotVariant sets the snapshot variant of the node of the region.
+func (cache *regionScopedSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	return cache.SnapshotCache.SetSnapshotVariant(ctx, cache.scope(node), variant, snapshot)
+}
+
+// GetSnapshotVariant gets the snapshot variant of the node of the region.
+func (cache *regionScopedSnapshotCache) GetSnapshotVariant(node string, variant string) (Snapshot, error) {
+	return cache.SnapshotCache.GetSnapshotVariant(cache.scope(node), variant)
+}
+
+// CompareAndSwapSnapshot swaps the snapshot of the node of the region.
+func (cache *regionScopedSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	return cache.SnapshotCache.CompareAndSwapSnapshot(ctx, cache.scope(node), expected, newSnapshot)
+}
+
+// ClearSnapshot clears the node of the region.
+func (cache *regionScopedSnapshotCache) ClearSnapshot(node string) {
+	cache.SnapshotCache.ClearSnapshot(cache.scope(node))
+}
+
+// BulkClearSnapshot clears the nodes of the region.
+func (cache *regionScopedSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	scoped := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		scoped = append(scoped, cache.scope(node))
+	}
+	return cache.SnapshotCache.BulkClearSnapshot(scoped)
+}
+
+// SubscribeNodeState subscribes the handler to the node states of the nodes of the region.
+func (cache *regionScopedSnapshotCache) SubscribeNodeState(handler func(nodeID string, event NodeStateEvent)) {
+	cache.SnapshotCache.SubscribeNodeState(func(nodeID string, event NodeStateEvent) {
+		if node, ok := cache.unscope(nodeID); ok {
+			handler(node, event)
+		}
+	})
+}
+
+// CreateResourceWatch creates the resource watch for the node of the region.
+func (cache *regionScopedSnapshotCache) CreateResourceWatch(typeURL string, resourceName string, node string, value chan envoy_cache.Response) func() {
+	return cache.SnapshotCache.CreateResourceWatch(typeURL, resourceName, cache.scope(node), value)
+}
+
+// CreateClearWatch creates the clear watch for the node of the region.
+func (cache *regionScopedSnapshotCache) CreateClearWatch(node string, value chan struct{}) func() {
+	return cache.SnapshotCache.CreateClearWatch(cache.scope(node), value)
+}
+
+// GetStatusInfo returns the status of the node of the region.
+func (cache *regionScopedSnapshotCache) GetStatusInfo(node string) StatusInfo {
+	return cache.SnapshotCache.GetStatusInfo(cache.scope(node))
+}
+
+// GetStatusKeys returns the nodes of the region with a status.
+func (cache *regionScopedSnapshotCache) GetStatusKeys() []string {
+	return cache.unscopeAll(cache.SnapshotCache.GetStatusKeys())
+}
+
+// ListNodes returns the nodes of the region with a snapshot.
+func (cache *regionScopedSnapshotCache) ListNodes() []string {
+	return cache.unscopeAll(cache.SnapshotCache.ListNodes())
+}
+
+// ForeachSnapshot calls fn for the snapshot of each node of the region.
+func (cache *regionScopedSnapshotCache) ForeachSnapshot(fn func(node string, snapshot Snapshot) bool) {
+	cache.SnapshotCache.ForeachSnapshot(func(node string, snapshot Snapshot) bool {
+		if node, ok := cache.unscope(node); ok {
+			return fn(node, snapshot)
+		}
+		return true
+	})
+}
+
+// ReadView returns the snapshots of the nodes of the region. Unlike the view of the inner
+// cache, the view is copied from the inner view.
+func (cache *regionScopedSnapshotCache) ReadView() CacheReadView {
+	var snapshots *snapshotMap
+	cache.SnapshotCache.ReadView().ForEach(func(node string, snapshot Snapshot) {
+		if node, ok := cache.unscope(node); ok {
+			snapshots = snapshots.set(node, snapshot)
+		}
+	})
+	return CacheReadView{snapshots: snapshots}
+}
+
+// WatchCount returns the number of open watches of the node of the region.
+func (cache *regionScopedSnapshotCache) WatchCount(node string) (int, int) {
+	return cache.SnapshotCache.WatchCount(cache.scope(node))
+}
+
+// WatchDuration returns how long a watch of the node of the region has been open.
+func (cache *regionScopedSnapshotCache) WatchDuration(node string, watchID int64) time.Duration {
+	return cache.SnapshotCache.WatchDuration(cache.scope(node), watchID)
+}
+
+// WatchGroup creates a watch group for the node of the region.
+func (cache *regionScopedSnapshotCache) WatchGroup(ctx context.Context, node string) *WatchGroup {
+	return cache.SnapshotCache.WatchGroup(ctx, cache.scope(node))
+}
+
+// AllResourceNames returns the resource names of a type of each node of the region.
+func (cache *regionScopedSnapshotCache) AllResourceNames(typeURL string) map[string][]string {
+	out := make(map[string][]string)
+	for node, names := range cache.SnapshotCache.AllResourceNames(typeURL) {
+		if node, ok := cache.unscope(node); ok {
+			out[node] = names
+		}
+	}
+	return out
+}
+
+// AllResourceNamesGlobal returns the resource names of a type across the nodes of the region.
+func (cache *regionScopedSnapshotCache) AllResourceNamesGlobal(typeURL string) []string {
+	return mergeResourceNames(cache.AllResourceNames(typeURL))
+}
+
+// unscopeAll returns the node IDs of the region in the node IDs of the inner cache.
+func (cache *regionScopedSnapshotCache) unscopeAll(nodes []string) []string {
+	out := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node, ok := cache.unscope(node); ok {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+var _ SnapshotCache = &regionScopedSnapshotCache{}
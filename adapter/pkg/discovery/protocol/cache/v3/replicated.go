@@ -0,0 +1,213 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// ReplicatingSnapshotCache is a snapshot cache which replicates its snapshots to a secondary
+// cache, returned by ReplicatedSnapshotCache.
+type ReplicatingSnapshotCache interface {
+	SnapshotCache
+
+	// SyncFromPrimary copies the snapshots of all nodes from the primary cache to the
+	// secondary cache, and clears the nodes of the secondary cache missing in the primary.
+	SyncFromPrimary(ctx context.Context) error
+}
+
+type replicatedSnapshotCache struct {
+	SnapshotCache
+
+	secondary SnapshotCache
+	log       StructuredLogger
+}
+
+// ReplicatedSnapshotCache wraps a primary snapshot cache to write each snapshot set in it to
+// the secondary cache as well, so that the secondary can serve the Envoy nodes right away if
+// the primary fails. The snapshots are read from the primary, falling back to the secondary
+// for the nodes missing in the primary, and the nodes whose versions differ between the two
+// caches are logged. The watches are created in the primary only.
+//
+// A failure to write the secondary cache is returned after the primary is written.
+//
+// Logger is optional.
+func ReplicatedSnapshotCache(primary, secondary SnapshotCache, logger log.Logger) ReplicatingSnapshotCache {
+	return &replicatedSnapshotCache{
+		SnapshotCache: primary,
+		secondary:     secondary,
+		log:           NewStructuredLogger(logger),
+	}
+}
+
+// SetSnapshot sets the snapshot in the primary cache and then in the secondary cache.
+func (cache *replicatedSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.SetSnapshot(ctx, node, snapshot); err != nil {
+		return err
+	}
+	return cache.replicate(ctx, node)
+}
+
+// WarmupSnapshot warms up the snapshot in both caches.
+func (cache *replicatedSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	if err := cache.SnapshotCache.WarmupSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	if err := cache.secondary.WarmupSnapshot(node, snapshot); err != nil {
+		return fmt.Errorf("failed to replicate snapshot for node %q: %w", node, err)
+	}
+	return nil
+}
+
+// SetSnapshots sets the snapshots in the primary cache and replicates those which were set.
+func (cache *replicatedSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	errs := []error{cache.SnapshotCache.SetSnapshots(ctx, snapshots)}
+	for node := range snapshots {
+		errs = append(errs, cache.replicate(ctx, node))
+	}
+	return errors.Join(errs...)
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *replicatedSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotWithAudit sets the snapshot with SetSnapshot, with the requester in the context.
+func (cache *replicatedSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.SetSnapshot(WithRequestedBy(ctx, requestedBy), node, snapshot)
+}
+
+// PatchSnapshot patches the snapshot in the primary cache and replicates the patched snapshot.
+func (cache *replicatedSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	if err := cache.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version); err != nil {
+		return err
+	}
+	return cache.replicate(ctx, node)
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the primary cache and replicates it.
+func (cache *replicatedSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	snapshot, err := cache.SnapshotCache.GetOrCreateSnapshot(ctx, node, factory)
+	if err != nil {
+		return snapshot, err
+	}
+	return snapshot, cache.replicate(ctx, node)
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the primary cache and replicates it if set.
+func (cache *replicatedSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	set, err := cache.SnapshotCache.SetSnapshotIfAbsent(ctx, node, snapshot)
+	if err != nil || !set {
+		return set, err
+	}
+	return true, cache.replicate(ctx, node)
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the primary cache and replicates it if swapped.
+func (cache *replicatedSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	swapped, err := cache.SnapshotCache.CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+	if err != nil || !swapped {
+		return swapped, err
+	}
+	return true, cache.replicate(ctx, node)
+}
+
+// ClearSnapshot clears the node from both caches.
+func (cache *replicatedSnapshotCache) ClearSnapshot(node string) {
+	cache.SnapshotCache.ClearSnapshot(node)
+	cache.secondary.ClearSnapshot(node)
+}
+
+// BulkClearSnapshot clears the nodes from both caches, and returns the number of nodes cleared
+// from the primary cache.
+func (cache *replicatedSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	cleared := cache.SnapshotCache.BulkClearSnapshot(nodes)
+	cache.secondary.BulkClearSnapshot(nodes)
+	return cleared
+}
+
+// GetSnapshot gets the snapshot from the primary cache, or from the secondary cache if the
+// primary has none, and logs the node if the versions of the caches differ.
+func (cache *replicatedSnapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	snapshot, err := cache.SnapshotCache.GetSnapshot(node)
+	replica, replicaErr := cache.secondary.GetSnapshot(node)
+	if err != nil {
+		if replicaErr != nil {
+			return snapshot, err
+		}
+		cache.log.Warn("serving snapshot from the secondary cache", nodeField(node), errorField(err))
+		return replica, nil
+	}
+	if replicaErr == nil && !EqualSnapshot(snapshot, replica) {
+		cache.log.Warn("snapshot versions diverged between the primary and secondary caches", nodeField(node))
+	}
+	return snapshot, nil
+}
+
+// Fetch fetches from the primary cache, or from the secondary cache if the primary has no
+// snapshot of the node.
+func (cache *replicatedSnapshotCache) Fetch(ctx context.Context, request *envoy_cache.Request) (envoy_cache.Response, error) {
+	response, err := cache.SnapshotCache.Fetch(ctx, request)
+	if errors.Is(err, ErrNodeNotFound) {
+		return cache.secondary.Fetch(ctx, request)
+	}
+	return response, err
+}
+
+// SyncFromPrimary sets the snapshots of the primary cache in the secondary cache under a
+// single call, and clears the other nodes of the secondary cache.
+func (cache *replicatedSnapshotCache) SyncFromPrimary(ctx context.Context) error {
+	view := cache.SnapshotCache.ReadView()
+	snapshots := make(map[string]Snapshot, view.Len())
+	view.ForEach(func(node string, snapshot Snapshot) {
+		snapshots[node] = snapshot
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := cache.secondary.SetSnapshots(ctx, snapshots); err != nil {
+		return err
+	}
+	for _, node := range cache.secondary.ListNodes() {
+		if _, ok := snapshots[node]; !ok {
+			cache.secondary.ClearSnapshot(node)
+		}
+	}
+	cache.log.Info("synced the secondary cache", Field{Key: "nodes", Value: len(snapshots)})
+	return nil
+}
+
+// replicate sets the snapshot of a node in the primary cache in the secondary cache.
+func (cache *replicatedSnapshotCache) replicate(ctx context.Context, node string) error {
+	snapshot, err := cache.SnapshotCache.GetSnapshot(node)
+	if err != nil {
+		// the snapshot was not set in the primary cache
+		return nil
+	}
+	if err := cache.secondary.SetSnapshot(ctx, node, snapshot); err != nil {
+		cache.log.Error("failed to replicate snapshot", nodeField(node), errorField(err))
+		return fmt.Errorf("failed to replicate snapshot for node %q: %w", node, err)
+	}
+	return nil
+}
+
+var _ ReplicatingSnapshotCache = &replicatedSnapshotCache{}
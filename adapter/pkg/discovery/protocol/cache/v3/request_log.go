@@ -0,0 +1,81 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// defaultRequestLogSize is the number of requests kept per node by default.
+const defaultRequestLogSize = 100
+
+// RequestLogEntry is a sotw discovery request made by a node.
+type RequestLogEntry struct {
+	Time          time.Time `json:"time"`
+	Node          string    `json:"node"`
+	TypeURL       string    `json:"typeUrl"`
+	Version       string    `json:"version"`
+	ResourceNames []string  `json:"resourceNames,omitempty"`
+	Nonce         string    `json:"nonce"`
+	// ErrorDetail is the message of the error detail of a NACK
+	ErrorDetail string `json:"errorDetail,omitempty"`
+}
+
+// WithRequestLogSize sets the number of requests kept per node for RequestLog.
+// Zero disables the request log. The default is 100.
+func WithRequestLogSize(size int) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if size >= 0 {
+			cache.requestLogSize = size
+		}
+	}
+}
+
+// RequestLog returns the last requests of a node from the oldest to the newest.
+func (cache *snapshotCache) RequestLog(node string) ([]RequestLogEntry, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	requests, ok := cache.requestLogs[node]
+	if !ok {
+		return nil, fmt.Errorf("no requests found for node %s", node)
+	}
+	return requests.list(), nil
+}
+
+// logRequest adds a request to the request log of a node. The cache mutex must be held by
+// the caller.
+func (cache *snapshotCache) logRequest(node string, request *envoy_cache.Request) {
+	if cache.requestLogSize == 0 {
+		return
+	}
+	requests, ok := cache.requestLogs[node]
+	if !ok {
+		requests = &ringBuffer[RequestLogEntry]{}
+		cache.requestLogs[node] = requests
+	}
+	requests.add(RequestLogEntry{
+		Time:          time.Now(),
+		Node:          node,
+		TypeURL:       request.TypeUrl,
+		Version:       request.VersionInfo,
+		ResourceNames: request.ResourceNames,
+		Nonce:         request.ResponseNonce,
+		ErrorDetail:   request.GetErrorDetail().GetMessage(),
+	}, cache.requestLogSize)
+}
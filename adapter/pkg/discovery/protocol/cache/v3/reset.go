@@ -0,0 +1,75 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strings"
+)
+
+// Reset closes the open watches in the same way as Drain, and clears the snapshots, the
+// snapshot variants, the selector snapshots and the status of all nodes, so that the cache
+// can be filled again from scratch. Unlike Drain, the watches created afterwards are
+// accepted. The clear watches are responded, while the resource and global watches are kept.
+//
+// The watch ID counters are not reset, so that the cancel functions of the closed watches
+// cannot remove the watches created after the reset. The version counters of the automatic
+// versioning are not reset either, so that a node is not sent a version it has acknowledged.
+//
+// Reset returns with the context error if the context expires before the cache lock is
+// acquired.
+func (cache *snapshotCache) Reset(ctx context.Context) error {
+	return cache.reset(ctx, "")
+}
+
+// reset resets the nodes with the node ID prefix and the selector snapshots set for them, as
+// Reset does for all nodes.
+func (cache *snapshotCache) reset(ctx context.Context, prefix string) error {
+	if err := cache.lockContext(ctx); err != nil {
+		return err
+	}
+	defer cache.mu.Unlock()
+
+	cache.closeWatches(prefix)
+	nodes := make(map[string]bool, cache.snapshots.Load().len()+len(cache.status))
+	cache.snapshots.Load().forEach(func(node string, _ Snapshot) bool {
+		if strings.HasPrefix(node, prefix) {
+			nodes[node] = true
+		}
+		return true
+	})
+	for node := range cache.status {
+		if strings.HasPrefix(node, prefix) {
+			nodes[node] = true
+		}
+	}
+	for node := range cache.variants {
+		if strings.HasPrefix(node, prefix) {
+			nodes[node] = true
+		}
+	}
+	for node := range nodes {
+		cache.clearSnapshot(node)
+	}
+	selectors := cache.selectors[:0:0]
+	for _, s := range cache.selectors {
+		if !strings.HasPrefix(s.prefix, prefix) {
+			selectors = append(selectors, s)
+		}
+	}
+	cache.selectors = selectors
+	cache.log.Info("reset the snapshot cache", Field{Key: "nodes", Value: len(nodes)})
+	return nil
+}
@@ -66,6 +66,48 @@ func GetResponseType(typeURL string) types.ResponseType {
 	return types.UnknownType
 }
 
+// GetResponseTypeURL returns the type url for a valid enum.
+func GetResponseTypeURL(responseType types.ResponseType) (string, error) {
+	switch responseType {
+	case types.Config:
+		return resource.ConfigType, nil
+	case types.API:
+		return resource.APIType, nil
+	case types.SubscriptionList:
+		return resource.SubscriptionListType, nil
+	case types.APIList:
+		return resource.APIListType, nil
+	case types.ApplicationList:
+		return resource.ApplicationListType, nil
+	case types.JWTIssuerList:
+		return resource.JWTIssuerListType, nil
+	case types.ApplicationPolicyList:
+		return resource.ApplicationPolicyListType, nil
+	case types.SubscriptionPolicyList:
+		return resource.SubscriptionPolicyListType, nil
+	case types.ApplicationKeyMappingList:
+		return resource.ApplicationKeyMappingListType, nil
+	case types.ApplicationMappingList:
+		return resource.ApplicationMappingListType, nil
+	case types.KeyManagerConfig:
+		return resource.KeyManagerType, nil
+	case types.RevokedTokens:
+		return resource.RevokedTokensType, nil
+	case types.ThrottleData:
+		return resource.ThrottleDataType, nil
+	case types.APKMgtApplicationList:
+		return resource.APKMgtApplicationType, nil
+	case types.Application:
+		return resource.ApplicationType, nil
+	case types.Subscription:
+		return resource.SubscriptionType, nil
+	case types.JWTIssuer:
+		return resource.JWTIssuerType, nil
+	default:
+		return "", fmt.Errorf("couldn't map response type %v to known resource type", responseType)
+	}
+}
+
 // GetResourceName returns the resource name for a valid xDS response type.
 func GetResourceName(res envoy_types.Resource) string {
 	// Since Applications, Subscriptions, API-Metadata, Application Policies and Subscription Policies
@@ -84,3 +126,12 @@ func GetResourceName(res envoy_types.Resource) string {
 		return ""
 	}
 }
+
+// GetResourceNames returns the resource names for a list of valid xDS response types.
+func GetResourceNames(resources []envoy_types.Resource) []string {
+	out := make([]string, len(resources))
+	for i, r := range resources {
+		out[i] = GetResourceName(r)
+	}
+	return out
+}
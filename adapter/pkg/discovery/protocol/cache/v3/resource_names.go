@@ -0,0 +1,66 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"sort"
+)
+
+// AllResourceNames returns the sorted names of the resources of a type in the snapshot of
+// each node which has any, indexed by node IDs. The snapshots are read as they were set at a
+// single instant.
+func (cache *snapshotCache) AllResourceNames(typeURL string) map[string][]string {
+	out := make(map[string][]string)
+	cache.snapshots.Load().forEach(func(node string, snapshot Snapshot) bool {
+		if names := resourceNames(snapshot, typeURL); len(names) > 0 {
+			out[node] = names
+		}
+		return true
+	})
+	return out
+}
+
+// AllResourceNamesGlobal returns the sorted names of the resources of a type in the snapshots
+// of all nodes, without duplicates.
+func (cache *snapshotCache) AllResourceNamesGlobal(typeURL string) []string {
+	return mergeResourceNames(cache.AllResourceNames(typeURL))
+}
+
+// resourceNames returns the sorted names of the resources of a type in the snapshot.
+func resourceNames(snapshot Snapshot, typeURL string) []string {
+	resources := snapshot.GetResourcesAndTTL(typeURL)
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergeResourceNames returns the sorted names of the resources of all nodes, without duplicates.
+func mergeResourceNames(nodes map[string][]string) []string {
+	set := make(map[string]bool)
+	for _, names := range nodes {
+		for _, name := range names {
+			set[name] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for name := range set {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
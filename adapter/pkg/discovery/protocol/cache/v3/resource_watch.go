@@ -0,0 +1,92 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// resourceWatch is an open watch on a single named resource of a node.
+type resourceWatch struct {
+	typeURL string
+	name    string
+	value   chan envoy_cache.Response
+}
+
+// CreateResourceWatch opens a watch on a single resource of a node. The watch is responded
+// once, when a snapshot is set in which the resource is added, modified or removed compared
+// to the previous snapshot of the node. The response holds only the resource, and no
+// resources if it was removed. The value channel should have capacity not to block.
+func (cache *snapshotCache) CreateResourceWatch(typeURL string, resourceName string, node string, value chan envoy_cache.Response) func() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	watches, ok := cache.resourceWatches[node]
+	if !ok {
+		watches = make(map[int64]resourceWatch)
+		cache.resourceWatches[node] = watches
+	}
+	watchID := cache.nextWatchID()
+	cache.log.Debug("open resource watch", nodeField(node), watchField(watchID), typeField(typeURL), namesField([]string{resourceName}))
+	watches[watchID] = resourceWatch{typeURL: typeURL, name: resourceName, value: value}
+
+	return func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if watches, ok := cache.resourceWatches[node]; ok {
+			delete(watches, watchID)
+			if len(watches) == 0 {
+				delete(cache.resourceWatches, node)
+			}
+		}
+	}
+}
+
+// respondResourceWatches responds to the resource watches of a node whose resource changed
+// between the previous and the new snapshot, and discards them. The cache mutex must be held
+// by the caller.
+func (cache *snapshotCache) respondResourceWatches(ctx context.Context, node string, previous, snapshot Snapshot) error {
+	watches := cache.resourceWatches[node]
+	for id, watch := range watches {
+		old, existed := previous.GetResourcesAndTTL(watch.typeURL)[watch.name]
+		current, exists := snapshot.GetResourcesAndTTL(watch.typeURL)[watch.name]
+		if existed == exists && (!exists || equalResources(old.Resource, current.Resource)) {
+			continue
+		}
+
+		resources := map[string]types.ResourceWithTTL{}
+		if exists {
+			resources[watch.name] = current
+		}
+		request := &envoy_cache.Request{
+			Node:          &core.Node{Id: node},
+			TypeUrl:       watch.typeURL,
+			ResourceNames: []string{watch.name},
+		}
+		cache.log.Debug("respond resource watch", nodeField(node), watchField(id), typeField(watch.typeURL), namesField([]string{watch.name}))
+		if err := cache.respond(ctx, request, watch.value, resources, snapshot.GetVersion(watch.typeURL), false); err != nil {
+			return err
+		}
+		delete(watches, id)
+	}
+	if len(watches) == 0 {
+		delete(cache.resourceWatches, node)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+// WatchResponse is a response pending to be sent to an open watch after a snapshot is set.
+type WatchResponse struct {
+	Node      string
+	WatchID   int64
+	Watch     envoy_cache.ResponseWatch
+	Resources map[string]types.ResourceWithTTL
+	Version   string
+}
+
+// RespondStrategy decides how the open watches are responded when snapshots are set.
+type RespondStrategy interface {
+	// Respond sends each of the responses with the respond function, and returns the
+	// errors of the failed responses.
+	Respond(ctx context.Context, responses []WatchResponse, respond func(context.Context, WatchResponse) error) error
+}
+
+// SequentialRespondStrategy responds to the watches one after the other, and stops at the
+// first failed response. It is the default strategy of the cache.
+type SequentialRespondStrategy struct{}
+
+// Respond sends the responses in order.
+func (SequentialRespondStrategy) Respond(ctx context.Context, responses []WatchResponse, respond func(context.Context, WatchResponse) error) error {
+	for _, response := range responses {
+		if err := respond(ctx, response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchRespondStrategy groups the responses by type URL across nodes, and responds to the
+// watches of each type concurrently with a pool of workers. The types are responded one
+// after the other.
+type BatchRespondStrategy struct {
+	// Workers is the number of concurrent responses. Values below one are treated as one.
+	Workers int
+}
+
+// Respond sends the responses of each type URL concurrently.
+func (strategy BatchRespondStrategy) Respond(ctx context.Context, responses []WatchResponse, respond func(context.Context, WatchResponse) error) error {
+	var typeURLs []string
+	batches := make(map[string][]WatchResponse)
+	for _, response := range responses {
+		typeURL := response.Watch.Request.TypeUrl
+		if _, ok := batches[typeURL]; !ok {
+			typeURLs = append(typeURLs, typeURL)
+		}
+		batches[typeURL] = append(batches[typeURL], response)
+	}
+
+	workers := strategy.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var errs []error
+	var mu sync.Mutex
+	for _, typeURL := range typeURLs {
+		queue := make(chan WatchResponse)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for response := range queue {
+					if err := respond(ctx, response); err != nil {
+						mu.Lock()
+						errs = append(errs, err)
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		for _, response := range batches[typeURL] {
+			queue <- response
+		}
+		close(queue)
+		wg.Wait()
+	}
+	return errors.Join(errs...)
+}
+
+// WithRespondStrategy sets the strategy used to respond to the open watches when snapshots
+// are set. The default strategy responds to the watches sequentially.
+func WithRespondStrategy(strategy RespondStrategy) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if strategy != nil {
+			cache.respondStrategy = strategy
+		}
+	}
+}
+
+// pendingResponses returns the responses for the open watches of a node served its snapshot
+// whose version differs from the snapshot, ordered by the respond priority. The cache mutex
+// must be held by the caller.
+func (cache *snapshotCache) pendingResponses(node string, snapshot Snapshot) []WatchResponse {
+	return cache.pendingVariantResponses(node, "", snapshot)
+}
+
+// pendingVariantResponses returns the responses for the open watches of a node served a
+// variant whose version differs from the snapshot of the variant. The cache mutex must be
+// held by the caller.
+func (cache *snapshotCache) pendingVariantResponses(node string, variant string, snapshot Snapshot) []WatchResponse {
+	info, ok := cache.status[node]
+	if !ok {
+		return nil
+	}
+
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+
+	var responses []WatchResponse
+	for id, watch := range info.watches {
+		if cache.servedVariant(node, watch.Request.Node) != variant {
+			continue
+		}
+		version := snapshot.GetVersion(watch.Request.TypeUrl)
+		if version != watch.Request.VersionInfo {
+			responses = append(responses, WatchResponse{
+				Node:      node,
+				WatchID:   id,
+				Watch:     watch.ResponseWatch,
+				Resources: snapshot.GetResourcesAndTTL(watch.Request.TypeUrl),
+				Version:   version,
+			})
+		}
+	}
+	cache.sortResponses(responses)
+	return responses
+}
+
+// forcedVersionSuffix is appended to the versions of a snapshot pushed with ForceRespondAll,
+// followed by a counter.
+const forcedVersionSuffix = "-forced-"
+
+// ForceRespondAll responds to all open sotw watches of a node with its current snapshot,
+// regardless of the versions the watches have. The versions of the snapshot are changed to
+// carry a forced suffix, so that the responses are accepted as new versions by Envoy.
+//
+// This bypasses the consistency guarantees of ADS, as the resources referenced by the pushed
+// resources are not pushed along with them. It is meant for recovering a node in an emergency.
+func (cache *snapshotCache) ForceRespondAll(ctx context.Context, node string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	snapshot, exists := cache.snapshots.Load().get(node)
+	if !exists {
+		return fmt.Errorf("no snapshot found for node %s", node)
+	}
+
+	suffix := forcedVersionSuffix + strconv.FormatInt(atomic.AddInt64(&cache.forcedCount, 1), 10)
+	for i := range snapshot.Resources {
+		version := snapshot.Resources[i].Version
+		if version == "" {
+			continue
+		}
+		if index := strings.Index(version, forcedVersionSuffix); index >= 0 {
+			version = version[:index]
+		}
+		snapshot.Resources[i].Version = version + suffix
+	}
+	snapshot.VersionMap = nil
+	snapshot, err := cache.storeSnapshot(ctx, node, snapshot)
+	if err != nil {
+		return err
+	}
+
+	info, ok := cache.status[node]
+	if !ok {
+		return nil
+	}
+	info.mu.RLock()
+	responses := make([]WatchResponse, 0, len(info.watches))
+	for id, watch := range info.watches {
+		responses = append(responses, WatchResponse{
+			Node:      node,
+			WatchID:   id,
+			Watch:     watch.ResponseWatch,
+			Resources: snapshot.GetResourcesAndTTL(watch.Request.TypeUrl),
+			Version:   snapshot.GetVersion(watch.Request.TypeUrl),
+		})
+	}
+	info.mu.RUnlock()
+
+	cache.log.Warn("force responding open watches", nodeField(node), Field{Key: "watches", Value: len(responses)})
+	return cache.respondWatches(ctx, responses)
+}
+
+// respondWatches responds to the open watches with the respond strategy, and discards the
+// watches which were responded. The cache mutex must be held by the caller.
+func (cache *snapshotCache) respondWatches(ctx context.Context, responses []WatchResponse) error {
+	if len(responses) == 0 {
+		return nil
+	}
+
+	var responded []WatchResponse
+	var mu sync.Mutex
+	err := cache.respondStrategy.Respond(ctx, responses, func(ctx context.Context, response WatchResponse) error {
+		cache.log.Debug("respond open watch with new version", nodeField(response.Node), watchField(response.WatchID),
+			typeField(response.Watch.Request.TypeUrl), namesField(response.Watch.Request.ResourceNames), versionField(response.Version))
+		if err := cache.respond(ctx, response.Watch.Request, response.Watch.Response, response.Resources, response.Version, false); err != nil {
+			return err
+		}
+		mu.Lock()
+		responded = append(responded, response)
+		mu.Unlock()
+		return nil
+	})
+
+	// discard the responded watches
+	for _, response := range responded {
+		if info, ok := cache.status[response.Node]; ok {
+			info.mu.Lock()
+			watch, exists := info.watches[response.WatchID]
+			delete(info.watches, response.WatchID)
+			info.mu.Unlock()
+			if exists {
+				cache.metrics.WatchDuration(response.Node, response.Watch.Request.TypeUrl, time.Since(watch.openedAt))
+			}
+			cache.metrics.WatchClosed(response.Node, response.Watch.Request.TypeUrl)
+		}
+	}
+	return err
+}
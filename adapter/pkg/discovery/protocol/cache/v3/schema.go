@@ -0,0 +1,158 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// SchemaRegistry maps the type URLs of the resources to the proto messages they must be.
+type SchemaRegistry struct {
+	factories map[string]func() proto.Message
+	mu        sync.RWMutex
+}
+
+// NewSchemaRegistry creates a schema registry with the message types of the supported
+// type URLs found in the global proto registry.
+func NewSchemaRegistry() *SchemaRegistry {
+	registry := &SchemaRegistry{factories: make(map[string]func() proto.Message)}
+	for i := wso2_types.ResponseType(0); i < wso2_types.UnknownType; i++ {
+		typeURL, err := GetResponseTypeURL(i)
+		if err != nil {
+			continue
+		}
+		messageType, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+		if err != nil {
+			continue
+		}
+		registry.factories[typeURL] = func() proto.Message { return messageType.New().Interface() }
+	}
+	return registry
+}
+
+// Register sets the factory of the message of a type URL, replacing any previous factory.
+func (r *SchemaRegistry) Register(typeURL string, factory func() proto.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[typeURL] = factory
+}
+
+// Validate checks that each resource of the snapshot is the message registered for its type
+// URL, and that it can be unmarshaled into the message with all its required fields set.
+// A ValidationError lists every malformed resource.
+func (r *SchemaRegistry) Validate(snapshot Snapshot) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var errs []ResourceValidationError
+	for i, resources := range snapshot.Resources {
+		if len(resources.Items) == 0 {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			return err
+		}
+		factory, ok := r.factories[typeURL]
+		for name, item := range resources.Items {
+			if !ok {
+				errs = append(errs, ResourceValidationError{TypeURL: typeURL, Name: name, Err: fmt.Errorf("no schema registered")})
+				continue
+			}
+			if err := validateResource(item.Resource, factory()); err != nil {
+				errs = append(errs, ResourceValidationError{TypeURL: typeURL, Name: name, Err: err})
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].TypeURL != errs[j].TypeURL {
+			return errs[i].TypeURL < errs[j].TypeURL
+		}
+		return errs[i].Name < errs[j].Name
+	})
+	return &ValidationError{Resources: errs}
+}
+
+// validateResource unmarshals the resource into the message of the schema.
+func validateResource(resource, schema proto.Message) error {
+	want := schema.ProtoReflect().Descriptor().FullName()
+	if got := resource.ProtoReflect().Descriptor().FullName(); got != want {
+		return fmt.Errorf("resource is a %s, not a %s", got, want)
+	}
+	data, err := proto.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	if err := proto.Unmarshal(data, schema); err != nil {
+		return err
+	}
+	return proto.CheckInitialized(schema)
+}
+
+// ResourceValidationError is the reason a resource of a snapshot is malformed.
+type ResourceValidationError struct {
+	TypeURL string
+	Name    string
+	Err     error
+}
+
+func (e ResourceValidationError) Error() string {
+	return fmt.Sprintf("resource %q of type %s: %v", e.Name, e.TypeURL, e.Err)
+}
+
+// ValidationError lists the malformed resources of a snapshot.
+type ValidationError struct {
+	Resources []ResourceValidationError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Resources))
+	for _, resource := range e.Resources {
+		messages = append(messages, resource.Error())
+	}
+	return fmt.Sprintf("%d malformed resources: %s", len(e.Resources), strings.Join(messages, "; "))
+}
+
+// WithSchemaRegistry validates the resources of the snapshots against the registry when
+// they are set.
+func WithSchemaRegistry(registry *SchemaRegistry) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.schemas = registry
+	}
+}
+
+// validateSnapshot validates the snapshot of a node, and its resources against the schema
+// registry if one is set.
+func (cache *snapshotCache) validateSnapshot(node string, snapshot Snapshot) error {
+	if err := snapshot.Validate(); err != nil {
+		return fmt.Errorf("invalid snapshot for node %q: %w", node, err)
+	}
+	if cache.schemas != nil {
+		if err := cache.schemas.Validate(snapshot); err != nil {
+			return fmt.Errorf("invalid snapshot for node %q: %w", node, err)
+		}
+	}
+	return nil
+}
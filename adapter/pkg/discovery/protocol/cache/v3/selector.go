@@ -0,0 +1,138 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// selectorSnapshot is a snapshot served to the nodes matching a label selector.
+type selectorSnapshot struct {
+	// prefix is the node ID prefix of the namespace the selector is set for, if any
+	prefix   string
+	selector map[string]string
+	snapshot Snapshot
+}
+
+// matches reports whether the node ID has the prefix of the selector, and each label of the
+// selector is a string field of the node metadata with the same value.
+func (s *selectorSnapshot) matches(node *core.Node) bool {
+	if !strings.HasPrefix(node.GetId(), s.prefix) {
+		return false
+	}
+	fields := node.GetMetadata().GetFields()
+	for key, value := range s.selector {
+		field, ok := fields[key]
+		if !ok {
+			return false
+		}
+		if _, isString := field.GetKind().(*structpb.Value_StringValue); !isString || field.GetStringValue() != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SetSnapshotForSelector sets the snapshot of the nodes whose metadata matches the selector,
+// replacing the snapshot of the same selector, and responds to their open watches.
+func (cache *snapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	return cache.setSnapshotForSelector(ctx, "", selector, snapshot)
+}
+
+// setSnapshotForSelector sets the snapshot of the nodes with the node ID prefix whose metadata
+// matches the selector, replacing the snapshot of the same selector and prefix.
+func (cache *snapshotCache) setSnapshotForSelector(ctx context.Context, prefix string, selector map[string]string, snapshot Snapshot) error {
+	if err := snapshot.Validate(); err != nil {
+		return fmt.Errorf("invalid snapshot for selector %v: %w", selector, err)
+	}
+	if cache.schemas != nil {
+		if err := cache.schemas.Validate(snapshot); err != nil {
+			return fmt.Errorf("invalid snapshot for selector %v: %w", selector, err)
+		}
+	}
+	// the version map is built once, as the snapshot is not stored per node
+	if err := snapshot.ConstructVersionMap(); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var entry *selectorSnapshot
+	for _, s := range cache.selectors {
+		if s.prefix == prefix && reflect.DeepEqual(s.selector, selector) {
+			entry = s
+			break
+		}
+	}
+	if entry == nil {
+		entry = &selectorSnapshot{prefix: prefix, selector: selector}
+		cache.selectors = append(cache.selectors, entry)
+	}
+	entry.snapshot = snapshot
+	cache.log.Debug("set snapshot for selector", Field{Key: "selector", Value: selector})
+
+	var nodes []string
+	var responses []WatchResponse
+	for node, info := range cache.status {
+		if _, ok := cache.snapshots.Load().get(node); ok {
+			continue
+		}
+		if cache.selectorFor(info.GetNode()) != entry {
+			continue
+		}
+		nodes = append(nodes, node)
+		responses = append(responses, cache.pendingResponses(node, snapshot)...)
+	}
+	if err := cache.respondWatches(ctx, responses); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := cache.respondDeltaWatches(ctx, node, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectorFor returns the selector snapshot matching the node with the most labels, or the
+// first one set among those with as many labels. The cache mutex must be held by the caller.
+func (cache *snapshotCache) selectorFor(node *core.Node) *selectorSnapshot {
+	var match *selectorSnapshot
+	for _, s := range cache.selectors {
+		if (match == nil || len(s.selector) > len(match.selector)) && s.matches(node) {
+			match = s
+		}
+	}
+	return match
+}
+
+// snapshotFor returns the snapshot of a node, or the snapshot of the selector matching the
+// node if the node has no snapshot of its own. The cache mutex must be held by the caller.
+func (cache *snapshotCache) snapshotFor(nodeID string, node *core.Node) (Snapshot, bool) {
+	if snapshot, ok := cache.snapshots.Load().get(nodeID); ok {
+		return snapshot, true
+	}
+	if s := cache.selectorFor(node); s != nil {
+		return s.snapshot, true
+	}
+	return Snapshot{}, false
+}
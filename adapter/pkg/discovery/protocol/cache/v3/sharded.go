@@ -0,0 +1,483 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+type shardedSnapshotCache struct {
+	shards []SnapshotCache
+
+	hash NodeHash
+	mu   sync.RWMutex
+}
+
+// ShardedSnapshotCache distributes the nodes across a number of inner caches, so that the
+// operations on different nodes do not contend for the same lock. Each node is routed to the
+// inner cache at the FNV hash of its node ID modulo the number of shards. The node IDs of
+// the requests are computed with the node hash, which the inner caches must use as well.
+//
+// The inner caches are created with the factory. Shards below one are treated as one.
+func ShardedSnapshotCache(shards int, hash NodeHash, factory func() SnapshotCache) SnapshotCache {
+	if shards < 1 {
+		shards = 1
+	}
+	cache := &shardedSnapshotCache{
+		shards: make([]SnapshotCache, shards),
+		hash:   hash,
+	}
+	for i := range cache.shards {
+		cache.shards[i] = factory()
+	}
+	return cache
+}
+
+// shard returns the inner cache of a node.
+func (cache *shardedSnapshotCache) shard(node string) SnapshotCache {
+	h := fnv.New32a()
+	h.Write([]byte(node))
+	return cache.shards[h.Sum32()%uint32(len(cache.shards))]
+}
+
+// nodeShard returns the inner cache of an Envoy node. With a NodeMultiHash, it is the inner
+// cache of the first of the IDs of the node which has a snapshot.
+func (cache *shardedSnapshotCache) nodeShard(node *core.Node) SnapshotCache {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if hash, ok := cache.hash.(NodeMultiHash); ok {
+		for _, id := range hash.IDs(node) {
+			if shard := cache.shard(id); hasSnapshot(shard, id) {
+				return shard
+			}
+		}
+	}
+	return cache.shard(cache.hash.ID(node))
+}
+
+// hasSnapshot reports whether the inner cache has a snapshot of the node, without recording
+// an access of the node.
+func hasSnapshot(shard SnapshotCache, node string) bool {
+	_, exists := shard.ReadView().Get(node)
+	return exists
+}
+
+// CreateWatch creates the watch in the inner cache of the node.
+func (cache *shardedSnapshotCache) CreateWatch(request *envoy_cache.Request, state stream.StreamState, value chan envoy_cache.Response) func() {
+	return cache.nodeShard(request.Node).CreateWatch(request, state, value)
+}
+
+// CreateDeltaWatch creates the delta watch in the inner cache of the node.
+func (cache *shardedSnapshotCache) CreateDeltaWatch(request *envoy_cache.DeltaRequest, state stream.StreamState, value chan envoy_cache.DeltaResponse) func() {
+	return cache.nodeShard(request.GetNode()).CreateDeltaWatch(request, state, value)
+}
+
+// Fetch fetches the resources from the inner cache of the node.
+func (cache *shardedSnapshotCache) Fetch(ctx context.Context, request *envoy_cache.Request) (envoy_cache.Response, error) {
+	return cache.nodeShard(request.Node).Fetch(ctx, request)
+}
+
+// SetSnapshot sets the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	return cache.shard(node).SetSnapshot(ctx, node, snapshot)
+}
+
+// WarmupSnapshot warms up the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	return cache.shard(node).WarmupSnapshot(node, snapshot)
+}
+
+// SetSnapshots sets the snapshots of each inner cache with a single SetSnapshots call.
+func (cache *shardedSnapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	byShard := make(map[SnapshotCache]map[string]Snapshot)
+	for node, snapshot := range snapshots {
+		shard := cache.shard(node)
+		if _, ok := byShard[shard]; !ok {
+			byShard[shard] = make(map[string]Snapshot)
+		}
+		byShard[shard][node] = snapshot
+	}
+
+	var errs []error
+	for shard, shardSnapshots := range byShard {
+		if err := shard.SetSnapshots(ctx, shardSnapshots); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetSnapshotForNodes sets the same snapshot for each of the nodes with SetSnapshots.
+func (cache *shardedSnapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// SetSnapshotForSelector sets the snapshot for the selector in all inner caches, as the
+// nodes matching the selector may be in any of them.
+func (cache *shardedSnapshotCache) SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error {
+	var errs []error
+	for _, shard := range cache.shards {
+		if err := shard.SetSnapshotForSelector(ctx, selector, snapshot); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PatchSnapshot patches the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	return cache.shard(node).PatchSnapshot(ctx, node, typeURL, resources, version)
+}
+
+// GetSnapshot gets the snapshot from the inner cache of the node.
+func (cache *shardedSnapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	return cache.shard(node).GetSnapshot(node)
+}
+
+// ForceRespondAll force responds the open watches in the inner cache of the node.
+func (cache *shardedSnapshotCache) ForceRespondAll(ctx context.Context, node string) error {
+	return cache.shard(node).ForceRespondAll(ctx, node)
+}
+
+// GetSnapshotHistory gets the snapshot history from the inner cache of the node.
+func (cache *shardedSnapshotCache) GetSnapshotHistory(node string) ([]SnapshotHistoryEntry, error) {
+	return cache.shard(node).GetSnapshotHistory(node)
+}
+
+// RequestLog gets the request log from the inner cache of the node.
+func (cache *shardedSnapshotCache) RequestLog(node string) ([]RequestLogEntry, error) {
+	return cache.shard(node).RequestLog(node)
+}
+
+// SnapshotAge gets the snapshot age from the inner cache of the node.
+func (cache *shardedSnapshotCache) SnapshotAge(node string) (time.Duration, error) {
+	return cache.shard(node).SnapshotAge(node)
+}
+
+// GetOrCreateSnapshot gets or creates the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	return cache.shard(node).GetOrCreateSnapshot(ctx, node, factory)
+}
+
+// SetSnapshotVariant sets the snapshot variant in the inner cache of the node.
+func (cache *shardedSnapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	return cache.shard(node).SetSnapshotVariant(ctx, node, variant, snapshot)
+}
+
+// GetSnapshotVariant gets the snapshot variant from the inner cache of the node.
+func (cache *shardedSnapshotCache) GetSnapshotVariant(node string, variant string) (Snapshot, error) {
+	return cache.shard(node).GetSnapshotVariant(node, variant)
+}
+
+// SetSnapshotDryRun runs SetSnapshotDryRun in the inner cache of the node.
+func (cache *shardedSnapshotCache) SetSnapshotDryRun(ctx context.Context, node string, snapshot Snapshot) (DryRunResult, error) {
+	return cache.shard(node).SetSnapshotDryRun(ctx, node, snapshot)
+}
+
+// SetSnapshotWithAudit sets the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error {
+	return cache.shard(node).SetSnapshotWithAudit(ctx, node, snapshot, requestedBy)
+}
+
+// GetAuditLog returns the audit log from the inner cache of the node.
+func (cache *shardedSnapshotCache) GetAuditLog(node string) []AuditEntry {
+	return cache.shard(node).GetAuditLog(node)
+}
+
+// SetSnapshotIfAbsent sets the snapshot in the inner cache of the node if it has none.
+func (cache *shardedSnapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	return cache.shard(node).SetSnapshotIfAbsent(ctx, node, snapshot)
+}
+
+// CompareAndSwapSnapshot swaps the snapshot in the inner cache of the node.
+func (cache *shardedSnapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	return cache.shard(node).CompareAndSwapSnapshot(ctx, node, expected, newSnapshot)
+}
+
+// ClearSnapshot clears the snapshot from the inner cache of the node.
+func (cache *shardedSnapshotCache) ClearSnapshot(node string) {
+	cache.shard(node).ClearSnapshot(node)
+}
+
+// BulkClearSnapshot clears the nodes from their inner caches, with a single call per cache.
+func (cache *shardedSnapshotCache) BulkClearSnapshot(nodes []string) int {
+	shards := make(map[SnapshotCache][]string)
+	for _, node := range nodes {
+		shard := cache.shard(node)
+		shards[shard] = append(shards[shard], node)
+	}
+	cleared := 0
+	for shard, nodes := range shards {
+		cleared += shard.BulkClearSnapshot(nodes)
+	}
+	return cleared
+}
+
+// CreateGlobalWatch opens the global watch in all inner caches.
+func (cache *shardedSnapshotCache) CreateGlobalWatch(typeURL string, value chan GlobalWatchEvent) func() {
+	cancels := make([]func(), 0, len(cache.shards))
+	for _, shard := range cache.shards {
+		cancels = append(cancels, shard.CreateGlobalWatch(typeURL, value))
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// RegisterListener registers the listener in all inner caches.
+func (cache *shardedSnapshotCache) RegisterListener(listener SnapshotChangeListener) {
+	for _, shard := range cache.shards {
+		shard.RegisterListener(listener)
+	}
+}
+
+// UnregisterListener unregisters the listener from all inner caches.
+func (cache *shardedSnapshotCache) UnregisterListener(listener SnapshotChangeListener) {
+	for _, shard := range cache.shards {
+		shard.UnregisterListener(listener)
+	}
+}
+
+// SubscribeNodeState subscribes the handler to the node states of all inner caches.
+func (cache *shardedSnapshotCache) SubscribeNodeState(handler func(nodeID string, event NodeStateEvent)) {
+	for _, shard := range cache.shards {
+		shard.SubscribeNodeState(handler)
+	}
+}
+
+// CreateResourceWatch opens the resource watch in the inner cache of the node.
+func (cache *shardedSnapshotCache) CreateResourceWatch(typeURL string, resourceName string, node string, value chan envoy_cache.Response) func() {
+	return cache.shard(node).CreateResourceWatch(typeURL, resourceName, node, value)
+}
+
+// CreateClearWatch opens the clear watch in the inner cache of the node.
+func (cache *shardedSnapshotCache) CreateClearWatch(node string, value chan struct{}) func() {
+	return cache.shard(node).CreateClearWatch(node, value)
+}
+
+// GetStatusInfo gets the status from the inner cache of the node.
+func (cache *shardedSnapshotCache) GetStatusInfo(node string) StatusInfo {
+	return cache.shard(node).GetStatusInfo(node)
+}
+
+// GetStatusKeys gets the status keys of all inner caches.
+func (cache *shardedSnapshotCache) GetStatusKeys() []string {
+	var out []string
+	for _, shard := range cache.shards {
+		out = append(out, shard.GetStatusKeys()...)
+	}
+	return out
+}
+
+// GarbageCollectStatus garbage collects the status of all inner caches.
+func (cache *shardedSnapshotCache) GarbageCollectStatus() int {
+	removed := 0
+	for _, shard := range cache.shards {
+		removed += shard.GarbageCollectStatus()
+	}
+	return removed
+}
+
+// ListNodes lists the nodes of all inner caches.
+func (cache *shardedSnapshotCache) ListNodes() []string {
+	var out []string
+	for _, shard := range cache.shards {
+		out = append(out, shard.ListNodes()...)
+	}
+	return out
+}
+
+// MemoryUsageBytes sums the memory usage of all inner caches.
+func (cache *shardedSnapshotCache) MemoryUsageBytes() int64 {
+	var usage int64
+	for _, shard := range cache.shards {
+		usage += shard.MemoryUsageBytes()
+	}
+	return usage
+}
+
+// SharedBytes sums the shared bytes of all inner caches. The resources are not shared
+// across the inner caches.
+func (cache *shardedSnapshotCache) SharedBytes() int64 {
+	var shared int64
+	for _, shard := range cache.shards {
+		shared += shard.SharedBytes()
+	}
+	return shared
+}
+
+// ReadView merges the views of all inner caches. Each view is taken separately, so the
+// merged view is not taken at a single instant across the inner caches.
+func (cache *shardedSnapshotCache) ReadView() CacheReadView {
+	var view CacheReadView
+	for _, shard := range cache.shards {
+		shard.ReadView().ForEach(func(node string, snapshot Snapshot) {
+			view.snapshots = view.snapshots.set(node, snapshot)
+		})
+	}
+	return view
+}
+
+// ForeachSnapshot iterates the snapshots of each inner cache in turn. Each inner cache is
+// locked only while its own snapshots are iterated.
+func (cache *shardedSnapshotCache) ForeachSnapshot(fn func(node string, snapshot Snapshot) bool) {
+	for _, shard := range cache.shards {
+		stopped := false
+		shard.ForeachSnapshot(func(node string, snapshot Snapshot) bool {
+			stopped = !fn(node, snapshot)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// WatchCount gets the watch count from the inner cache of the node.
+func (cache *shardedSnapshotCache) WatchCount(node string) (sotw int, delta int) {
+	return cache.shard(node).WatchCount(node)
+}
+
+// WatchDuration gets the watch duration from the inner cache of the node.
+func (cache *shardedSnapshotCache) WatchDuration(node string, watchID int64) time.Duration {
+	return cache.shard(node).WatchDuration(node, watchID)
+}
+
+// SetNodeHash replaces the node hash of the cache and of all inner caches. The status of a
+// node stays in its inner cache, so a node whose new ID belongs to another inner cache has
+// its status orphaned until Envoy sends a new request.
+func (cache *shardedSnapshotCache) SetNodeHash(hash NodeHash) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.hash = hash
+	for _, shard := range cache.shards {
+		shard.SetNodeHash(hash)
+	}
+}
+
+// Ready reports whether any inner cache is ready.
+func (cache *shardedSnapshotCache) Ready() bool {
+	for _, shard := range cache.shards {
+		if shard.Ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats sums the statistics of all inner caches. Each inner cache is locked separately.
+func (cache *shardedSnapshotCache) Stats() SnapshotCacheStats {
+	var stats SnapshotCacheStats
+	for _, shard := range cache.shards {
+		stats.add(shard.Stats())
+	}
+	return stats
+}
+
+// DumpState writes the merged state of all inner caches as JSON.
+func (cache *shardedSnapshotCache) DumpState(w io.Writer) error {
+	dump := CacheDump{
+		Timestamp: time.Now(),
+		Nodes:     make(map[string]*NodeDump),
+	}
+	for _, shard := range cache.shards {
+		var buf bytes.Buffer
+		if err := shard.DumpState(&buf); err != nil {
+			return err
+		}
+		var shardDump CacheDump
+		if err := json.Unmarshal(buf.Bytes(), &shardDump); err != nil {
+			return err
+		}
+		for node, nodeDump := range shardDump.Nodes {
+			dump.Nodes[node] = nodeDump
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+// Drain drains all inner caches.
+func (cache *shardedSnapshotCache) Drain(ctx context.Context) error {
+	var errs []error
+	for _, shard := range cache.shards {
+		if err := shard.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AllResourceNames merges the resource names of all inner caches.
+func (cache *shardedSnapshotCache) AllResourceNames(typeURL string) map[string][]string {
+	out := make(map[string][]string)
+	for _, shard := range cache.shards {
+		for node, names := range shard.AllResourceNames(typeURL) {
+			out[node] = names
+		}
+	}
+	return out
+}
+
+// AllResourceNamesGlobal merges the resource names of all inner caches, without duplicates.
+func (cache *shardedSnapshotCache) AllResourceNamesGlobal(typeURL string) []string {
+	return mergeResourceNames(cache.AllResourceNames(typeURL))
+}
+
+// WatchGroup creates the watch group in the inner cache of the node.
+func (cache *shardedSnapshotCache) WatchGroup(ctx context.Context, node string) *WatchGroup {
+	return cache.shard(node).WatchGroup(ctx, node)
+}
+
+// EvictLRU evicts the least recently accessed nodes of each inner cache down to its share of
+// the limit, rounded up, as the nodes are spread evenly across the inner caches.
+func (cache *shardedSnapshotCache) EvictLRU(maxNodes int) int {
+	share := (maxNodes + len(cache.shards) - 1) / len(cache.shards)
+	evicted := 0
+	for _, shard := range cache.shards {
+		evicted += shard.EvictLRU(share)
+	}
+	return evicted
+}
+
+// Reset resets all inner caches.
+func (cache *shardedSnapshotCache) Reset(ctx context.Context) error {
+	var errs []error
+	for _, shard := range cache.shards {
+		if err := shard.Reset(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var _ SnapshotCache = &shardedSnapshotCache{}
@@ -16,15 +16,22 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/log"
 	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+	"google.golang.org/protobuf/proto"
 )
 
 // SnapshotCache is a snapshot-based cache that maintains a single versioned
@@ -43,49 +50,398 @@ type SnapshotCache interface {
 
 	// SetSnapshot sets a response snapshot for a node. For ADS, the snapshots
 	// should have distinct versions and be internally consistent (e.g. all
-	// referenced resources must be included in the snapshot).
+	// referenced resources must be included in the snapshot). The snapshot is
+	// validated before it is set, and the validation error is returned if any.
 	//
 	// This method will cause the server to respond to all open watches, for which
 	// the version differs from the snapshot version.
 	SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error
 
-	// GetSnapshots gets the snapshot for a node.
+	// WarmupSnapshot stores a snapshot for a node without responding to any watches, so that
+	// the node is responded immediately when it connects. The snapshot is marked as warmed
+	// up in the status of the node until a snapshot is set with SetSnapshot.
+	WarmupSnapshot(node string, snapshot Snapshot) error
+
+	// SetSnapshots sets the response snapshots for multiple nodes at once. All
+	// snapshots are applied and the open watches are responded under a single
+	// lock, so that no node observes a partially applied update.
+	//
+	// An error is returned for each node that failed, joined into a single error.
+	SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error
+
+	// SetSnapshotForNodes sets the same snapshot for each of the nodes, in the same way as
+	// SetSnapshots. An error is returned for each node that failed, joined into a single error.
+	SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error
+
+	// SetSnapshotForSelector sets a snapshot for the nodes whose metadata has a string field
+	// for each label of the selector with the same value. A node set with its own snapshot
+	// is served its own snapshot instead. When several selectors match a node, the one with
+	// the most labels is used. An empty selector matches all nodes.
+	SetSnapshotForSelector(ctx context.Context, selector map[string]string, snapshot Snapshot) error
+
+	// PatchSnapshot merges resources of a single type into the snapshot of a node, replacing
+	// the resources with the same names and keeping the others. The version of the type is
+	// set to the given version, so that only the watches for the type are responded.
+	PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error
+
+	// GetSnapshots gets the snapshot for a node. The returned snapshot shares its resource
+	// maps with the cache, so code which modifies it must use a copy made with CloneSnapshot.
 	GetSnapshot(node string) (Snapshot, error)
 
+	// ForceRespondAll responds to all open sotw watches of a node with its current snapshot,
+	// regardless of their versions, and closes them. The snapshot versions are given a forced
+	// suffix. This bypasses the consistency guarantees of ADS and is meant for emergencies only.
+	ForceRespondAll(ctx context.Context, node string) error
+
+	// GetSnapshotHistory returns the previous snapshots of a node, from the oldest to the
+	// newest, with the time each was set.
+	GetSnapshotHistory(node string) ([]SnapshotHistoryEntry, error)
+
+	// RequestLog returns the last sotw discovery requests of a node, from the oldest to the
+	// newest, to debug the ACK and NACK cycle of the node.
+	RequestLog(node string) ([]RequestLogEntry, error)
+
+	// SnapshotAge returns the time elapsed since the snapshot of a node was last set.
+	SnapshotAge(node string) (time.Duration, error)
+
+	// GetOrCreateSnapshot returns the snapshot of a node. If the node has no snapshot,
+	// the snapshot returned by factory is set for the node and returned. The lookup and
+	// the update are done under a single lock, so concurrent callers cannot overwrite
+	// each other.
+	GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error)
+
+	// CompareAndSwapSnapshot sets the snapshot of a node only if the versions of all
+	// resource types of the current snapshot are the same as those of the expected
+	// snapshot. A node without a snapshot matches an empty expected snapshot.
+	// It reports whether the snapshot was set.
+	CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error)
+
+	// SetSnapshotVariant sets a named variant of the snapshot of a node, such as a canary
+	// snapshot, served to the watches whose node metadata selects the variant with the
+	// NodeVariantSelector of the cache. The empty variant is the snapshot of the node.
+	SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error
+
+	// GetSnapshotVariant gets a named variant of the snapshot of a node.
+	GetSnapshotVariant(node string, variant string) (Snapshot, error)
+
+	// SetSnapshotDryRun validates the snapshot of a node and returns the resource changes
+	// and the open watches that setting it would respond to, without changing the cache.
+	SetSnapshotDryRun(ctx context.Context, node string, snapshot Snapshot) (DryRunResult, error)
+
+	// SetSnapshotWithAudit sets the snapshot of a node as SetSnapshot does, recording the
+	// requester in the audit log of the node.
+	SetSnapshotWithAudit(ctx context.Context, node string, snapshot Snapshot, requestedBy string) error
+
+	// GetAuditLog returns the versions of each type set for a node, with the time they were
+	// set and their requester, from the oldest to the newest. The requester of a snapshot
+	// set with a context from WithRequestedBy is recorded as well.
+	GetAuditLog(node string) []AuditEntry
+
+	// SetSnapshotIfAbsent sets the snapshot of a node only if the node has no snapshot,
+	// checking and setting under a single lock. It reports whether the snapshot was set.
+	SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error)
+
 	// ClearSnapshot removes all status and snapshot information associated with a node.
 	ClearSnapshot(node string)
 
+	// BulkClearSnapshot clears the nodes which have a snapshot, like ClearSnapshot, and
+	// returns the number of nodes cleared.
+	BulkClearSnapshot(nodes []string) int
+
+	// MemoryUsageBytes returns the sum of the sizes of the snapshots of all nodes, measured
+	// as the encoded sizes of their resources.
+	MemoryUsageBytes() int64
+
+	// SharedBytes returns the size of the resource copies saved by sharing the resources
+	// across the snapshots of the nodes with WithResourceDeduplication.
+	SharedBytes() int64
+
+	// CreateGlobalWatch opens a watch on a type across all nodes, for admin tooling. An event
+	// is sent without blocking whenever the type changes in the snapshot of any node. It
+	// returns a function to cancel the watch.
+	CreateGlobalWatch(typeURL string, value chan GlobalWatchEvent) func()
+
+	// RegisterListener registers a listener called asynchronously after each snapshot of a
+	// node is set or cleared.
+	RegisterListener(listener SnapshotChangeListener)
+
+	// UnregisterListener stops calling a registered listener.
+	UnregisterListener(listener SnapshotChangeListener)
+
+	// SubscribeNodeState registers a handler called asynchronously when a node connects,
+	// disconnects, acknowledges every type of its snapshot, or falls behind it.
+	SubscribeNodeState(handler func(nodeID string, event NodeStateEvent))
+
+	// CreateResourceWatch opens a watch on a single resource of a node, which is responded
+	// only when the resource is added, modified or removed in the snapshot of the node.
+	// It returns a function to cancel the watch.
+	CreateResourceWatch(typeURL string, resourceName string, node string, value chan envoy_cache.Response) func()
+
+	// CreateClearWatch opens a watch which is responded once when the snapshot of a node is
+	// cleared, or immediately if the node has no snapshot. It returns a function to cancel
+	// the watch.
+	CreateClearWatch(node string, value chan struct{}) func()
+
 	// GetStatusInfo retrieves status information for a node ID.
 	GetStatusInfo(string) StatusInfo
 
 	// GetStatusKeys retrieves node IDs for all statuses.
 	GetStatusKeys() []string
+
+	// GarbageCollectStatus removes the status of the nodes which have no snapshot and no
+	// open watches, such as the nodes which disconnected without their snapshot being set,
+	// and returns the number of nodes removed. It is meant to be called periodically.
+	GarbageCollectStatus() int
+
+	// ListNodes retrieves the node IDs which currently have a snapshot.
+	ListNodes() []string
+
+	// ForeachSnapshot calls fn for the snapshot of each node until fn returns false, like
+	// sync.Map.Range. The snapshots are iterated as they were at a single instant, and fn
+	// may set or clear snapshots.
+	ForeachSnapshot(fn func(node string, snapshot Snapshot) bool)
+
+	// ReadView returns the snapshots of all nodes at a single instant, so that a node listed
+	// in the view can still be read after its snapshot is cleared from the cache.
+	ReadView() CacheReadView
+
+	// WatchCount returns the number of open sotw and delta watches of a node.
+	WatchCount(node string) (sotw int, delta int)
+
+	// WatchDuration returns the time elapsed since an open sotw watch of a node was
+	// created, or zero if the watch is not open.
+	WatchDuration(node string, watchID int64) time.Duration
+
+	// SetNodeHash replaces the hashing function for Envoy nodes. The status entries of
+	// the nodes are moved to the IDs computed by the new hash. Snapshots remain under
+	// the node IDs they were set with.
+	//
+	// Open watches keep the node ID they were created with, so calling this method while
+	// many watches are open may orphan those watches until Envoy sends a new request.
+	// When several statuses are moved to the same node ID only one is kept, and the open
+	// watches of the others are closed.
+	SetNodeHash(hash NodeHash)
+
+	// Ready reports whether a non-empty snapshot is set for at least one node.
+	Ready() bool
+
+	// DumpState writes the state of all nodes in the cache as JSON, for offline debugging.
+	DumpState(w io.Writer) error
+
+	// Stats returns the statistics of the cache, computed under a single lock.
+	Stats() SnapshotCacheStats
+
+	// Drain closes all open watches so that the Envoy nodes reconnect before the server
+	// shuts down. The watches created after the cache is drained are rejected.
+	Drain(ctx context.Context) error
+
+	// AllResourceNames returns the names of the resources of a type in the snapshot of each
+	// node, indexed by node IDs.
+	AllResourceNames(typeURL string) map[string][]string
+
+	// AllResourceNamesGlobal returns the names of the resources of a type in the snapshots of
+	// all nodes, without duplicates.
+	AllResourceNamesGlobal(typeURL string) []string
+
+	// WatchGroup creates a group of watches of a node which are cancelled together.
+	WatchGroup(ctx context.Context, node string) *WatchGroup
+
+	// EvictLRU clears the least recently accessed nodes until at most maxNodes nodes have a
+	// snapshot, and returns the number of evicted nodes.
+	EvictLRU(maxNodes int) int
+
+	// Reset closes all open watches and clears the snapshots and the status of all nodes.
+	Reset(ctx context.Context) error
 }
 
 type snapshotCache struct {
-	// watchCount is an atomic counters incremented for sotw watch. They need to
+	// watchCount and deltaWatchCount are atomic counters incremented for each watch respectively. They need to
 	// be the first fields in the struct to guarantee 64-bit alignment,
 	// which is a requirement for atomic operations on 64-bit operands to work on
 	// 32-bit machines.
-	watchCount int64
+	watchCount      int64
+	deltaWatchCount int64
+	// forcedCount is the atomic counter of the snapshots pushed with ForceRespondAll
+	forcedCount int64
+	// watchesCreated and watchesResponded are the atomic lifetime counters of the sotw and
+	// delta watch requests, and of the responses sent to them
+	watchesCreated   int64
+	watchesResponded int64
 
-	log log.Logger
+	log StructuredLogger
 
 	// ads flag to hold responses until all resources are named
 	ads bool
 
-	// snapshots are cached resources indexed by node IDs
-	snapshots map[string]Snapshot
+	// federation strips the authority of the federated resource names in the requests
+	federation bool
+
+	// ordering orders the resources in the sotw responses
+	ordering ResourceOrdering
+
+	// schemas validates the resources of the snapshots, if set
+	schemas *SchemaRegistry
+
+	// resources holds the resources shared across the snapshots, if they are deduplicated
+	resources *resourceStore
+
+	// selectors are the snapshots of the nodes matching label selectors, in the order set
+	selectors []*selectorSnapshot
+
+	// auditLogs are the versions set for each node, kept when the node is cleared
+	auditLogs map[string][]AuditEntry
+
+	// variants are the named snapshot variants indexed by node IDs and variant names, served
+	// to the nodes selected by the variant selector
+	variants        map[string]map[string]Snapshot
+	variantSelector NodeVariantSelector
+
+	// snapshots are cached resources indexed by node IDs. The map is replaced under the cache
+	// mutex on each change, and may be loaded without the mutex.
+	snapshots atomic.Pointer[snapshotMap]
+
+	// lastSetTime is the time each snapshot was last set in Unix nanoseconds, indexed by node
+	// IDs. The times are atomic, so that the time of a node can be refreshed under the read
+	// lock.
+	lastSetTime map[string]*int64
+
+	// history holds up to historySize previous snapshots, indexed by node IDs
+	history     map[string]*ringBuffer[SnapshotHistoryEntry]
+	historySize int
+
+	// requestLogs hold up to requestLogSize last requests, indexed by node IDs
+	requestLogs    map[string]*ringBuffer[RequestLogEntry]
+	requestLogSize int
+
+	// autoVersion assigns a version from versionCounters to the resource types set without a version
+	autoVersion bool
+
+	// versionCounters are the monotonic snapshot version counters indexed by node IDs
+	versionCounters map[string]*int64
+
+	// snapshotSizes are the sizes of the snapshots in bytes indexed by node IDs, which add up
+	// to memoryUsage
+	snapshotSizes map[string]int64
+	memoryUsage   int64
+
+	// maxNodes is the number of nodes with snapshots above which the least recently accessed
+	// nodes are evicted. Zero disables the limit.
+	maxNodes int
+
+	// maxSnapshotSize is the size limit of a snapshot in bytes. Zero disables the limit.
+	maxSnapshotSize int64
+
+	// snapshotTTL is the duration after which a snapshot that is not set again is cleared.
+	// Zero disables the expiry.
+	snapshotTTL time.Duration
+
+	// watchTimeout is the duration after which the open watches of a node that has not sent
+	// a request are closed, checked every watchReapInterval. Zero disables the timeout.
+	watchTimeout      time.Duration
+	watchReapInterval time.Duration
 
 	// status information for all nodes indexed by node IDs
 	status map[string]*statusInfo
 
+	// resourceWatches are the open watches on single resources, indexed by node IDs
+	resourceWatches map[string]map[int64]resourceWatch
+
+	// clearWatches are the open watches on the clearing of snapshots, indexed by node IDs
+	clearWatches map[string]map[int64]chan struct{}
+
+	// globalWatches are the open watches on types across all nodes, indexed by watch IDs
+	globalWatches map[int64]globalWatch
+
 	// hash is the hashing function for Envoy nodes
 	hash NodeHash
 
+	// namespaceHashes are the hashing functions of the nodes of the namespaces, indexed by the
+	// node ID prefixes of the namespaces
+	namespaceHashes map[string]NodeHash
+
+	// metrics records the watch lifecycle events
+	metrics Metrics
+
+	// respondStrategy responds to the open watches when snapshots are set
+	respondStrategy RespondStrategy
+
+	// respondPriority orders the responses to the open watches by their type URLs, where a
+	// lower value is responded earlier
+	respondPriority map[string]int
+
+	// events receives the snapshot change events, if set
+	events EventBus
+
+	// listeners are called after each change of a snapshot
+	listeners listenerPool
+
+	// onSet is called for each snapshot set, with the cache mutex held, if set. Unlike the
+	// listeners it is never dropped, so it must not block.
+	onSet func(node string)
+
+	// ctx stops the background goroutines of the cache when it is cancelled
+	ctx context.Context
+
+	// draining holds the node ID prefixes drained, the empty one once the whole cache is
+	// drained, after which the new watches of their nodes are closed
+	draining map[string]bool
+
 	mu sync.RWMutex
 }
 
+// SnapshotCacheOption configures optional behaviour of the snapshot cache.
+type SnapshotCacheOption func(*snapshotCache)
+
+// WithContext ties the background goroutines of the cache, which expire the snapshots, reap
+// the watches and call the listeners, to the context, so that they stop when it is cancelled.
+// The cache remains usable, without these background tasks. The default is
+// context.Background, with which the goroutines run for the lifetime of the process.
+func WithContext(ctx context.Context) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if ctx != nil {
+			cache.ctx = ctx
+		}
+	}
+}
+
+// WithMetrics sets the recorder for watch lifecycle events. Passing nil disables metrics.
+func WithMetrics(metrics Metrics) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		if metrics != nil {
+			cache.metrics = metrics
+		}
+	}
+}
+
+// WithEventBus publishes an event to the bus whenever a snapshot is set or cleared.
+func WithEventBus(bus EventBus) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.events = bus
+	}
+}
+
+// WithSnapshotTTL clears the snapshot of a node if it is not set again within the ttl.
+// The expiry is checked periodically, every half of the ttl. A zero ttl disables the expiry.
+func WithSnapshotTTL(ttl time.Duration) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.snapshotTTL = ttl
+	}
+}
+
+// WithWatchResponseTimeout closes the stale watches of a node if the node has not sent a
+// watch request within the timeout. A watch is stale if it waits for a version other than the
+// one of the snapshot of the node, such as a watch of a node without a snapshot. The response
+// channels of the watches are closed, which signals the server to end the stream so that
+// Envoy reconnects. The watches of up to date nodes, and the watches of the types sharing the
+// muxed response channel of the stream, are never closed. The watches are checked every
+// interval. A zero timeout disables it.
+func WithWatchResponseTimeout(timeout time.Duration, interval time.Duration) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.watchTimeout = timeout
+		cache.watchReapInterval = interval
+	}
+}
+
 // NewSnapshotCache initializes a simple cache.
 //
 // ADS flag forces a delay in responding to streaming requests until all
@@ -97,26 +453,86 @@ type snapshotCache struct {
 // is OK.
 //
 // Logger is optional.
-func NewSnapshotCache(ads bool, hash NodeHash, logger log.Logger) SnapshotCache {
-	return newSnapshotCache(ads, hash, logger)
+func NewSnapshotCache(ads bool, hash NodeHash, logger log.Logger, opts ...SnapshotCacheOption) SnapshotCache {
+	return newSnapshotCache(ads, hash, logger, opts...)
 }
 
-func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger) *snapshotCache {
-	if logger == nil {
-		logger = log.NewDefaultLogger()
+func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger, opts ...SnapshotCacheOption) *snapshotCache {
+	cache := &snapshotCache{
+		log:             NewStructuredLogger(logger),
+		ads:             ads,
+		lastSetTime:     make(map[string]*int64),
+		history:         make(map[string]*ringBuffer[SnapshotHistoryEntry]),
+		historySize:     defaultHistorySize,
+		requestLogs:     make(map[string]*ringBuffer[RequestLogEntry]),
+		requestLogSize:  defaultRequestLogSize,
+		versionCounters: make(map[string]*int64),
+		snapshotSizes:   make(map[string]int64),
+		status:          make(map[string]*statusInfo),
+		resourceWatches: make(map[string]map[int64]resourceWatch),
+		clearWatches:    make(map[string]map[int64]chan struct{}),
+		globalWatches:   make(map[int64]globalWatch),
+		hash:            hash,
+		metrics:         nopMetrics{},
+		respondStrategy: SequentialRespondStrategy{},
+		respondPriority: DefaultRespondPriority,
+		ordering:        DefaultResourceOrdering{},
+		ctx:             context.Background(),
+		listeners: listenerPool{
+			workers: defaultListenerWorkers,
+			timeout: defaultListenerTimeout,
+		},
 	}
 
-	cache := &snapshotCache{
-		log:       logger,
-		ads:       ads,
-		snapshots: make(map[string]Snapshot),
-		status:    make(map[string]*statusInfo),
-		hash:      hash,
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	if cache.snapshotTTL > 0 {
+		go cache.expireSnapshots()
+	}
+	if cache.watchTimeout > 0 {
+		go cache.reapWatches()
 	}
 
 	return cache
 }
 
+// NewSnapshotCacheWithAutoVersioning initializes a simple cache which versions the snapshots
+// on behalf of the caller. The cache keeps a monotonic counter per node, which is incremented
+// on every SetSnapshot call for the node. Each resource type of the snapshot which has an
+// empty version is set with the counter value as the version.
+//
+// The remaining parameters are the same as in NewSnapshotCache.
+func NewSnapshotCacheWithAutoVersioning(ads bool, hash NodeHash, logger log.Logger, opts ...SnapshotCacheOption) SnapshotCache {
+	cache := newSnapshotCache(ads, hash, logger, opts...)
+	cache.autoVersion = true
+	return cache
+}
+
+// NewSnapshotCacheWithFixtures initializes a simple cache with the snapshots of the nodes
+// already set, which is convenient in tests. Each snapshot is validated, and the validation
+// error of the first invalid snapshot is returned without creating the cache.
+//
+// The remaining parameters are the same as in NewSnapshotCache.
+func NewSnapshotCacheWithFixtures(ads bool, hash NodeHash, logger log.Logger, snapshots map[string]Snapshot, opts ...SnapshotCacheOption) (SnapshotCache, error) {
+	for node, snapshot := range snapshots {
+		if err := snapshot.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid snapshot for node %q: %w", node, err)
+		}
+	}
+
+	cache := newSnapshotCache(ads, hash, logger, opts...)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for node, snapshot := range snapshots {
+		if _, err := cache.storeSnapshot(context.Background(), node, snapshot); err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
 // NewSnapshotCacheWithHeartbeating initializes a simple cache that sends periodic heartbeat
 // responses for resources with a TTL.
 //
@@ -131,33 +547,27 @@ func newSnapshotCache(ads bool, hash NodeHash, logger log.Logger) *snapshotCache
 // Logger is optional.
 //
 // The context provides a way to cancel the heartbeating routine, while the heartbeatInterval
-// parameter controls how often heartbeating occurs.
+// parameter controls how often heartbeating occurs. A node is sent heartbeats more often if
+// half of the shortest TTL of its resources is below the interval, so that the resources do
+// not expire between the heartbeats. The interval can be changed later with
+// SetHeartbeatInterval.
 //
 // Unused by the adapter at the moment.
-func NewSnapshotCacheWithHeartbeating(ctx context.Context, ads bool, hash NodeHash, logger log.Logger, heartbeatInterval time.Duration) SnapshotCache {
-	cache := newSnapshotCache(ads, hash, logger)
-	go func() {
-		t := time.NewTicker(heartbeatInterval)
-
-		for {
-			select {
-			case <-t.C:
-				cache.mu.Lock()
-				for node := range cache.status {
-					// TODO(snowp): Omit heartbeats if a real response has been sent recently.
-					cache.sendHeartbeats(ctx, node)
-				}
-				cache.mu.Unlock()
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+func NewSnapshotCacheWithHeartbeating(ctx context.Context, ads bool, hash NodeHash, logger log.Logger, heartbeatInterval time.Duration, opts ...SnapshotCacheOption) HeartbeatingSnapshotCache {
+	cache := &heartbeatingSnapshotCache{
+		snapshotCache: newSnapshotCache(ads, hash, logger, opts...),
+		intervals:     make(chan time.Duration),
+		done:          ctx.Done(),
+		pending:       make(map[string]struct{}),
+		wake:          make(chan struct{}, 1),
+	}
+	cache.snapshotCache.onSet = cache.schedule
+	go cache.run(ctx, heartbeatInterval)
 	return cache
 }
 
 func (cache *snapshotCache) sendHeartbeats(ctx context.Context, node string) {
-	snapshot := cache.snapshots[node]
+	snapshot, _ := cache.snapshots.Load().get(node)
 	if info, ok := cache.status[node]; ok {
 		info.mu.Lock()
 		for id, watch := range info.watches {
@@ -176,90 +586,452 @@ func (cache *snapshotCache) sendHeartbeats(ctx context.Context, node string) {
 			if len(resourcesWithTTL) == 0 {
 				continue
 			}
-			cache.log.Debugf("respond open watch %d%v with heartbeat for version %q", id, watch.Request.ResourceNames, version)
+			cache.log.Debug("respond open watch with heartbeat", nodeField(node), watchField(id), typeField(watch.Request.TypeUrl), namesField(watch.Request.ResourceNames), versionField(version))
 			err := cache.respond(ctx, watch.Request, watch.Response, resourcesWithTTL, version, true)
 			if err != nil {
-				cache.log.Errorf("received error when attempting to respond to watches: %v", err)
+				cache.log.Error("received error when attempting to respond to watches", nodeField(node), watchField(id), errorField(err))
 			}
 
 			// The watch must be deleted and we must rely on the client to ack this response to create a new watch.
 			delete(info.watches, id)
+			cache.metrics.WatchDuration(node, watch.Request.TypeUrl, time.Since(watch.openedAt))
+			cache.metrics.WatchClosed(node, watch.Request.TypeUrl)
 		}
 		info.mu.Unlock()
 	}
 }
 
-// SetSnapshotCacheContext updates a snapshot for a node.
+// SetSnapshot updates a snapshot for a node. A snapshot equal to the current snapshot of the
+// node is skipped under the read lock, as it would not respond to any watch, so that setting
+// an unchanged snapshot does not block the readers of the cache.
 func (cache *snapshotCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	cache.mu.RLock()
+	skipped, err := cache.skipUnchanged(node, snapshot)
+	cache.mu.RUnlock()
+	if skipped || err != nil {
+		return err
+	}
+
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	// update the existing entry
-	cache.snapshots[node] = snapshot
+	// the snapshot may have been set by another caller since the read lock was released
+	if skipped, err := cache.skipUnchanged(node, snapshot); skipped || err != nil {
+		return err
+	}
+
+	_, exists := cache.snapshots.Load().get(node)
+	if err := cache.setSnapshot(ctx, node, snapshot); err != nil {
+		return err
+	}
+	if !exists && cache.maxNodes > 0 {
+		cache.evictLRU(cache.maxNodes, node, "")
+	}
+	return nil
+}
+
+// skipUnchanged reports whether the snapshot is unchanged from the current snapshot of a node,
+// in which case it only validates the snapshot and refreshes its set time. The cache mutex
+// must be held by the caller, at least for reading.
+func (cache *snapshotCache) skipUnchanged(node string, snapshot Snapshot) (bool, error) {
+	if !cache.unchanged(node, snapshot) {
+		return false, nil
+	}
+	if err := cache.validateSnapshot(node, snapshot); err != nil {
+		return false, err
+	}
+	// the snapshot is still set, which refreshes its age and TTL
+	cache.touchSetTime(node)
+	cache.log.Debug("skipped setting unchanged snapshot", nodeField(node))
+	cache.metrics.SnapshotUpdateSkipped(node)
+	return true, nil
+}
+
+// setTime returns the time the snapshot of a node was last set. The cache mutex must be held
+// by the caller, at least for reading.
+func (cache *snapshotCache) setTime(node string) (time.Time, bool) {
+	nanos, ok := cache.lastSetTime[node]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, atomic.LoadInt64(nanos)), true
+}
+
+// touchSetTime sets the time the snapshot of a node was last set to now. The time of a node
+// with a snapshot can be refreshed with the cache mutex held for reading, and the mutex must
+// be held for writing otherwise.
+func (cache *snapshotCache) touchSetTime(node string) {
+	now := time.Now().UnixNano()
+	if nanos, ok := cache.lastSetTime[node]; ok {
+		atomic.StoreInt64(nanos, now)
+		return
+	}
+	cache.lastSetTime[node] = &now
+}
+
+// unchanged reports whether the snapshot holds the same versions and resources as the current
+// snapshot of a node, so that the watches need not be responded. The types without a version
+// are not compared by version when the cache assigns versions. A warmed up snapshot is never
+// considered unchanged, so that setting it responds the watches. The cache mutex must be held
+// by the caller.
+func (cache *snapshotCache) unchanged(node string, snapshot Snapshot) bool {
+	current, ok := cache.snapshots.Load().get(node)
+	if !ok {
+		return false
+	}
+	if info, ok := cache.status[node]; ok && info.IsWarmedUp() {
+		return false
+	}
+	for i := range snapshot.Resources {
+		version := snapshot.Resources[i].Version
+		if cache.autoVersion && version == "" {
+			continue
+		}
+		if version != current.Resources[i].Version {
+			return false
+		}
+	}
+	return Diff(current, snapshot).Empty()
+}
+
+// assignVersion sets the next version of the node to the resource types of the snapshot
+// which have no version. The counters are never reset, so that a node does not receive a
+// version it has already acknowledged after its snapshot is cleared.
+func (cache *snapshotCache) assignVersion(node string, snapshot *Snapshot) {
+	counter, ok := cache.versionCounters[node]
+	if !ok {
+		counter = new(int64)
+		cache.versionCounters[node] = counter
+	}
+	version := strconv.FormatInt(atomic.AddInt64(counter, 1), 10)
+
+	for i := range snapshot.Resources {
+		if snapshot.Resources[i].Version == "" {
+			snapshot.Resources[i].Version = version
+		}
+	}
+}
+
+// WarmupSnapshot stores the snapshot of a node without responding to the open watches.
+func (cache *snapshotCache) WarmupSnapshot(node string, snapshot Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err := cache.validateSnapshot(node, snapshot); err != nil {
+		return err
+	}
+	size, err := cache.checkSnapshotSize(node, snapshot)
+	if err != nil {
+		return err
+	}
+	if cache.resources != nil {
+		if snapshot, err = cache.resources.intern(node, snapshot); err != nil {
+			return err
+		}
+	}
+	if cache.autoVersion {
+		cache.assignVersion(node, &snapshot)
+	}
+
+	current, _ := cache.snapshots.Load().get(node)
+	cache.recordHistory(node)
+	cache.recordAudit(node, "", current, snapshot)
+	cache.putSnapshot(node, snapshot)
+	cache.touchSetTime(node)
+	cache.trackSnapshotSize(node, size)
+
+	info, ok := cache.status[node]
+	if !ok {
+		// the node metadata is set when the node creates its first watch
+		info = newStatusInfo(nil)
+		cache.status[node] = info
+	}
+	info.mu.Lock()
+	info.warmedUp = true
+	info.mu.Unlock()
+
+	cache.log.Debug("warmed up snapshot", nodeField(node))
+	return nil
+}
+
+// SetSnapshots updates the snapshots for a set of nodes under a single lock.
+func (cache *snapshotCache) SetSnapshots(ctx context.Context, snapshots map[string]Snapshot) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var errs []error
+	stored := make(map[string]Snapshot, len(snapshots))
+	previous := make(map[string]Snapshot, len(snapshots))
+	for node, snapshot := range snapshots {
+		previous[node], _ = cache.snapshots.Load().get(node)
+		snapshot, err := cache.storeSnapshot(ctx, node, snapshot)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored[node] = snapshot
+	}
+
+	// the open watches of all nodes are responded together, so that the respond strategy
+	// can batch the watches of the same type across nodes
+	var responses []WatchResponse
+	for node, snapshot := range stored {
+		responses = append(responses, cache.pendingResponses(node, snapshot)...)
+	}
+	if err := cache.respondWatches(ctx, responses); err != nil {
+		errs = append(errs, err)
+	}
+
+	for node, snapshot := range stored {
+		if err := cache.respondDeltaWatches(ctx, node, snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("failed to respond delta watches of node %q: %w", node, err))
+		}
+		if err := cache.respondResourceWatches(ctx, node, previous[node], snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("failed to respond resource watches of node %q: %w", node, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetSnapshotForNodes updates the snapshots of a set of nodes to the same snapshot under a single lock.
+func (cache *snapshotCache) SetSnapshotForNodes(ctx context.Context, nodes []string, snapshot Snapshot) error {
+	return cache.SetSnapshots(ctx, snapshotsForNodes(nodes, snapshot))
+}
+
+// snapshotsForNodes indexes the same snapshot by each of the nodes.
+func snapshotsForNodes(nodes []string, snapshot Snapshot) map[string]Snapshot {
+	snapshots := make(map[string]Snapshot, len(nodes))
+	for _, node := range nodes {
+		snapshots[node] = snapshot
+	}
+	return snapshots
+}
+
+// setSnapshot updates the snapshot of a node and responds to the open watches.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) setSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	previous, _ := cache.snapshots.Load().get(node)
+	snapshot, err := cache.storeSnapshot(ctx, node, snapshot)
+	if err != nil {
+		return err
+	}
 
 	// trigger existing watches for which version changed
+	if err := cache.respondWatches(ctx, cache.pendingResponses(node, snapshot)); err != nil {
+		return err
+	}
+	if err := cache.respondDeltaWatches(ctx, node, snapshot); err != nil {
+		return err
+	}
+	return cache.respondResourceWatches(ctx, node, previous, snapshot)
+}
+
+// storeSnapshot validates and stores the snapshot of a node, and returns the stored snapshot.
+// The versions are recorded in the audit log with the requester carried by the context.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) storeSnapshot(ctx context.Context, node string, snapshot Snapshot) (Snapshot, error) {
+	if err := cache.validateSnapshot(node, snapshot); err != nil {
+		return snapshot, err
+	}
+	size, err := cache.checkSnapshotSize(node, snapshot)
+	if err != nil {
+		return snapshot, err
+	}
+	if cache.resources != nil {
+		if snapshot, err = cache.resources.intern(node, snapshot); err != nil {
+			return snapshot, err
+		}
+	}
+
+	if cache.autoVersion {
+		cache.assignVersion(node, &snapshot)
+	}
+
+	// notify the global watches before the watches of the node are responded
+	current, _ := cache.snapshots.Load().get(node)
+	cache.notifyGlobalWatches(node, current, snapshot)
+
+	// update the existing entry
+	cache.recordHistory(node)
+	cache.recordAudit(node, requestedBy(ctx), current, snapshot)
+	cache.putSnapshot(node, snapshot)
+	cache.touchSetTime(node)
+	cache.trackSnapshotSize(node, size)
 	if info, ok := cache.status[node]; ok {
 		info.mu.Lock()
-		defer info.mu.Unlock()
-		for id, watch := range info.watches {
-			version := snapshot.GetVersion(watch.Request.TypeUrl)
-			if version != watch.Request.VersionInfo {
-				cache.log.Debugf("respond open watch %d%v with new version %q", id, watch.Request.ResourceNames, version)
+		info.warmedUp = false
+		if info.state == NodeSynced && !ackedAll(info, snapshot) {
+			cache.setNodeState(node, info, NodeStale)
+		}
+		info.mu.Unlock()
+	}
+	cache.publish(SnapshotSet, node, &snapshot)
+	cache.notifySet(node, current, snapshot)
+	if cache.onSet != nil {
+		cache.onSet(node)
+	}
+	return snapshot, nil
+}
 
-				resources := snapshot.GetResourcesAndTTL(watch.Request.TypeUrl)
-				err := cache.respond(ctx, watch.Request, watch.Response, resources, version, false)
-				if err != nil {
-					return err
-				}
+// respondDeltaWatches responds to the open delta watches of a node for which the resources
+// changed. The cache mutex must be held by the caller.
+func (cache *snapshotCache) respondDeltaWatches(ctx context.Context, node string, snapshot Snapshot) error {
+	info, ok := cache.status[node]
+	if !ok {
+		return nil
+	}
 
-				// discard the watch
-				delete(info.watches, id)
-			}
-		}
+	info.mu.Lock()
+	defer info.mu.Unlock()
 
-		// We only calculate version hashes when using delta. We don't
-		// want to do this when using SOTW so we can avoid unnecessary
-		// computational cost if not using delta.
-		if len(info.deltaWatches) > 0 {
-			err := snapshot.ConstructVersionMap()
-			if err != nil {
-				return err
-			}
-		}
+	// We only calculate version hashes when using delta. We don't
+	// want to do this when using SOTW so we can avoid unnecessary
+	// computational cost if not using delta.
+	if len(info.deltaWatches) == 0 {
+		return nil
+	}
+	err := snapshot.ConstructVersionMap()
+	if err != nil {
+		return err
+	}
+	if _, ok := cache.snapshots.Load().get(node); ok {
+		cache.putSnapshot(node, snapshot)
+	}
 
-		// process our delta watches
-		for id, watch := range info.deltaWatches {
-			res, err := cache.respondDelta(
-				ctx,
-				&snapshot,
-				watch.Request,
-				watch.Response,
-				watch.StreamState,
-			)
-			if err != nil {
-				return err
-			}
-			// If we detect a nil response here, that means there has been no state change
-			// so we don't want to respond or remove any existing resource watches
-			if res != nil {
-				delete(info.deltaWatches, id)
-			}
+	// process our delta watches
+	for id, watch := range info.deltaWatches {
+		res, err := cache.respondDelta(
+			ctx,
+			&snapshot,
+			watch.Request,
+			watch.Response,
+			watch.StreamState,
+		)
+		if err != nil {
+			return err
+		}
+		// If we detect a nil response here, that means there has been no state change
+		// so we don't want to respond or remove any existing resource watches
+		if res != nil {
+			delete(info.deltaWatches, id)
 		}
 	}
 
 	return nil
 }
 
-// GetSnapshots gets the snapshot for a node, and returns an error if not found.
+// PatchSnapshot merges the resources of a type into the snapshot for a node.
+func (cache *snapshotCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	index := GetResponseType(typeURL)
+	if index == wso2_types.UnknownType {
+		return errors.New("unknown resource type: " + typeURL)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	snapshot, _ := cache.snapshots.Load().get(node)
+	// the resource map is copied as the current snapshot may be held by readers
+	items := make(map[string]types.ResourceWithTTL, len(snapshot.Resources[index].Items)+len(resources))
+	for name, resource := range snapshot.Resources[index].Items {
+		items[name] = resource
+	}
+	for name, resource := range resources {
+		items[name] = resource
+	}
+	snapshot.Resources[index] = envoy_cache.Resources{Version: version, Items: items}
+	// the resource hashes must be recomputed for the patched resources
+	snapshot.VersionMap = nil
+
+	return cache.setSnapshot(ctx, node, snapshot)
+}
+
+// GetSnapshots gets the snapshot for a node, and returns an error if not found. The snapshot
+// is loaded without the cache mutex, so the read is not blocked while snapshots are set.
 func (cache *snapshotCache) GetSnapshot(node string) (Snapshot, error) {
+	snap, ok := cache.snapshots.Load().get(node)
+	if !ok {
+		return Snapshot{}, fmt.Errorf("no snapshot found for node %s", node)
+	}
+	// the access is not recorded while a writer holds the mutex, rather than waiting for it
+	if cache.mu.TryRLock() {
+		cache.recordAccess(node)
+		cache.mu.RUnlock()
+	}
+	return snap, nil
+}
+
+// SnapshotAge returns the time since the snapshot for a node was set, and returns an error if not found.
+func (cache *snapshotCache) SnapshotAge(node string) (time.Duration, error) {
 	cache.mu.RLock()
 	defer cache.mu.RUnlock()
 
-	snap, ok := cache.snapshots[node]
+	setTime, ok := cache.setTime(node)
 	if !ok {
-		return Snapshot{}, fmt.Errorf("no snapshot found for node %s", node)
+		return 0, fmt.Errorf("no snapshot found for node %s", node)
 	}
-	return snap, nil
+	return time.Since(setTime), nil
+}
+
+// GetOrCreateSnapshot gets the snapshot for a node, setting the snapshot created by factory if not found.
+func (cache *snapshotCache) GetOrCreateSnapshot(ctx context.Context, node string, factory func() Snapshot) (Snapshot, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if snap, ok := cache.snapshots.Load().get(node); ok {
+		return snap, nil
+	}
+
+	snap := factory()
+	if err := cache.setSnapshot(ctx, node, snap); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot, _ := cache.snapshots.Load().get(node)
+	return snapshot, nil
+}
+
+// CompareAndSwapSnapshot sets the snapshot for a node if the current snapshot has the expected versions.
+func (cache *snapshotCache) CompareAndSwapSnapshot(ctx context.Context, node string, expected, newSnapshot Snapshot) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	current, _ := cache.snapshots.Load().get(node)
+	if !EqualSnapshot(current, expected) {
+		return false, nil
+	}
+	if err := cache.setSnapshot(ctx, node, newSnapshot); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetSnapshotIfAbsent sets the snapshot for a node if the node has no snapshot.
+func (cache *snapshotCache) SetSnapshotIfAbsent(ctx context.Context, node string, snapshot Snapshot) (bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if _, ok := cache.snapshots.Load().get(node); ok {
+		return false, nil
+	}
+	if err := cache.setSnapshot(ctx, node, snapshot); err != nil {
+		return false, err
+	}
+	if cache.maxNodes > 0 {
+		cache.evictLRU(cache.maxNodes, node, "")
+	}
+	return true, nil
+}
+
+// EqualSnapshot reports whether the versions of all resource types are the same in both
+// snapshots. The resources are not compared, as a snapshot with the same versions is
+// expected to hold the same resources.
+func EqualSnapshot(a, b Snapshot) bool {
+	for i := range a.Resources {
+		if a.Resources[i].Version != b.Resources[i].Version {
+			return false
+		}
+	}
+	return true
 }
 
 // ClearSnapshot clears snapshot and info for a node.
@@ -267,14 +1039,95 @@ func (cache *snapshotCache) ClearSnapshot(node string) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
-	delete(cache.snapshots, node)
+	cache.clearSnapshot(node)
+}
+
+// BulkClearSnapshot clears the snapshot and info of the nodes with the cache mutex held once.
+// The nodes without a snapshot are skipped, so their watches are left open.
+func (cache *snapshotCache) BulkClearSnapshot(nodes []string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cleared := 0
+	for _, node := range nodes {
+		if _, ok := cache.snapshots.Load().get(node); !ok {
+			continue
+		}
+		cache.clearSnapshot(node)
+		cleared++
+	}
+	return cleared
+}
+
+// clearSnapshot clears snapshot and info for a node. The cache mutex must be held by the caller.
+func (cache *snapshotCache) clearSnapshot(node string) {
+	if info, ok := cache.status[node]; ok {
+		info.mu.RLock()
+		for _, watch := range info.watches {
+			cache.metrics.WatchClosed(node, watch.Request.TypeUrl)
+		}
+		info.mu.RUnlock()
+	}
+
+	cache.removeSnapshot(node)
+	delete(cache.lastSetTime, node)
+	delete(cache.history, node)
+	delete(cache.requestLogs, node)
 	delete(cache.status, node)
+	delete(cache.variants, node)
+	cache.trackSnapshotSize(node, -1)
+	if cache.resources != nil {
+		cache.resources.release(node)
+	}
+	cache.metrics.NodeRemoved(node)
+	cache.publish(SnapshotCleared, node, nil)
+	cache.notifyClear(node)
+	cache.respondClearWatches(node)
+}
+
+// expireSnapshots periodically clears the snapshots which have not been set within the snapshot TTL.
+func (cache *snapshotCache) expireSnapshots() {
+	t := time.NewTicker(cache.snapshotTTL / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-cache.ctx.Done():
+			return
+		}
+		cache.mu.Lock()
+		for node := range cache.lastSetTime {
+			setTime, _ := cache.setTime(node)
+			if age := time.Since(setTime); age > cache.snapshotTTL {
+				cache.log.Warn("clearing snapshot as it was not refreshed within the TTL", nodeField(node), Field{Key: "age", Value: age})
+				cache.clearSnapshot(node)
+			}
+		}
+		cache.mu.Unlock()
+	}
+}
+
+// publish sends a snapshot event to the event bus of the cache, if one is set.
+func (cache *snapshotCache) publish(eventType SnapshotEventType, node string, snapshot *Snapshot) {
+	if cache.events == nil {
+		return
+	}
+	if snapshot != nil {
+		// the event must not share the pointer with the caller
+		snap := *snapshot
+		snapshot = &snap
+	}
+	cache.events.Publish(SnapshotEvent{Type: eventType, Node: node, Snapshot: snapshot, Timestamp: time.Now()})
 }
 
 // nameSet creates a map from a string slice to value true.
-func nameSet(names []string) map[string]bool {
+func nameSet(names []string, federation bool) map[string]bool {
 	set := make(map[string]bool)
 	for _, name := range names {
+		if federation {
+			name = stripAuthority(name)
+		}
 		set[name] = true
 	}
 	return set
@@ -290,13 +1143,79 @@ func superset(names map[string]bool, resources map[string]types.ResourceWithTTL)
 	return nil
 }
 
+// nodeID returns the node ID of a node. With a NodeMultiHash, it is the first of the IDs of
+// the node which has a snapshot, or the ID of the node if none has.
+func (cache *snapshotCache) nodeID(node *core.Node) string {
+	prefix, hash, node := cache.hashOf(node)
+	if multiHash, ok := hash.(NodeMultiHash); ok {
+		snapshots := cache.snapshots.Load()
+		for _, id := range multiHash.IDs(node) {
+			if _, exists := snapshots.get(prefix + id); exists {
+				return prefix + id
+			}
+		}
+	}
+	return prefix + hash.ID(node)
+}
+
+// hashOf returns the hash of a node. The node of a namespace is hashed without the node ID
+// prefix of the namespace, with the hash set for the namespace or else the hash of the cache,
+// and the prefix is returned with the node without it. The cache mutex must be held by the
+// caller, for reading at least.
+func (cache *snapshotCache) hashOf(node *core.Node) (string, NodeHash, *core.Node) {
+	id := node.GetId()
+	if i := strings.Index(id, RegionSeparator); i >= 0 {
+		prefix := id[:i+len(RegionSeparator)]
+		if hash, ok := cache.namespaceHashes[prefix]; ok {
+			if hash == nil {
+				hash = cache.hash
+			}
+			unscoped := proto.Clone(node).(*core.Node)
+			unscoped.Id = id[len(prefix):]
+			return prefix, hash, unscoped
+		}
+	}
+	return "", cache.hash, node
+}
+
+// drained reports whether the watches of a node are rejected, as the cache or the namespace
+// of the node is drained. The cache mutex must be held by the caller, for reading at least.
+func (cache *snapshotCache) drained(node string) bool {
+	for prefix := range cache.draining {
+		if strings.HasPrefix(node, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // CreateWatch returns a watch for an xDS request.
 func (cache *snapshotCache) CreateWatch(request *envoy_cache.Request, streamState stream.StreamState, value chan envoy_cache.Response) func() {
-	nodeID := cache.hash.ID(request.Node)
-
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 
+	nodeID := cache.nodeID(request.Node)
+	if watchID := cache.openWatch(nodeID, request, streamState, value); watchID != 0 {
+		return cache.cancelWatch(nodeID, watchID)
+	}
+	return nil
+}
+
+// openWatch responds to the request of a node or opens a watch for it, and returns the ID of
+// the open watch, or zero if the request was responded or rejected. The cache mutex must be
+// held by the caller.
+func (cache *snapshotCache) openWatch(nodeID string, request *envoy_cache.Request, streamState stream.StreamState, value chan envoy_cache.Response) int64 {
+	cache.logRequest(nodeID, request)
+	atomic.AddInt64(&cache.watchesCreated, 1)
+
+	if cache.drained(nodeID) {
+		cache.log.Debug("rejecting watch as the cache is drained", nodeField(nodeID), typeField(request.TypeUrl))
+		if closeable(request.TypeUrl) {
+			close(value)
+		}
+		return 0
+	}
+
 	info, ok := cache.status[nodeID]
 	if !ok {
 		info = newStatusInfo(request.Node)
@@ -306,11 +1225,43 @@ func (cache *snapshotCache) CreateWatch(request *envoy_cache.Request, streamStat
 	// update last watch request time
 	info.mu.Lock()
 	info.lastWatchRequestTime = time.Now()
+	info.lastAccessTime = info.lastWatchRequestTime
+	info.recordRequest(request.TypeUrl, info.lastWatchRequestTime)
+	if info.node == nil {
+		info.node = request.Node
+	}
+	if info.state == NodeDisconnected {
+		cache.setNodeState(nodeID, info, NodeConnected)
+	}
 	info.mu.Unlock()
 
-	snapshot, exists := cache.snapshots[nodeID]
+	snapshot, exists := cache.servedSnapshot(nodeID, request.Node)
 	version := snapshot.GetVersion(request.TypeUrl)
 
+	if exists && request.ErrorDetail == nil && version != "" && request.VersionInfo == version {
+		info.mu.Lock()
+		cache.recordACK(nodeID, info, snapshot, request.TypeUrl, version)
+		info.mu.Unlock()
+	}
+
+	if request.ErrorDetail != nil {
+		// the request rejects the last response sent to the node, which may be older than the
+		// current snapshot; the current version is assumed if no response was sent by this cache
+		rejected := info.lastSent(request.TypeUrl)
+		if rejected == "" {
+			rejected = version
+		}
+		cache.log.Warn("response rejected by the node", nodeField(nodeID), typeField(request.TypeUrl), versionField(rejected),
+			Field{Key: "error", Value: request.ErrorDetail.GetMessage()})
+		info.recordNACK(request.TypeUrl, rejected, request.ErrorDetail.GetMessage())
+		info.mu.Lock()
+		if info.state == NodeSynced {
+			cache.setNodeState(nodeID, info, NodeStale)
+		}
+		info.mu.Unlock()
+		cache.metrics.NACKReceived(nodeID, request.TypeUrl)
+	}
+
 	if exists {
 		knownResourceNames := streamState.GetKnownResourceNames(request.TypeUrl)
 		diff := []string{}
@@ -320,18 +1271,21 @@ func (cache *snapshotCache) CreateWatch(request *envoy_cache.Request, streamStat
 			}
 		}
 
-		cache.log.Debugf("nodeID %q requested %s%v and known %v. Diff %v", nodeID,
-			request.TypeUrl, request.ResourceNames, knownResourceNames, diff)
+		cache.log.Debug("requested resources compared to the known resources", nodeField(nodeID), typeField(request.TypeUrl),
+			namesField(request.ResourceNames), Field{Key: "known_resource_names", Value: knownResourceNames}, Field{Key: "diff", Value: diff})
 
 		if len(diff) > 0 {
 			resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
 			for _, name := range diff {
+				if cache.federation {
+					name = stripAuthority(name)
+				}
 				if _, exists := resources[name]; exists {
 					if err := cache.respond(context.Background(), request, value, resources, version, false); err != nil {
-						cache.log.Errorf("failed to send a response for %s%v to nodeID %q: %s", request.TypeUrl,
-							request.ResourceNames, nodeID, err)
+						cache.log.Error("failed to send a response", nodeField(nodeID), typeField(request.TypeUrl),
+							namesField(request.ResourceNames), errorField(err))
 					}
-					return nil
+					return 0
 				}
 			}
 		}
@@ -340,72 +1294,155 @@ func (cache *snapshotCache) CreateWatch(request *envoy_cache.Request, streamStat
 	// if the requested version is up-to-date or missing a response, leave an open watch
 	if !exists || request.VersionInfo == version {
 		watchID := cache.nextWatchID()
-		cache.log.Debugf("open watch %d for %s%v from nodeID %q, version %q", watchID, request.TypeUrl, request.ResourceNames, nodeID, request.VersionInfo)
+		cache.log.Debug("open watch", nodeField(nodeID), watchField(watchID), typeField(request.TypeUrl), namesField(request.ResourceNames), versionField(request.VersionInfo))
 
 		info.mu.Lock()
-		info.watches[watchID] = envoy_cache.ResponseWatch{Request: request, Response: value}
+		info.watches[watchID] = responseWatch{
+			ResponseWatch: envoy_cache.ResponseWatch{Request: request, Response: value},
+			openedAt:      time.Now(),
+		}
 		info.mu.Unlock()
-		return cache.cancelWatch(nodeID, watchID)
+		cache.metrics.WatchOpened(nodeID, request.TypeUrl)
+		return watchID
 	}
 
 	// otherwise, the watch may be responded immediately
 	resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
 	if err := cache.respond(context.Background(), request, value, resources, version, false); err != nil {
-		cache.log.Errorf("failed to send a response for %s%v to nodeID %q: %s", request.TypeUrl,
-			request.ResourceNames, nodeID, err)
+		cache.log.Error("failed to send a response", nodeField(nodeID), typeField(request.TypeUrl),
+			namesField(request.ResourceNames), errorField(err))
 	}
 
-	return nil
+	return 0
 }
 
 func (cache *snapshotCache) nextWatchID() int64 {
 	return atomic.AddInt64(&cache.watchCount, 1)
 }
 
+// reapWatches periodically closes the stale watches of the nodes which have not sent a
+// request within the watch timeout.
+func (cache *snapshotCache) reapWatches() {
+	t := time.NewTicker(cache.watchReapInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-cache.ctx.Done():
+			return
+		}
+		cache.mu.Lock()
+		for node, info := range cache.status {
+			info.mu.Lock()
+			if len(info.watches) > 0 && time.Since(info.lastWatchRequestTime) > cache.watchTimeout {
+				for id, watch := range info.watches {
+					if !cache.staleWatch(node, watch) {
+						continue
+					}
+					cache.log.Info("closing watch as no request was received within the watch timeout", nodeField(node),
+						watchField(id), typeField(watch.Request.TypeUrl), Field{Key: "last_request_time", Value: info.lastWatchRequestTime})
+					close(watch.Response)
+					delete(info.watches, id)
+					cache.metrics.WatchCancelled(node, watch.Request.TypeUrl)
+					cache.metrics.WatchClosed(node, watch.Request.TypeUrl)
+				}
+				if len(info.watches) == 0 {
+					cache.setNodeState(node, info, NodeDisconnected)
+				}
+			}
+			info.mu.Unlock()
+		}
+		cache.mu.Unlock()
+	}
+}
+
+// staleWatch reports whether an open watch of a node may be closed by the reaper. A watch of
+// the version of the snapshot served to the node is the steady state of a healthy idle node,
+// which sends no request until the snapshot changes, so it is kept, and the watches of a
+// disconnected stream are cancelled by the server. Only the dedicated response channels are
+// closed, as closing the muxed channel shared by a stream would not end the stream. The cache
+// mutex must be held by the caller.
+func (cache *snapshotCache) staleWatch(node string, watch responseWatch) bool {
+	if !closeable(watch.Request.TypeUrl) {
+		return false
+	}
+	snapshot, exists := cache.servedSnapshot(node, watch.Request.Node)
+	return !exists || watch.Request.VersionInfo != snapshot.GetVersion(watch.Request.TypeUrl)
+}
+
 // cancellation function for cleaning stale watches
 func (cache *snapshotCache) cancelWatch(nodeID string, watchID int64) func() {
 	return func() {
-		// uses the cache mutex
-		cache.mu.RLock()
-		defer cache.mu.RUnlock()
-		if info, ok := cache.status[nodeID]; ok {
-			info.mu.Lock()
+		// the cache mutex is write locked, so that the watch cannot be removed while the
+		// watches of the node are iterated by a snapshot update
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		cache.removeWatch(nodeID, watchID)
+	}
+}
+
+// removeWatch removes an open watch of a node. The cache mutex must be held by the caller.
+func (cache *snapshotCache) removeWatch(nodeID string, watchID int64) {
+	if info, ok := cache.status[nodeID]; ok {
+		info.mu.Lock()
+		if watch, exists := info.watches[watchID]; exists {
 			delete(info.watches, watchID)
-			info.mu.Unlock()
+			cache.metrics.WatchCancelled(nodeID, watch.Request.TypeUrl)
+			cache.metrics.WatchClosed(nodeID, watch.Request.TypeUrl)
+			if len(info.watches) == 0 {
+				cache.setNodeState(nodeID, info, NodeDisconnected)
+			}
 		}
+		info.mu.Unlock()
 	}
 }
 
 // Respond to a watch with the snapshot value. The value channel should have capacity not to block.
 // TODO(kuat) do not respond always, see issue https://github.com/envoyproxy/go-control-plane/issues/46
-func (cache *snapshotCache) respond(ctx context.Context, request *envoy_cache.Request, value chan envoy_cache.Response, resources map[string]types.ResourceWithTTL, version string, heartbeat bool) error {
+func (cache *snapshotCache) respond(ctx context.Context, request *envoy_cache.Request, value chan envoy_cache.Response, resources map[string]types.ResourceWithTTL, version string, heartbeat bool) (err error) {
+	// a send on a channel that was closed by the caller panics, which must not crash the
+	// goroutine setting the snapshot, so the panic is logged and returned as an error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to respond to the watch: %v", r)
+			cache.log.Error("recovered from a panic while responding", nodeField(cache.nodeID(request.Node)), typeField(request.TypeUrl), errorField(err))
+		}
+	}()
+
 	// for ADS, the request names must match the snapshot names
 	// if they do not, then the watch is never responded, and it is expected that envoy makes another request
 	if len(request.ResourceNames) != 0 && cache.ads {
-		if err := superset(nameSet(request.ResourceNames), resources); err != nil {
-			cache.log.Warnf("ADS mode: not responding to request: %v", err)
+		if err := superset(nameSet(request.ResourceNames, cache.federation), resources); err != nil {
+			cache.log.Debug("ADS mode: not responding to request", nodeField(cache.nodeID(request.Node)), typeField(request.TypeUrl), errorField(err))
 			return nil
 		}
 	}
 
-	cache.log.Debugf("respond %s%v version %q with version %q", request.TypeUrl, request.ResourceNames, request.VersionInfo, version)
+	cache.log.Debug("respond", nodeField(cache.nodeID(request.Node)), typeField(request.TypeUrl), namesField(request.ResourceNames),
+		Field{Key: "request_version", Value: request.VersionInfo}, versionField(version))
 
 	select {
-	case value <- createResponse(ctx, request, resources, version, heartbeat):
+	case value <- createResponse(responseContext(ctx), request, resources, version, heartbeat, cache.federation, cache.ordering):
+		if info, ok := cache.status[cache.nodeID(request.Node)]; ok {
+			info.recordSent(request.TypeUrl, version)
+		}
+		cache.metrics.WatchResponded(cache.nodeID(request.Node), request.TypeUrl)
+		atomic.AddInt64(&cache.watchesResponded, 1)
 		return nil
 	case <-ctx.Done():
 		return context.Canceled
 	}
 }
 
-func createResponse(ctx context.Context, request *envoy_cache.Request, resources map[string]types.ResourceWithTTL, version string, heartbeat bool) envoy_cache.Response {
+func createResponse(ctx context.Context, request *envoy_cache.Request, resources map[string]types.ResourceWithTTL, version string, heartbeat bool, federation bool, ordering ResourceOrdering) envoy_cache.Response {
 	filtered := make([]types.ResourceWithTTL, 0, len(resources))
 
 	// Reply only with the requested resources. Envoy may ask each resource
 	// individually in a separate stream. It is ok to reply with the same version
 	// on separate streams since requests do not share their response versions.
 	if len(request.ResourceNames) != 0 {
-		set := nameSet(request.ResourceNames)
+		set := nameSet(request.ResourceNames, federation)
 		for name, resource := range resources {
 			if set[name] {
 				filtered = append(filtered, resource)
@@ -420,48 +1457,148 @@ func createResponse(ctx context.Context, request *envoy_cache.Request, resources
 	return &envoy_cache.RawResponse{
 		Request:   request,
 		Version:   version,
-		Resources: filtered,
+		Resources: ordering.Order(request.TypeUrl, filtered),
 		Heartbeat: heartbeat,
 		Ctx:       ctx,
 	}
 }
 
 // CreateDeltaWatch returns a watch for a delta xDS request which implements the Simple SnapshotCache.
-// Unused in adapter implementation.
 func (cache *snapshotCache) CreateDeltaWatch(request *envoy_cache.DeltaRequest, state stream.StreamState, value chan envoy_cache.DeltaResponse) func() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	nodeID := cache.nodeID(request.GetNode())
+	t := request.GetTypeUrl()
+	atomic.AddInt64(&cache.watchesCreated, 1)
+
+	if cache.drained(nodeID) {
+		cache.log.Debug("rejecting delta watch as the cache is drained", nodeField(nodeID), typeField(t))
+		return nil
+	}
+
+	info, ok := cache.status[nodeID]
+	if !ok {
+		info = newStatusInfo(request.GetNode())
+		cache.status[nodeID] = info
+	}
+
+	// update last watch request time
+	info.mu.Lock()
+	info.lastDeltaWatchRequestTime = time.Now()
+	info.recordRequest(t, info.lastDeltaWatchRequestTime)
+	if info.node == nil {
+		info.node = request.GetNode()
+	}
+	info.mu.Unlock()
+
+	// find the current cache snapshot for the provided node
+	snapshot, exists := cache.snapshotFor(nodeID, request.GetNode())
+
+	// There are three different cases that leads to a delayed watch trigger:
+	// - no snapshot exists for the requested nodeID
+	// - a snapshot exists, but we failed to initialize its version map
+	// - we attempted to issue a response, but the caller is already up to date
+	delayedResponse := !exists
+	if exists {
+		err := snapshot.ConstructVersionMap()
+		if err != nil {
+			cache.log.Error("failed to compute version for snapshot resources inline", nodeField(nodeID), errorField(err))
+		} else if _, ok := cache.snapshots.Load().get(nodeID); ok {
+			// keep the version map so that it is not recomputed for every delta request
+			cache.putSnapshot(nodeID, snapshot)
+		}
+		response, err := cache.respondDelta(context.Background(), &snapshot, request, value, state)
+		if err != nil {
+			cache.log.Error("failed to respond with delta response", nodeField(nodeID), typeField(t), errorField(err))
+		}
+
+		delayedResponse = response == nil
+	}
+
+	if delayedResponse {
+		watchID := cache.nextDeltaWatchID()
+
+		if exists {
+			cache.log.Debug("open delta watch", nodeField(nodeID), watchField(watchID), typeField(t), Field{Key: "subscribed_resource_names", Value: state.GetSubscribedResourceNames()}, versionField(snapshot.GetVersion(t)))
+		} else {
+			cache.log.Debug("open delta watch", nodeField(nodeID), watchField(watchID), typeField(t), Field{Key: "subscribed_resource_names", Value: state.GetSubscribedResourceNames()})
+		}
+
+		info.SetDeltaResponseWatch(watchID, envoy_cache.DeltaResponseWatch{Request: request, Response: value, StreamState: state})
+		return cache.cancelDeltaWatch(nodeID, watchID)
+	}
 
 	return nil
 }
 
 // Respond to a delta watch with the provided snapshot value. If the response is nil, there has been no state change.
-// Unused in adapter implementation.
 func (cache *snapshotCache) respondDelta(ctx context.Context, snapshot *Snapshot, request *envoy_cache.DeltaRequest, value chan envoy_cache.DeltaResponse, state stream.StreamState) (*envoy_cache.RawDeltaResponse, error) {
+	resp := createDeltaResponse(responseContext(ctx), request, state, resourceContainer{
+		resourceMap:   snapshot.GetResources(request.GetTypeUrl()),
+		versionMap:    snapshot.GetVersionMap(request.GetTypeUrl()),
+		systemVersion: snapshot.GetVersion(request.GetTypeUrl()),
+	})
+
+	// Only send a response if there were changes
+	// We want to respond immediately for the first wildcard request in a stream, even if the response is empty
+	// otherwise, envoy won't complete initialization
+	if len(resp.Resources) > 0 || len(resp.RemovedResources) > 0 || (state.IsWildcard() && state.IsFirst()) {
+		cache.log.Debug("sending delta response", nodeField(request.GetNode().GetId()), typeField(request.GetTypeUrl()),
+			namesField(GetResourceNames(resp.Resources)), Field{Key: "removed_resource_names", Value: resp.RemovedResources},
+			Field{Key: "wildcard", Value: state.IsWildcard()})
+		select {
+		case value <- resp:
+			atomic.AddInt64(&cache.watchesResponded, 1)
+			return resp, nil
+		case <-ctx.Done():
+			return resp, context.Canceled
+		}
+	}
 	return nil, nil
 }
 
+func (cache *snapshotCache) nextDeltaWatchID() int64 {
+	return atomic.AddInt64(&cache.deltaWatchCount, 1)
+}
+
+// cancellation function for cleaning stale delta watches
+func (cache *snapshotCache) cancelDeltaWatch(nodeID string, watchID int64) func() {
+	return func() {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		if info, ok := cache.status[nodeID]; ok {
+			info.mu.Lock()
+			delete(info.deltaWatches, watchID)
+			info.mu.Unlock()
+		}
+	}
+}
+
 // Fetch implements the cache fetch function.
 // Fetch is called on multiple streams, so responding to individual names with the same version works.
 func (cache *snapshotCache) Fetch(ctx context.Context, request *envoy_cache.Request) (envoy_cache.Response, error) {
-	nodeID := cache.hash.ID(request.Node)
-
 	cache.mu.RLock()
 	defer cache.mu.RUnlock()
 
-	if snapshot, exists := cache.snapshots[nodeID]; exists {
+	nodeID := cache.nodeID(request.Node)
+	cache.recordAccess(nodeID)
+
+	if snapshot, exists := cache.servedSnapshot(nodeID, request.Node); exists {
 		// Respond only if the request version is distinct from the current snapshot state.
 		// It might be beneficial to hold the request since Envoy will re-attempt the refresh.
 		version := snapshot.GetVersion(request.TypeUrl)
 		if request.VersionInfo == version {
-			cache.log.Warnf("skip fetch: version up to date")
+			cache.log.Debug("skip fetch as the version is up to date", nodeField(nodeID), typeField(request.TypeUrl), versionField(version))
 			return nil, &types.SkipFetchError{}
 		}
 
 		resources := snapshot.GetResourcesAndTTL(request.TypeUrl)
-		out := createResponse(ctx, request, resources, version, false)
+		out := createResponse(ctx, request, resources, version, false, cache.federation, cache.ordering)
 		return out, nil
 	}
 
-	return nil, fmt.Errorf("missing snapshot for %q", nodeID)
+	return nil, &NodeNotFoundError{Node: nodeID}
 }
 
 // GetStatusInfo retrieves the status info for the node.
@@ -471,7 +1608,7 @@ func (cache *snapshotCache) GetStatusInfo(node string) StatusInfo {
 
 	info, exists := cache.status[node]
 	if !exists {
-		cache.log.Warnf("node does not exist")
+		cache.log.Debug("node does not exist", nodeField(node))
 		return nil
 	}
 
@@ -490,3 +1627,141 @@ func (cache *snapshotCache) GetStatusKeys() []string {
 
 	return out
 }
+
+// GarbageCollectStatus removes the status and the request log of the nodes without a
+// snapshot or open watches.
+func (cache *snapshotCache) GarbageCollectStatus() int {
+	return cache.garbageCollectStatus("")
+}
+
+// garbageCollectStatus removes the status and the request log of the nodes with the node ID
+// prefix without a snapshot or open watches.
+func (cache *snapshotCache) garbageCollectStatus(prefix string) int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	removed := 0
+	for node, info := range cache.status {
+		if !strings.HasPrefix(node, prefix) {
+			continue
+		}
+		if _, ok := cache.snapshots.Load().get(node); ok {
+			continue
+		}
+		if sotw, delta := info.WatchCount(); sotw > 0 || delta > 0 {
+			continue
+		}
+		delete(cache.status, node)
+		delete(cache.requestLogs, node)
+		cache.metrics.NodeRemoved(node)
+		removed++
+	}
+	if removed > 0 {
+		cache.log.Debug("garbage collected node status", Field{Key: "removed", Value: removed})
+	}
+	return removed
+}
+
+// WatchCount returns the number of open sotw and delta watches of a node.
+func (cache *snapshotCache) WatchCount(node string) (sotw int, delta int) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	info, exists := cache.status[node]
+	if !exists {
+		return 0, 0
+	}
+
+	return info.WatchCount()
+}
+
+// WatchDuration returns the time elapsed since an open sotw watch of a node was created.
+func (cache *snapshotCache) WatchDuration(node string, watchID int64) time.Duration {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	info, exists := cache.status[node]
+	if !exists {
+		return 0
+	}
+
+	return info.watchDuration(watchID)
+}
+
+// SetNodeHash replaces the node hash and migrates the status entries to the new node IDs.
+func (cache *snapshotCache) SetNodeHash(hash NodeHash) {
+	cache.setNodeHash("", hash)
+}
+
+// setNodeHash replaces the node hash of the namespace with the node ID prefix, or the node
+// hash of the cache for the empty prefix, and migrates the status entries of its nodes.
+func (cache *snapshotCache) setNodeHash(prefix string, hash NodeHash) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if prefix == "" {
+		cache.hash = hash
+	} else {
+		cache.namespaceHashes[prefix] = hash
+	}
+
+	status := make(map[string]*statusInfo, len(cache.status))
+	for id, info := range cache.status {
+		newID := id
+		if node := info.GetNode(); node != nil && strings.HasPrefix(id, prefix) {
+			namespace, nodeHash, node := cache.hashOf(node)
+			newID = namespace + nodeHash.ID(node)
+		}
+		if _, exists := status[newID]; exists {
+			// the watches of the dropped status would never be responded, so they are closed
+			// for the nodes to reconnect under the new node ID
+			cache.closeNodeWatches(id, info)
+			cache.log.Warn("dropping status of node as its new node ID is already taken by the new node hash", nodeField(id), Field{Key: "new_node_id", Value: newID})
+			continue
+		}
+		status[newID] = info
+	}
+	cache.status = status
+}
+
+// Ready reports whether any node has a snapshot with at least one resource.
+func (cache *snapshotCache) Ready() bool {
+	return cache.ready("")
+}
+
+// ready reports whether any node with the node ID prefix has a snapshot with at least one
+// resource.
+func (cache *snapshotCache) ready(prefix string) bool {
+	ready := false
+	cache.snapshots.Load().forEach(func(node string, snapshot Snapshot) bool {
+		if !strings.HasPrefix(node, prefix) {
+			return true
+		}
+		for _, resources := range snapshot.Resources {
+			if len(resources.Items) > 0 {
+				ready = true
+				return false
+			}
+		}
+		return true
+	})
+	return ready
+}
+
+// ListNodes retrieves all node IDs in the snapshot map.
+func (cache *snapshotCache) ListNodes() []string {
+	snapshots := cache.snapshots.Load()
+	out := make([]string, 0, snapshots.len())
+	snapshots.forEach(func(id string, _ Snapshot) bool {
+		out = append(out, id)
+		return true
+	})
+
+	return out
+}
+
+// ForeachSnapshot calls fn for the snapshot of each node as they were set at a single instant.
+// The cache is not locked, so fn may call the other methods of the cache.
+func (cache *snapshotCache) ForeachSnapshot(fn func(node string, snapshot Snapshot) bool) {
+	cache.snapshots.Load().forEach(fn)
+}
@@ -0,0 +1,2611 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/api"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+	sotw "github.com/wso2/apk/adapter/pkg/discovery/protocol/server/sotw/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const testNode = "test-node"
+
+func newTestAPI(basePath string) *api.Api {
+	return &api.Api{Vhost: "localhost", BasePath: basePath, Version: "v1"}
+}
+
+func newTestSnapshot(t *testing.T, version string, apis ...*api.Api) Snapshot {
+	resources := make([]types.Resource, 0, len(apis))
+	for _, a := range apis {
+		resources = append(resources, a)
+	}
+	snapshot, err := NewSnapshot(version, map[resource.Type][]types.Resource{
+		resource.APIType: resources,
+	})
+	assert.Nil(t, err)
+	return snapshot
+}
+
+// recordingMetrics counts the calls of the watch and event metrics.
+type recordingMetrics struct {
+	nopMetrics
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{counts: make(map[string]int)}
+}
+
+func (m *recordingMetrics) record(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name]++
+}
+
+func (m *recordingMetrics) count(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counts[name]
+}
+
+func (m *recordingMetrics) WatchOpened(string, string)    { m.record("WatchOpened") }
+func (m *recordingMetrics) WatchClosed(string, string)    { m.record("WatchClosed") }
+func (m *recordingMetrics) WatchCancelled(string, string) { m.record("WatchCancelled") }
+func (m *recordingMetrics) WatchResponded(string, string) { m.record("WatchResponded") }
+func (m *recordingMetrics) SnapshotEventDropped(string)   { m.record("SnapshotEventDropped") }
+func (m *recordingMetrics) NodeRemoved(string)            { m.record("NodeRemoved") }
+
+func TestMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithMetrics(metrics))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+
+	// a cancelled watch is opened, cancelled and closed
+	cancel := cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.Equal(t, 1, metrics.count("WatchOpened"))
+	cancel()
+	assert.Equal(t, 1, metrics.count("WatchCancelled"))
+	assert.Equal(t, 1, metrics.count("WatchClosed"))
+
+	// a watch responded by a new snapshot is opened, responded and closed
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	<-value
+	assert.Equal(t, 2, metrics.count("WatchOpened"))
+	assert.Equal(t, 1, metrics.count("WatchResponded"))
+	assert.Equal(t, 2, metrics.count("WatchClosed"))
+
+	// and a request for an older version is responded without a watch
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.Equal(t, 2, metrics.count("WatchOpened"))
+	assert.Equal(t, 2, metrics.count("WatchResponded"))
+	assert.Equal(t, 1, metrics.count("WatchCancelled"))
+
+	// the metrics of the cleared and garbage collected nodes are removed
+	cache.ClearSnapshot(testNode)
+	assert.Equal(t, 1, metrics.count("NodeRemoved"))
+	cancel = cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: "other"}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	cancel()
+	assert.Equal(t, 1, cache.GarbageCollectStatus())
+	assert.Equal(t, 2, metrics.count("NodeRemoved"))
+}
+
+func TestPrometheusMetricsNodeRemoved(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics("test", registry)
+	assert.Nil(t, err)
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithMetrics(metrics))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	for _, node := range []string{testNode, "other"} {
+		request := &envoy_cache.Request{Node: &core.Node{Id: node}, TypeUrl: resource.APIType, VersionInfo: "1"}
+		cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	}
+
+	// only the series of the cleared node are deleted
+	series := func(node string) int {
+		families, err := registry.Gather()
+		assert.Nil(t, err)
+		count := 0
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "node" && label.GetValue() == node {
+						count++
+					}
+				}
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 2, series(testNode))
+	cache.ClearSnapshot(testNode)
+	assert.Equal(t, 0, series(testNode))
+	assert.Equal(t, 2, series("other"))
+}
+
+func TestCreateDeltaWatch(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	request := &envoy_cache.DeltaRequest{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	state := stream.NewStreamState(true, nil)
+	value := make(chan envoy_cache.DeltaResponse, 1)
+
+	// the first wildcard request is responded immediately with all resources
+	cancel := cache.CreateDeltaWatch(request, state, value)
+	assert.Nil(t, cancel)
+	response := (<-value).(*envoy_cache.RawDeltaResponse)
+	assert.Equal(t, []string{"localhost/foov1"}, GetResourceNames(response.Resources))
+	assert.Equal(t, "1", response.SystemVersionInfo)
+
+	// a stream that already knows the resources is left with an open watch
+	state.SetResourceVersions(response.NextVersionMap)
+	cancel = cache.CreateDeltaWatch(request, state, value)
+	assert.NotNil(t, cancel)
+	_, delta := cache.WatchCount(testNode)
+	assert.Equal(t, 1, delta)
+
+	// the open watch is responded with the added and removed resources only
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/bar"))))
+	response = (<-value).(*envoy_cache.RawDeltaResponse)
+	assert.Equal(t, []string{"localhost/barv1"}, GetResourceNames(response.Resources))
+	assert.Equal(t, []string{"localhost/foov1"}, response.RemovedResources)
+	_, delta = cache.WatchCount(testNode)
+	assert.Equal(t, 0, delta)
+}
+
+func TestNewSnapshotCacheWithFixtures(t *testing.T) {
+	cache, err := NewSnapshotCacheWithFixtures(false, IDHash{}, nil, map[string]Snapshot{
+		testNode: newTestSnapshot(t, "1", newTestAPI("/foo")),
+	})
+	assert.Nil(t, err)
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	invalid := newTestSnapshot(t, "1")
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	_, err = NewSnapshotCacheWithFixtures(false, IDHash{}, nil, map[string]Snapshot{testNode: invalid})
+	assert.NotNil(t, err)
+}
+
+func TestGetSnapshotHistory(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithSnapshotHistory(2))
+	_, err := cache.GetSnapshotHistory(testNode)
+	assert.NotNil(t, err)
+
+	for _, version := range []string{"1", "2", "3", "4"} {
+		assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, version, newTestAPI("/foo"))))
+	}
+	history, err := cache.GetSnapshotHistory(testNode)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "2", history[0].Snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, "3", history[1].Snapshot.GetVersion(resource.APIType))
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	events map[string][]Field
+}
+
+func (l *recordingLogger) record(msg string, fields []Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[msg] = fields
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...Field) { l.record(msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...Field)  { l.record(msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...Field)  { l.record(msg, fields) }
+func (l *recordingLogger) Error(msg string, fields ...Field) { l.record(msg, fields) }
+
+func TestStructuredLogger(t *testing.T) {
+	logger := &recordingLogger{events: make(map[string][]Field)}
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithStructuredLogger(logger))
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	assert.Contains(t, logger.events["open watch"], Field{Key: FieldNodeID, Value: testNode})
+	assert.Contains(t, logger.events["open watch"], Field{Key: FieldTypeURL, Value: resource.APIType})
+	assert.Equal(t, `open watch node_id="test-node" watch_id=1`,
+		formatFields("open watch", []Field{nodeField(testNode), watchField(1)}))
+}
+
+func TestFederation(t *testing.T) {
+	assert.Equal(t, "localhost/foov1", stripAuthority("xdstp://authority/wso2.discovery.api.Api/localhost/foov1?ctx=1"))
+	assert.Equal(t, "localhost/foov1", stripAuthority("localhost/foov1"))
+
+	cache := NewSnapshotCache(true, IDHash{}, nil, WithFederation(true))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{
+		Node:          &core.Node{Id: testNode},
+		TypeUrl:       resource.APIType,
+		ResourceNames: []string{"xdstp://authority/wso2.discovery.api.Api/localhost/foov1"},
+	}
+	value := make(chan envoy_cache.Response, 1)
+	assert.Nil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	assert.Len(t, (<-value).(*envoy_cache.RawResponse).Resources, 1)
+}
+
+func TestWatchCount(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	sotw, delta := cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+	assert.Equal(t, 0, delta)
+
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	value := make(chan envoy_cache.Response, 1)
+	cancel := cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+	sotw, _ = cache.WatchCount(testNode)
+	assert.Equal(t, 1, sotw)
+
+	cancel()
+	sotw, _ = cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+}
+
+func TestCancelWatchConcurrently(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cancel := cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+				if cancel != nil {
+					cancel()
+				}
+			}
+		}()
+		go func(version int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				snapshot := newTestSnapshot(t, fmt.Sprintf("%d-%d", version, j), newTestAPI("/foo"))
+				assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, snapshot))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWatchDuration(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	cancel := cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	time.Sleep(10 * time.Millisecond)
+	assert.GreaterOrEqual(t, cache.WatchDuration(testNode, 1), 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, time.Duration(0), cache.WatchDuration(testNode, 1))
+}
+
+func TestSetSnapshotSkipsEqualSnapshot(t *testing.T) {
+	assert.True(t, EqualSnapshot(newTestSnapshot(t, "1", newTestAPI("/foo")), newTestSnapshot(t, "1", newTestAPI("/bar"))))
+	assert.False(t, EqualSnapshot(newTestSnapshot(t, "1"), newTestSnapshot(t, "2")))
+
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}, stream.NewStreamState(false, nil), value)
+
+	// an unchanged snapshot does not respond the watch, but refreshes the age of the snapshot
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	age, err := cache.SnapshotAge(testNode)
+	assert.Nil(t, err)
+	assert.Less(t, age, 50*time.Millisecond)
+	assert.Empty(t, value)
+
+	// and is skipped under the read lock, without blocking the readers
+	inner := cache.(*snapshotCache)
+	inner.mu.RLock()
+	skipped := make(chan error)
+	go func() {
+		skipped <- cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo")))
+	}()
+	select {
+	case err := <-skipped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("unchanged snapshot waited for the write lock")
+	}
+	inner.mu.RUnlock()
+
+	// a snapshot with the same version but other resources is set
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/bar"))))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/barv1")
+}
+
+func TestAlphabeticResourceOrdering(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithResourceOrdering(AlphabeticResourceOrdering{}))
+	snapshot := newTestSnapshot(t, "1", newTestAPI("/c"), newTestAPI("/a"), newTestAPI("/b"))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, snapshot))
+
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	response, err := cache.Fetch(context.Background(), request)
+	assert.Nil(t, err)
+	var names []string
+	for _, r := range response.(*envoy_cache.RawResponse).Resources {
+		names = append(names, GetResourceName(r.Resource))
+	}
+	assert.Equal(t, []string{"localhost/av1", "localhost/bv1", "localhost/cv1"}, names)
+}
+
+func TestRequestLog(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithRequestLogSize(1))
+	_, err := cache.RequestLog(testNode)
+	assert.NotNil(t, err)
+
+	for _, nonce := range []string{"1", "2"} {
+		request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, ResponseNonce: nonce}
+		cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	}
+	requests, err := cache.RequestLog(testNode)
+	assert.Nil(t, err)
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "2", requests[0].Nonce)
+	assert.Equal(t, resource.APIType, requests[0].TypeURL)
+}
+
+func TestGarbageCollectStatus(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	cancel := cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.Nil(t, cache.WarmupSnapshot("warm-node", newTestSnapshot(t, "1")))
+
+	// the node with an open watch and the node with a snapshot are kept
+	assert.Equal(t, 0, cache.GarbageCollectStatus())
+	cancel()
+	assert.Equal(t, 1, cache.GarbageCollectStatus())
+	assert.Equal(t, []string{"warm-node"}, cache.GetStatusKeys())
+}
+
+func TestFetchUnknownNode(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	_, err := cache.Fetch(context.Background(), request)
+	assert.ErrorIs(t, err, ErrNodeNotFound)
+	var notFound *NodeNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	assert.Equal(t, testNode, notFound.Node)
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	registry := NewSchemaRegistry()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithSchemaRegistry(registry))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	registry.Register(resource.APIType, func() proto.Message { return &core.Node{} })
+	err := cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"), newTestAPI("/bar")))
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Len(t, validationErr.Resources, 2)
+	assert.Equal(t, "localhost/barv1", validationErr.Resources[0].Name)
+}
+
+func TestResourceDeduplication(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithResourceDeduplication())
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-1", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-2", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Equal(t, int64(proto.Size(newTestAPI("/foo"))), cache.SharedBytes())
+
+	first, _ := cache.GetSnapshot("node-1")
+	second, _ := cache.GetSnapshot("node-2")
+	assert.Same(t, first.GetResources(resource.APIType)["localhost/foov1"], second.GetResources(resource.APIType)["localhost/foov1"])
+
+	cache.ClearSnapshot("node-2")
+	assert.Equal(t, int64(0), cache.SharedBytes())
+}
+
+func TestSetSnapshotForSelector(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	metadata, err := structpb.NewStruct(map[string]interface{}{"namespace": "production"})
+	assert.Nil(t, err)
+	node := &core.Node{Id: testNode, Metadata: metadata}
+	request := &envoy_cache.Request{Node: node, TypeUrl: resource.APIType}
+
+	// the open watch of a matching node is responded
+	value := make(chan envoy_cache.Response, 1)
+	assert.NotNil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	assert.Nil(t, cache.SetSnapshotForSelector(context.Background(), map[string]string{"namespace": "staging"}, newTestSnapshot(t, "1")))
+	assert.Len(t, value, 0)
+	assert.Nil(t, cache.SetSnapshotForSelector(context.Background(), map[string]string{"namespace": "production"}, newTestSnapshot(t, "2")))
+	assert.Equal(t, "2", (<-value).(*envoy_cache.RawResponse).Version)
+
+	// the snapshot of the node overrides the snapshot of the selector
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "3")))
+	response, err := cache.Fetch(context.Background(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", response.(*envoy_cache.RawResponse).Version)
+}
+
+func TestStats(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.WarmupSnapshot("other-node", newTestSnapshot(t, "1")))
+	request.VersionInfo = "1"
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	stats := cache.Stats()
+	assert.Equal(t, 2, stats.Nodes)
+	assert.Equal(t, 2, stats.Snapshots)
+	assert.Equal(t, 1, stats.OpenWatches)
+	assert.Equal(t, int64(2), stats.WatchesCreated)
+	assert.Equal(t, int64(1), stats.WatchesResponded)
+	assert.Equal(t, cache.MemoryUsageBytes(), stats.MemoryUsageBytes)
+}
+
+type spanKey struct{}
+
+func TestResponseContext(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	// the response continues the span of the caller, after the call returns
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), spanKey{}, "parent-span"))
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1")))
+	cancel()
+	responseCtx := (<-value).GetContext()
+	assert.Equal(t, "parent-span", responseCtx.Value(spanKey{}))
+	assert.Nil(t, responseCtx.Err())
+
+	assert.Equal(t, context.Background(), responseContext(context.TODO()))
+}
+
+func TestReadView(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	view := cache.ReadView()
+	cache.ClearSnapshot(testNode)
+	assert.Equal(t, 1, view.Len())
+	snapshot, ok := view.Get(testNode)
+	assert.True(t, ok)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	_, ok = cache.ReadView().Get(testNode)
+	assert.False(t, ok)
+}
+
+func TestCreateClearWatch(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+
+	// a node without a snapshot is responded immediately
+	value := make(chan struct{}, 1)
+	cache.CreateClearWatch(testNode, value)
+	assert.Len(t, value, 1)
+	<-value
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	cache.CreateClearWatch(testNode, value)
+	cancelled := make(chan struct{}, 1)
+	cache.CreateClearWatch(testNode, cancelled)()
+	assert.Len(t, value, 0)
+
+	cache.ClearSnapshot(testNode)
+	assert.Len(t, value, 1)
+	assert.Len(t, cancelled, 0)
+}
+
+func TestCreateResourceWatch(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateResourceWatch(resource.APIType, "localhost/foov1", testNode, value)
+
+	// changes to other resources do not respond to the watch
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/bar"))))
+	assert.Len(t, value, 0)
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/bar"), newTestAPI("/foo"))))
+	response := (<-value).(*envoy_cache.RawResponse)
+	assert.Len(t, response.Resources, 1)
+	assert.Equal(t, []string{"localhost/foov1"}, GetResourceNames([]types.Resource{response.Resources[0].Resource}))
+	assert.Equal(t, "2", response.Version)
+}
+
+func TestForceRespondAll(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the watch is up to date, so it is only responded when forced
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	assert.NotNil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+
+	assert.Nil(t, cache.ForceRespondAll(context.Background(), testNode))
+	response := (<-value).(*envoy_cache.RawResponse)
+	assert.Equal(t, "1-forced-1", response.Version)
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+
+	// the suffix is replaced when forced again
+	assert.Nil(t, cache.ForceRespondAll(context.Background(), testNode))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1-forced-2", snapshot.GetVersion(resource.APIType))
+}
+
+func TestCreateGlobalWatch(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	value := make(chan GlobalWatchEvent, 2)
+	cancel := cache.CreateGlobalWatch(resource.APIType, value)
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	// the same version is not sent again
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Equal(t, "node-a", (<-value).Node)
+	assert.Equal(t, "node-b", (<-value).Node)
+	assert.Len(t, value, 0)
+
+	cancel()
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-a", newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.Len(t, value, 0)
+}
+
+func TestHealthChecker(t *testing.T) {
+	checker := NewHealthChecker(NewSnapshotCache(false, IDHash{}, nil))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, checker.Status())
+
+	assert.Nil(t, checker.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, checker.Status())
+
+	invalid := newTestSnapshot(t, "2")
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	assert.NotNil(t, checker.SetSnapshot(context.Background(), testNode, invalid))
+	response, err := checker.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_UNKNOWN, response.Status)
+
+	// the errors of the other mutators are tracked too
+	api := newTestAPI("/bar")
+	assert.Nil(t, checker.PatchSnapshot(context.Background(), testNode, resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "2"))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, checker.Status())
+	_, err = checker.CompareAndSwapSnapshot(context.Background(), testNode, newTestSnapshot(t, "2"), invalid)
+	assert.NotNil(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_UNKNOWN, checker.Status())
+}
+
+func TestCircuitBreakerSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	cache := CircuitBreakerSnapshotCache(NewSnapshotCache(false, IDHash{}, nil), 2, 50*time.Millisecond)
+	checker := NewHealthChecker(cache)
+	invalid := newTestSnapshot(t, "2")
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+
+	// the circuit opens after two consecutive failures
+	assert.NotNil(t, checker.SetSnapshot(ctx, testNode, invalid))
+	assert.NotErrorIs(t, checker.SetSnapshot(ctx, testNode, invalid), ErrCircuitOpen)
+	assert.ErrorIs(t, checker.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))), ErrCircuitOpen)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, checker.Status())
+
+	// a failure while half-open opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, cache.(circuitBreaker).CircuitState())
+	assert.NotErrorIs(t, checker.SetSnapshot(ctx, testNode, invalid), ErrCircuitOpen)
+	assert.Equal(t, CircuitOpen, cache.(circuitBreaker).CircuitState())
+
+	// and a success closes it
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, checker.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Equal(t, CircuitClosed, cache.(circuitBreaker).CircuitState())
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, checker.Status())
+}
+
+// blockingCache blocks the snapshots set through it until release is closed.
+type blockingCache struct {
+	SnapshotCache
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCache) PatchSnapshot(ctx context.Context, node string, typeURL string, resources map[string]types.ResourceWithTTL, version string) error {
+	c.started <- struct{}{}
+	<-c.release
+	return c.SnapshotCache.PatchSnapshot(ctx, node, typeURL, resources, version)
+}
+
+func TestCircuitBreakerSnapshotCacheHalfOpen(t *testing.T) {
+	ctx := context.Background()
+	inner := &blockingCache{SnapshotCache: NewSnapshotCache(false, IDHash{}, nil), started: make(chan struct{}, 1), release: make(chan struct{})}
+	cache := CircuitBreakerSnapshotCache(inner, 1, 10*time.Millisecond)
+	invalid := newTestSnapshot(t, "1")
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	assert.NotNil(t, cache.SetSnapshot(ctx, testNode, invalid))
+	assert.ErrorIs(t, cache.SetSnapshotVariant(ctx, testNode, "canary", newTestSnapshot(t, "1")), ErrCircuitOpen)
+	time.Sleep(20 * time.Millisecond)
+
+	// a single probe is let through while the circuit is half-open
+	api := newTestAPI("/foo")
+	probe := make(chan error)
+	go func() {
+		probe <- cache.PatchSnapshot(ctx, testNode, resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "1")
+	}()
+	<-inner.started
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.ErrorIs(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))), ErrCircuitOpen)
+			_, err := cache.GetOrCreateSnapshot(ctx, "other", func() Snapshot { return newTestSnapshot(t, "1") })
+			assert.ErrorIs(t, err, ErrCircuitOpen)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, CircuitHalfOpen, cache.(circuitBreaker).CircuitState())
+
+	// and its success closes the circuit
+	close(inner.release)
+	assert.Nil(t, <-probe)
+	assert.Equal(t, CircuitClosed, cache.(circuitBreaker).CircuitState())
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+}
+
+func TestCoalescingSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	cache := CoalescingSnapshotCache(NewSnapshotCache(false, IDHash{}, nil), 50*time.Millisecond, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "other", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the writes after the buffered snapshots within the window win
+	api := newTestAPI("/bar")
+	assert.Nil(t, cache.PatchSnapshot(ctx, testNode, resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "2"))
+	swapped, err := cache.CompareAndSwapSnapshot(ctx, "other", newTestSnapshot(t, "1"), newTestSnapshot(t, "2", newTestAPI("/bar")))
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, node := range []string{testNode, "other"} {
+		snapshot, err := cache.GetSnapshot(node)
+		assert.Nil(t, err)
+		assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+		assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/barv1")
+	}
+
+	// an invalid snapshot is rejected before it is buffered
+	invalid := newTestSnapshot(t, "3", newTestAPI("/foo"))
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	assert.NotNil(t, cache.SetSnapshot(ctx, testNode, invalid))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+
+	// the windows of the cleared nodes are stopped
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "3", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "other", newTestSnapshot(t, "3", newTestAPI("/foo"))))
+	cache.ClearSnapshot(testNode)
+	assert.Len(t, cache.(*coalescingSnapshotCache).timers, 1)
+	assert.Nil(t, cache.Reset(ctx))
+	assert.Empty(t, cache.(*coalescingSnapshotCache).timers)
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, cache.ListNodes())
+}
+
+func TestLimitedSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	cache := LimitedSnapshotCache(2, LRUEviction, NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	_, err := cache.GetSnapshot("a")
+	assert.Nil(t, err)
+
+	// a patch of a new node evicts the least recently used node
+	api := newTestAPI("/bar")
+	assert.Nil(t, cache.PatchSnapshot(ctx, "c", resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "1"))
+	_, err = cache.GetSnapshot("b")
+	assert.NotNil(t, err)
+
+	// a swap and a variant count as uses of the nodes
+	swapped, err := cache.CompareAndSwapSnapshot(ctx, "a", newTestSnapshot(t, "1"), newTestSnapshot(t, "2", newTestAPI("/foo")))
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	assert.Nil(t, cache.SetSnapshotVariant(ctx, "c", "canary", newTestSnapshot(t, "2", newTestAPI("/bar"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "d", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.ElementsMatch(t, []string{"c", "d"}, cache.ListNodes())
+}
+
+func TestLimitedSnapshotCacheLFU(t *testing.T) {
+	ctx := context.Background()
+	cache := LimitedSnapshotCache(2, LFUEviction, NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// concurrent reads are tracked under the read lock
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.GetSnapshot("a")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Nil(t, cache.SetSnapshot(ctx, "c", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.ElementsMatch(t, []string{"a", "c"}, cache.ListNodes())
+}
+
+func TestRateLimitedSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	cache := RateLimitedSnapshotCache(NewSnapshotCache(false, IDHash{}, nil), 10, 1)
+	start := time.Now()
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the next update waits for its turn
+	next := newTestSnapshot(t, "2", newTestAPI("/foo"))
+	done := make(chan error)
+	go func() {
+		done <- cache.SetSnapshot(ctx, testNode, next)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// and the updates beyond the queue depth are rejected
+	assert.ErrorIs(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "3", newTestAPI("/foo"))), ErrRateLimited)
+	// while the other nodes have their own rate
+	assert.Nil(t, cache.SetSnapshot(ctx, "other", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	assert.Nil(t, <-done)
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+}
+
+func TestRateLimitedSnapshotCacheTokens(t *testing.T) {
+	ctx := context.Background()
+	cache := RateLimitedSnapshotCache(NewSnapshotCache(false, IDHash{}, nil), 10, 0)
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// a call rejected for one node takes no token of the others
+	err := cache.SetSnapshots(ctx, map[string]Snapshot{
+		"a": newTestSnapshot(t, "2", newTestAPI("/foo")),
+		"b": newTestSnapshot(t, "2", newTestAPI("/foo")),
+	})
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the other mutators are limited as well
+	api := newTestAPI("/bar")
+	assert.ErrorIs(t, cache.PatchSnapshot(ctx, "b", resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "2"), ErrRateLimited)
+	_, err = cache.CompareAndSwapSnapshot(ctx, "b", newTestSnapshot(t, "1"), newTestSnapshot(t, "2", newTestAPI("/foo")))
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// and a rate of zero does not limit the updates
+	unlimited := RateLimitedSnapshotCache(NewSnapshotCache(false, IDHash{}, nil), 0, 0)
+	for _, version := range []string{"1", "2", "3"} {
+		assert.Nil(t, unlimited.SetSnapshot(ctx, "a", newTestSnapshot(t, version, newTestAPI("/foo"))))
+	}
+}
+
+func TestBulkClearSnapshot(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "c", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the watch of a node without a snapshot is left open
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: "d"}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), value)
+
+	assert.Equal(t, 2, cache.BulkClearSnapshot([]string{"a", "c", "d", "missing"}))
+	assert.ElementsMatch(t, []string{"b"}, cache.ListNodes())
+	assert.Equal(t, 1, cache.GetStatusInfo("d").GetNumWatches())
+}
+
+func TestShardedSnapshotCache(t *testing.T) {
+	cache := ShardedSnapshotCache(4, IDHash{}, func() SnapshotCache {
+		return NewSnapshotCache(false, IDHash{}, nil)
+	})
+	snapshots := make(map[string]Snapshot)
+	for i := 0; i < 10; i++ {
+		snapshots[fmt.Sprintf("node-%d", i)] = newTestSnapshot(t, "1", newTestAPI("/foo"))
+	}
+	assert.Nil(t, cache.SetSnapshots(context.Background(), snapshots))
+	assert.Len(t, cache.ListNodes(), 10)
+
+	// the watch of a node is responded by the inner cache holding its snapshot
+	request := &envoy_cache.Request{Node: &core.Node{Id: "node-3"}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	assert.NotNil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-3", newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.Equal(t, "2", (<-value).(*envoy_cache.RawResponse).Version)
+}
+
+func TestWarmupSnapshot(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.WarmupSnapshot(testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.True(t, cache.GetStatusInfo(testNode).IsWarmedUp())
+
+	// the first watch of the node is responded immediately
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	value := make(chan envoy_cache.Response, 1)
+	assert.Nil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	assert.Equal(t, "1", (<-value).(*envoy_cache.RawResponse).Version)
+	assert.Equal(t, testNode, cache.GetStatusInfo(testNode).GetNode().Id)
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.False(t, cache.GetStatusInfo(testNode).IsWarmedUp())
+}
+
+func TestMaxSnapshotSizeBytes(t *testing.T) {
+	small := newTestSnapshot(t, "1", newTestAPI("/foo"))
+	size := snapshotSize(small)
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithMaxSnapshotSizeBytes(size))
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, small))
+	assert.Equal(t, size, cache.MemoryUsageBytes())
+
+	large := newTestSnapshot(t, "2", newTestAPI("/foo"), newTestAPI("/bar"))
+	assert.Error(t, cache.SetSnapshot(context.Background(), testNode, large))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	cache.ClearSnapshot(testNode)
+	assert.Equal(t, int64(0), cache.MemoryUsageBytes())
+}
+
+func TestAdminServer(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	handler := AdminServer("", cache, WithAdminBearerToken("secret")).Handler
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshots", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	data, err := MarshalSnapshotJSON(newTestSnapshot(t, "1", newTestAPI("/foo")))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPost, "/snapshots/"+testNode, data).Code)
+	assert.JSONEq(t, `["`+testNode+`"]`, do(http.MethodGet, "/snapshots", nil).Body.String())
+
+	w = do(http.MethodGet, "/snapshots/"+testNode, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	snapshot, err := UnmarshalSnapshotJSON(w.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/foov1")
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/snapshots/"+testNode, nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/snapshots/"+testNode, nil).Code)
+	assert.JSONEq(t, `[]`, do(http.MethodGet, "/status", nil).Body.String())
+}
+
+func TestAdminServerLimits(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	handler := AdminServer("", cache, WithAdminMaxBodyBytes(16)).Handler
+	data, err := MarshalSnapshotJSON(newTestSnapshot(t, "1", newTestAPI("/foo")))
+	assert.Nil(t, err)
+
+	// without a token, only the requests from a loopback address are served
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshots", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	r := httptest.NewRequest(http.MethodPost, "/snapshots/"+testNode, bytes.NewReader(data))
+	r.RemoteAddr = "127.0.0.1:1234"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, cache.ListNodes())
+}
+
+func TestEventBus(t *testing.T) {
+	metrics := newRecordingMetrics()
+	bus := NewEventBus(1, nil, metrics)
+	received := make(chan string, 3)
+	release := make(chan struct{})
+	handler := func(event SnapshotEvent) {
+		received <- event.Node
+		<-release
+	}
+	unsubscribe := bus.Subscribe(handler)
+
+	// the events beyond the buffer of a slow subscriber are dropped and counted
+	bus.Publish(SnapshotEvent{Node: "a"})
+	assert.Equal(t, "a", <-received)
+	bus.Publish(SnapshotEvent{Node: "b"})
+	bus.Publish(SnapshotEvent{Node: "c"})
+	assert.Equal(t, 1, metrics.count("SnapshotEventDropped"))
+	close(release)
+	assert.Equal(t, "b", <-received)
+
+	// no event is delivered after the handler is unsubscribed
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(SnapshotEvent{Node: "d"})
+
+	// nor after the bus is closed
+	bus.Subscribe(handler)
+	bus.Close()
+	bus.Subscribe(handler)
+	bus.Publish(SnapshotEvent{Node: "e"})
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, received)
+}
+
+func TestDumpState(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))))
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "other", newTestSnapshot(t, "2", newTestAPI("/foo"))))
+
+	var buf bytes.Buffer
+	assert.Nil(t, cache.DumpState(&buf))
+	var dump CacheDump
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &dump))
+
+	assert.Len(t, dump.Nodes, 2)
+	node := dump.Nodes[testNode]
+	assert.Equal(t, ResourceTypeDump{Version: "1", ResourceCount: 2}, node.Resources[resource.APIType])
+	assert.Equal(t, 1, node.NumWatches)
+	assert.NotNil(t, node.LastWatchRequestTime)
+	assert.NotNil(t, node.LastSnapshotSetTime)
+
+	other := dump.Nodes["other"]
+	assert.Equal(t, ResourceTypeDump{Version: "2", ResourceCount: 1}, other.Resources[resource.APIType])
+	assert.Equal(t, 0, other.NumWatches)
+	assert.Nil(t, other.LastWatchRequestTime)
+}
+
+func TestSnapshotTransactionRollback(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	value := make(chan envoy_cache.Response, 1)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	// a rollback before the commit leaves the snapshot and the open watches untouched
+	tx := BeginTransaction(cache, testNode, "2")
+	assert.Nil(t, tx.Set(resource.APIType, []types.Resource{newTestAPI("/bar")}))
+	assert.Nil(t, tx.Rollback(context.Background()))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/foov1")
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 1, sotw)
+	assert.Empty(t, value)
+}
+
+func TestSnapshotTransactionCommit(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	value := make(chan envoy_cache.Response, 1)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	tx := BeginTransaction(cache, testNode, "2")
+	assert.NotNil(t, tx.Set("unknown", nil))
+	assert.Nil(t, tx.Set(resource.APIType, []types.Resource{newTestAPI("/bar")}))
+	assert.Nil(t, tx.Commit(context.Background()))
+	assert.NotNil(t, tx.Commit(context.Background()))
+
+	// the commit sets the changes in a single snapshot and responds to the open watches
+	response := <-value
+	version, err := response.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "2", version)
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.GetResources(resource.APIType), 1)
+	assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/barv1")
+
+	// and a rollback after the commit restores the snapshot the transaction began with
+	assert.Nil(t, tx.Rollback(context.Background()))
+	snapshot, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Len(t, snapshot.GetResources(resource.APIType), 1)
+	assert.Contains(t, snapshot.GetResources(resource.APIType), "localhost/foov1")
+
+	// or clears the node which had no snapshot
+	tx = BeginTransaction(cache, "other", "1")
+	assert.Nil(t, tx.Set(resource.APIType, []types.Resource{newTestAPI("/foo")}))
+	assert.Nil(t, tx.Commit(context.Background()))
+	assert.Nil(t, tx.Rollback(context.Background()))
+	_, err = cache.GetSnapshot("other")
+	assert.NotNil(t, err)
+}
+
+func TestDrain(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	assert.Nil(t, cache.Drain(context.Background()))
+	_, more := <-value
+	assert.False(t, more)
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+
+	// watches created after the drain are closed immediately
+	value = make(chan envoy_cache.Response, 1)
+	assert.Nil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	_, more = <-value
+	assert.False(t, more)
+}
+
+func TestDrainSharedChannel(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.ApplicationType}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	// the muxed channel of the stream is not closed, and the watch is only removed
+	assert.Nil(t, cache.Drain(context.Background()))
+	select {
+	case <-value:
+		t.Fatal("shared channel was closed or written")
+	default:
+	}
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+
+	assert.Nil(t, cache.CreateWatch(request, stream.NewStreamState(false, nil), value))
+	select {
+	case <-value:
+		t.Fatal("shared channel was closed or written")
+	default:
+	}
+}
+
+// sotwStream is an in-process sotw stream, which receives the requests sent on a channel.
+type sotwStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests chan *discovery.DiscoveryRequest
+}
+
+func (s *sotwStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sotwStream) Send(*discovery.DiscoveryResponse) error {
+	return nil
+}
+
+func (s *sotwStream) Recv() (*discovery.DiscoveryRequest, error) {
+	select {
+	case request := <-s.requests:
+		return request, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func TestReapWatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithContext(ctx), WithWatchResponseTimeout(50*time.Millisecond, 10*time.Millisecond))
+	assert.Nil(t, cache.SetSnapshot(ctx, "idle", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	server := sotw.NewServer(ctx, cache, nil)
+	open := func(node string, version string) chan error {
+		s := &sotwStream{ctx: ctx, requests: make(chan *discovery.DiscoveryRequest, 1)}
+		s.requests <- &discovery.DiscoveryRequest{Node: &core.Node{Id: node}, TypeUrl: resource.APIType, VersionInfo: version}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.StreamHandler(s, resource.APIType)
+		}()
+		return done
+	}
+
+	// the stream of a node waiting for a snapshot is ended
+	stale := open(testNode, "")
+	select {
+	case err := <-stale:
+		assert.Equal(t, codes.Unavailable, grpcstatus.Code(err))
+	case <-time.After(time.Second):
+		t.Fatal("stream of the stale watch not ended")
+	}
+
+	// while the stream of an up to date node is kept
+	idle := open("idle", "1")
+	select {
+	case err := <-idle:
+		t.Fatalf("stream of the up to date watch ended: %v", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+	watches, _ := cache.WatchCount("idle")
+	assert.Equal(t, 1, watches)
+}
+
+func TestEvictLRU(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithMaxNodes(2))
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{Node: &core.Node{Id: "a"}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	// b was set after a, but a was accessed since
+	assert.Nil(t, cache.SetSnapshot(ctx, "c", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.ElementsMatch(t, []string{"a", "c"}, cache.ListNodes())
+
+	assert.Equal(t, 1, cache.EvictLRU(1))
+	assert.Equal(t, []string{"c"}, cache.ListNodes())
+}
+
+func TestSnapshotMap(t *testing.T) {
+	var m *snapshotMap
+	want := make(map[string]Snapshot)
+	versions := make([]*snapshotMap, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		node := fmt.Sprintf("node-%d", i%700)
+		snapshot := Snapshot{}
+		snapshot.Resources[0].Version = strconv.Itoa(i)
+		m = m.set(node, snapshot)
+		want[node] = snapshot
+		if i%3 == 0 {
+			m = m.delete(fmt.Sprintf("node-%d", i/2))
+			delete(want, fmt.Sprintf("node-%d", i/2))
+		}
+		versions = append(versions, m)
+	}
+
+	assert.Equal(t, len(want), m.len())
+	got := make(map[string]Snapshot)
+	m.forEach(func(node string, snapshot Snapshot) bool {
+		got[node] = snapshot
+		return true
+	})
+	assert.Equal(t, want, got)
+	for node, snapshot := range want {
+		value, ok := m.get(node)
+		assert.True(t, ok)
+		assert.Equal(t, snapshot, value)
+	}
+
+	// the previous versions are not changed by the later updates
+	assert.Equal(t, 0, versions[0].len())
+	first, ok := versions[1].get("node-1")
+	assert.True(t, ok)
+	assert.Equal(t, "1", first.Resources[0].Version)
+	assert.Equal(t, 1, versions[1].len())
+}
+
+func TestWatchGroup(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	group := cache.WatchGroup(context.Background(), testNode)
+	group.Add(resource.APIType, make(chan envoy_cache.Response, 1))
+	group.Add(resource.APIListType, make(chan envoy_cache.Response, 1))
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 2, sotw)
+
+	group.Cancel()
+	sotw, _ = cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+	group.Add(resource.APIType, make(chan envoy_cache.Response, 1))
+	sotw, _ = cache.WatchCount(testNode)
+	assert.Equal(t, 0, sotw)
+
+	// the group is cancelled with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	group = cache.WatchGroup(ctx, testNode)
+	group.Add(resource.APIType, make(chan envoy_cache.Response, 1))
+	cancel()
+	assert.Eventually(t, func() bool {
+		sotw, _ := cache.WatchCount(testNode)
+		return sotw == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReplicatedSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	primary := NewSnapshotCache(false, IDHash{}, nil)
+	secondary := NewSnapshotCache(false, IDHash{}, nil)
+	cache := ReplicatedSnapshotCache(primary, secondary, nil)
+
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	replica, err := secondary.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", replica.GetVersion(resource.APIType))
+
+	// the secondary serves the nodes missing in the primary
+	primary.ClearSnapshot(testNode)
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}
+	_, err = cache.Fetch(ctx, request)
+	assert.Nil(t, err)
+
+	assert.Nil(t, primary.SetSnapshot(ctx, "other", newTestSnapshot(t, "2", newTestAPI("/bar"))))
+	assert.Nil(t, cache.SyncFromPrimary(ctx))
+	assert.Equal(t, []string{"other"}, secondary.ListNodes())
+}
+
+func TestNACKTracking(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{
+		Node:        &core.Node{Id: testNode},
+		TypeUrl:     resource.APIType,
+		VersionInfo: "1",
+		ErrorDetail: &status.Status{Message: "invalid api"},
+	}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	info := cache.GetStatusInfo(testNode)
+	assert.Equal(t, "2", info.LastNACKedVersion(resource.APIType))
+	assert.Equal(t, "invalid api", info.LastNACKError(resource.APIType))
+	assert.Equal(t, 1, info.NACKCount(resource.APIType))
+	assert.Equal(t, "", info.LastNACKedVersion(resource.APIListType))
+}
+
+func TestNACKOfOlderResponse(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), value)
+	response := <-value
+	version, err := response.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", version)
+
+	// the snapshot changes before the node rejects the response with version 1
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/bar"))))
+	cache.CreateWatch(&envoy_cache.Request{
+		Node:        &core.Node{Id: testNode},
+		TypeUrl:     resource.APIType,
+		ErrorDetail: &status.Status{Message: "invalid api"},
+	}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+
+	assert.Equal(t, "1", cache.GetStatusInfo(testNode).LastNACKedVersion(resource.APIType))
+}
+
+func TestRespondPriority(t *testing.T) {
+	cache := newSnapshotCache(false, IDHash{}, nil, WithRespondPriority(map[string]int{
+		resource.APIListType: 0,
+		resource.APIType:     1,
+	}))
+	response := func(typeURL string, id int64) WatchResponse {
+		return WatchResponse{WatchID: id, Watch: envoy_cache.ResponseWatch{Request: &envoy_cache.Request{TypeUrl: typeURL}}}
+	}
+	responses := []WatchResponse{
+		response(resource.ConfigType, 1),
+		response(resource.APIType, 3),
+		response(resource.APIListType, 4),
+		response(resource.APIType, 2),
+	}
+	cache.sortResponses(responses)
+	assert.Equal(t, []WatchResponse{
+		response(resource.APIListType, 4),
+		response(resource.APIType, 2),
+		response(resource.APIType, 3),
+		response(resource.ConfigType, 1),
+	}, responses)
+
+	// by default the config and the subscription data are responded before the APIs
+	cache = newSnapshotCache(false, IDHash{}, nil)
+	responses = []WatchResponse{
+		response(resource.APIType, 1),
+		response(resource.ApplicationType, 2),
+		response(resource.SubscriptionListType, 3),
+		response(resource.ConfigType, 4),
+	}
+	cache.sortResponses(responses)
+	assert.Equal(t, []WatchResponse{
+		response(resource.ConfigType, 4),
+		response(resource.ApplicationType, 2),
+		response(resource.SubscriptionListType, 3),
+		response(resource.APIType, 1),
+	}, responses)
+}
+
+// testConfigMaps stores a single ConfigMap in memory.
+type testConfigMaps struct {
+	corev1client.ConfigMapInterface
+
+	configMap *corev1.ConfigMap
+	mu        sync.Mutex
+}
+
+func (c *testConfigMaps) Get(_ context.Context, name string, _ metav1.GetOptions) (*corev1.ConfigMap, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.configMap == nil {
+		return nil, k8serrors.NewNotFound(corev1.Resource("configmaps"), name)
+	}
+	return c.configMap.DeepCopy(), nil
+}
+
+func (c *testConfigMaps) Create(_ context.Context, configMap *corev1.ConfigMap, _ metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	return c.Update(context.Background(), configMap, metav1.UpdateOptions{})
+}
+
+func (c *testConfigMaps) Update(_ context.Context, configMap *corev1.ConfigMap, _ metav1.UpdateOptions) (*corev1.ConfigMap, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configMap = configMap.DeepCopy()
+	return configMap, nil
+}
+
+func TestConfigMapBackedSnapshotCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &testConfigMaps{}
+	cache, err := ConfigMapBackedSnapshotCache(ctx, client, "snapshots", NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the snapshots are saved in the background
+	assert.Eventually(t, func() bool {
+		configMap, err := client.Get(ctx, "snapshots", metav1.GetOptions{})
+		return err == nil && len(configMap.BinaryData) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	restored, err := ConfigMapBackedSnapshotCache(ctx, client, "snapshots", NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, err)
+	snapshot, err := restored.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1"+StaleVersionSuffix, snapshot.GetVersion(resource.APIType))
+}
+
+func TestAllResourceNames(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, "a", newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, "b", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	assert.Equal(t, map[string][]string{
+		"a": {"localhost/barv1", "localhost/foov1"},
+		"b": {"localhost/foov1"},
+	}, cache.AllResourceNames(resource.APIType))
+	assert.Equal(t, []string{"localhost/barv1", "localhost/foov1"}, cache.AllResourceNamesGlobal(resource.APIType))
+	assert.Empty(t, cache.AllResourceNames(resource.APIListType))
+}
+
+func TestCachingSnapshotCacheProxy(t *testing.T) {
+	ctx := context.Background()
+	inner := NewSnapshotCache(false, IDHash{}, nil)
+	cache := CachingSnapshotCacheProxy(inner, time.Hour)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	// the copy is served until the snapshot is set through the proxy
+	assert.Nil(t, inner.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	snapshot, _ = cache.GetSnapshot(testNode)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "3", newTestAPI("/foo"))))
+	snapshot, _ = cache.GetSnapshot(testNode)
+	assert.Equal(t, "3", snapshot.GetVersion(resource.APIType))
+
+	cache.ClearSnapshot(testNode)
+	_, err = cache.GetSnapshot(testNode)
+	assert.NotNil(t, err)
+}
+
+type testListener struct {
+	changes chan string
+}
+
+func (l *testListener) OnSet(ctx context.Context, node string, previous, current Snapshot) {
+	l.changes <- "set " + node + " " + previous.GetVersion(resource.APIType) + " " + current.GetVersion(resource.APIType)
+}
+
+func (l *testListener) OnClear(ctx context.Context, node string) {
+	l.changes <- "clear " + node
+}
+
+// tenantHash maps a node to an ID for each of the tenants listed in its metadata, followed by
+// the node ID.
+type tenantHash struct {
+	IDHash
+}
+
+func (h tenantHash) IDs(node *core.Node) []string {
+	var ids []string
+	for _, tenant := range node.GetMetadata().GetFields()["tenants"].GetListValue().GetValues() {
+		ids = append(ids, tenant.GetStringValue())
+	}
+	return append(ids, h.ID(node))
+}
+
+func TestNodeMultiHash(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, tenantHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, "tenant-b", newTestSnapshot(t, "b", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "node", newTestAPI("/foo"))))
+
+	tenants, _ := structpb.NewList([]interface{}{"tenant-a", "tenant-b"})
+	node := &core.Node{Id: testNode, Metadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"tenants": structpb.NewListValue(tenants),
+	}}}
+	// the first ID with a snapshot is served
+	response, err := cache.Fetch(ctx, &envoy_cache.Request{Node: node, TypeUrl: resource.APIType})
+	assert.Nil(t, err)
+	version, _ := response.GetVersion()
+	assert.Equal(t, "b", version)
+
+	// the watch is responded when the snapshot of the ID it was served is set
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: node, TypeUrl: resource.APIType, VersionInfo: "b"}, stream.NewStreamState(false, nil), value)
+	assert.Nil(t, cache.SetSnapshot(ctx, "tenant-b", newTestSnapshot(t, "b2", newTestAPI("/foo"))))
+	select {
+	case response := <-value:
+		version, _ := response.GetVersion()
+		assert.Equal(t, "b2", version)
+	case <-time.After(time.Second):
+		t.Fatal("watch not responded")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithContext(ctx), WithSnapshotTTL(20*time.Millisecond))
+	assert.Nil(t, cache.SetSnapshot(ctx, "expired", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Eventually(t, func() bool {
+		_, err := cache.GetSnapshot("expired")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the snapshots are no longer expired once the context is cancelled
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	time.Sleep(100 * time.Millisecond)
+	_, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+}
+
+func TestRespondToClosedChannel(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	value := make(chan envoy_cache.Response)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), value)
+	close(value)
+
+	// the panic of the send is returned as an error
+	assert.NotNil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// and the cache keeps serving the other nodes
+	other := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: "other"}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), other)
+	assert.Nil(t, cache.SetSnapshot(ctx, "other", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	select {
+	case response := <-other:
+		version, _ := response.GetVersion()
+		assert.Equal(t, "1", version)
+	case <-time.After(time.Second):
+		t.Fatal("watch not responded")
+	}
+}
+
+func TestSubscribeNodeState(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithListenerWorkers(1))
+	events := make(chan NodeStateEvent, 10)
+	cache.SubscribeNodeState(func(nodeID string, event NodeStateEvent) {
+		assert.Equal(t, testNode, nodeID)
+		events <- event
+	})
+	expect := func(state NodeState) {
+		select {
+		case event := <-events:
+			assert.Equal(t, state, event.State)
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", state)
+		}
+	}
+
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	node := &core.Node{Id: testNode}
+	cache.CreateWatch(&envoy_cache.Request{Node: node, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	expect(NodeConnected)
+
+	// the node is synced once it acknowledges the version of every type
+	cache.CreateWatch(&envoy_cache.Request{Node: node, TypeUrl: resource.APIType, VersionInfo: "1"}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	expect(NodeSynced)
+
+	// and stale until it acknowledges the new snapshot
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	expect(NodeStale)
+	cancel := cache.CreateWatch(&envoy_cache.Request{Node: node, TypeUrl: resource.APIType, VersionInfo: "2"}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	expect(NodeSynced)
+
+	// the node is disconnected when its last watch is cancelled
+	cancel()
+	expect(NodeDisconnected)
+}
+
+func TestWatchSummary(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	node := &core.Node{Id: testNode}
+	cache.CreateWatch(&envoy_cache.Request{Node: node, TypeUrl: resource.APIType, VersionInfo: "1"}, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	cache.CreateDeltaWatch(&envoy_cache.DeltaRequest{Node: node, TypeUrl: resource.ConfigType}, stream.NewStreamState(false, nil), make(chan envoy_cache.DeltaResponse, 1))
+
+	summary := cache.GetStatusInfo(testNode).WatchSummary()
+	assert.Equal(t, map[string]int{resource.APIType: 1}, summary.Watches)
+	assert.Equal(t, map[string]int{resource.ConfigType: 1}, summary.DeltaWatches)
+	assert.Equal(t, map[string]string{resource.APIType: "1"}, summary.ACKedVersions)
+	assert.Len(t, summary.LastRequestTimes, 2)
+
+	_, err := json.Marshal(summary)
+	assert.Nil(t, err)
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshotWithAudit(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo")), "alice"))
+	assert.Nil(t, cache.SetSnapshot(WithRequestedBy(ctx, "bob"), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	cache.ClearSnapshot(testNode)
+
+	// the entries are kept once the node is cleared
+	var log []AuditEntry
+	for _, entry := range cache.GetAuditLog(testNode) {
+		if entry.TypeURL == resource.APIType {
+			log = append(log, entry)
+		}
+	}
+	assert.Len(t, log, 2)
+	assert.Equal(t, "1", log[0].Version)
+	assert.Equal(t, "alice", log[0].RequestedBy)
+	assert.Equal(t, "2", log[1].Version)
+	assert.Equal(t, "bob", log[1].RequestedBy)
+}
+
+func TestSetSnapshotDryRun(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))))
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"},
+		stream.NewStreamState(false, nil), value)
+
+	result, err := cache.SetSnapshotDryRun(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"), newTestAPI("/baz")))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"localhost/bazv1"}, result.Diff[resource.APIType].Added)
+	assert.Equal(t, []string{"localhost/barv1"}, result.Diff[resource.APIType].Removed)
+	assert.Len(t, result.Watches, 1)
+	assert.Equal(t, "2", result.Watches[0].Version)
+	_, err = json.Marshal(result)
+	assert.Nil(t, err)
+
+	// the cache is left unchanged
+	assert.Empty(t, value)
+	snapshot, _ := cache.GetSnapshot(testNode)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	invalid := newTestSnapshot(t, "3")
+	invalid.GetResourcesAndTTL(resource.APIType)["wrong"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	_, err = cache.SetSnapshotDryRun(ctx, testNode, invalid)
+	assert.NotNil(t, err)
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	misindexed := newTestSnapshot(t, "1")
+	misindexed.GetResourcesAndTTL(resource.APIType)["wrong"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	missing := newTestSnapshot(t, "1")
+	missing.GetResourcesAndTTL(resource.APIType)["localhost/foov1"] = types.ResourceWithTTL{}
+
+	tests := []struct {
+		name     string
+		snapshot Snapshot
+		valid    bool
+	}{
+		{
+			name:     "Empty snapshot",
+			snapshot: newTestSnapshot(t, "1"),
+			valid:    true,
+		},
+		{
+			name:     "Resources indexed under their names",
+			snapshot: newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar")),
+			valid:    true,
+		},
+		{
+			name:     "Resource indexed under another name",
+			snapshot: misindexed,
+		},
+		{
+			name:     "Resource not set",
+			snapshot: missing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.valid, tt.snapshot.Validate() == nil)
+
+			// an invalid snapshot is returned to the caller and never stored
+			cache := NewSnapshotCache(false, IDHash{}, nil)
+			assert.Equal(t, tt.valid, cache.SetSnapshot(context.Background(), testNode, tt.snapshot) == nil)
+			_, err := cache.GetSnapshot(testNode)
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+}
+
+func TestGetChecksum(t *testing.T) {
+	a := newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))
+	b := newTestSnapshot(t, "2", newTestAPI("/bar"), newTestAPI("/foo"))
+	assert.Len(t, a.GetChecksum(resource.APIType), 64)
+	// the checksum does not depend on the version or the order of the resources
+	assert.Equal(t, a.GetChecksum(resource.APIType), b.GetChecksum(resource.APIType))
+
+	c := newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/baz"))
+	assert.NotEqual(t, a.GetChecksum(resource.APIType), c.GetChecksum(resource.APIType))
+	matches := CompareChecksums(a, c)
+	assert.False(t, matches[resource.APIType])
+	assert.True(t, matches[resource.ConfigType])
+	assert.True(t, CompareChecksums(a, b)[resource.APIType])
+}
+
+func TestSnapshotChangeListener(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithListenerWorkers(1))
+	listener := &testListener{changes: make(chan string, 10)}
+	cache.RegisterListener(listener)
+
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	cache.ClearSnapshot(testNode)
+	for _, expected := range []string{"set " + testNode + "  1", "set " + testNode + " 1 2", "clear " + testNode} {
+		select {
+		case change := <-listener.changes:
+			assert.Equal(t, expected, change)
+		case <-time.After(time.Second):
+			t.Fatalf("listener not called for %q", expected)
+		}
+	}
+
+	cache.UnregisterListener(listener)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "3", newTestAPI("/foo"))))
+	select {
+	case change := <-listener.changes:
+		t.Fatalf("unregistered listener called for %q", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSnapshotVariant(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithNodeVariantSelector(MetadataVariantSelector("track")))
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, newTestSnapshot(t, "stable", newTestAPI("/foo"))))
+
+	canaryNode := &core.Node{Id: testNode, Metadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+		"track": structpb.NewStringValue("canary"),
+	}}}
+	stable := make(chan envoy_cache.Response, 1)
+	canary := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "stable"},
+		stream.NewStreamState(false, nil), stable)
+	cache.CreateWatch(&envoy_cache.Request{Node: canaryNode, TypeUrl: resource.APIType, VersionInfo: "stable"},
+		stream.NewStreamState(false, nil), canary)
+
+	// only the watch selecting the variant is responded
+	assert.Nil(t, cache.SetSnapshotVariant(ctx, testNode, "canary", newTestSnapshot(t, "canary", newTestAPI("/foo"))))
+	select {
+	case response := <-canary:
+		version, _ := response.GetVersion()
+		assert.Equal(t, "canary", version)
+	case <-time.After(time.Second):
+		t.Fatal("no response for the canary watch")
+	}
+	assert.Empty(t, stable)
+
+	snapshot, err := cache.GetSnapshotVariant(testNode, "canary")
+	assert.Nil(t, err)
+	assert.Equal(t, "canary", snapshot.GetVersion(resource.APIType))
+	_, err = cache.GetSnapshotVariant(testNode, "beta")
+	assert.NotNil(t, err)
+
+	response, err := cache.Fetch(ctx, &envoy_cache.Request{Node: canaryNode, TypeUrl: resource.APIType})
+	assert.Nil(t, err)
+	version, _ := response.GetVersion()
+	assert.Equal(t, "canary", version)
+
+	cache.ClearSnapshot(testNode)
+	_, err = cache.GetSnapshotVariant(testNode, "canary")
+	assert.NotNil(t, err)
+}
+
+func TestSetSnapshotIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+
+	// only one of the racing writers sets the snapshot
+	var wg sync.WaitGroup
+	results := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			set, err := cache.SetSnapshotIfAbsent(ctx, testNode, newTestSnapshot(t, fmt.Sprint(i), newTestAPI("/foo")))
+			assert.Nil(t, err)
+			results <- set
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	count := 0
+	for set := range results {
+		if set {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+
+	snapshot, _ := cache.GetSnapshot(testNode)
+	set, err := cache.SetSnapshotIfAbsent(ctx, testNode, newTestSnapshot(t, "new", newTestAPI("/foo")))
+	assert.Nil(t, err)
+	assert.False(t, set)
+	current, _ := cache.GetSnapshot(testNode)
+	assert.True(t, EqualSnapshot(snapshot, current))
+}
+
+func TestRegionScopedSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	inner := NewSnapshotCache(false, IDHash{}, nil)
+	east := RegionScopedSnapshotCache("us-east", inner)
+	west := RegionScopedSnapshotCache("eu-west", inner)
+	assert.Nil(t, east.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, west.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+
+	snapshot, err := east.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, []string{testNode}, west.ListNodes())
+	assert.ElementsMatch(t, []string{"us-east/" + testNode, "eu-west/" + testNode}, inner.ListNodes())
+	assert.Equal(t, 1, west.ReadView().Len())
+
+	// the requests of a node are answered from the snapshot of its region
+	response, err := west.Fetch(ctx, &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType})
+	assert.Nil(t, err)
+	version, _ := response.GetVersion()
+	assert.Equal(t, "2", version)
+
+	east.ClearSnapshot(testNode)
+	assert.Empty(t, east.ListNodes())
+	assert.Equal(t, []string{testNode}, west.ListNodes())
+}
+
+func TestNamespacedSnapshotCache(t *testing.T) {
+	ctx := context.Background()
+	inner := NewSnapshotCache(false, IDHash{}, nil, WithListenerWorkers(1))
+	tenant, err := NamespacedSnapshotCache("tenant-a", inner)
+	assert.Nil(t, err)
+	other, err := NamespacedSnapshotCache("tenant-b", inner)
+	assert.Nil(t, err)
+	_, err = NamespacedSnapshotCache("tenant/c", inner)
+	assert.NotNil(t, err)
+	_, err = NamespacedSnapshotCache("tenant-c", RegionScopedSnapshotCache("region", inner))
+	assert.NotNil(t, err)
+
+	events := make(chan GlobalWatchEvent, 2)
+	tenant.CreateGlobalWatch(resource.APIType, events)
+	listener := &testListener{changes: make(chan string, 10)}
+	tenant.RegisterListener(listener)
+	assert.False(t, tenant.Ready())
+
+	// the nodes of the tenants with the same ID are isolated
+	assert.Nil(t, other.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.False(t, tenant.Ready())
+	assert.Nil(t, tenant.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.True(t, tenant.Ready())
+	snapshot, err := tenant.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, []string{testNode}, tenant.ListNodes())
+	assert.ElementsMatch(t, []string{"tenant-a/" + testNode, "tenant-b/" + testNode}, inner.ListNodes())
+	assert.Equal(t, testNode, StripNamespace("tenant-a/"+testNode))
+	assert.Equal(t, testNode, StripNamespace(testNode))
+
+	// and the global watches and the listeners of a tenant only see its own nodes
+	assert.Len(t, events, 1)
+	assert.Equal(t, testNode, (<-events).Node)
+	select {
+	case change := <-listener.changes:
+		assert.Equal(t, "set "+testNode+"  1", change)
+	case <-time.After(time.Second):
+		t.Fatal("listener not called")
+	}
+	tenant.UnregisterListener(listener)
+	assert.Nil(t, tenant.SetSnapshot(ctx, testNode, newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))))
+	select {
+	case change := <-listener.changes:
+		t.Fatalf("unregistered listener called for %q", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the selector snapshot of a tenant is only served to its own nodes
+	metadata, err := structpb.NewStruct(map[string]interface{}{"app": "gateway"})
+	assert.Nil(t, err)
+	selected := &envoy_cache.Request{Node: &core.Node{Id: "selected", Metadata: metadata}, TypeUrl: resource.APIType}
+	value := make(chan envoy_cache.Response, 1)
+	tenant.CreateWatch(selected, stream.NewStreamState(false, nil), value)
+	assert.Nil(t, other.SetSnapshotForSelector(ctx, map[string]string{"app": "gateway"}, newTestSnapshot(t, "other", newTestAPI("/foo"))))
+	assert.Empty(t, value)
+	assert.Nil(t, tenant.SetSnapshotForSelector(ctx, map[string]string{"app": "gateway"}, newTestSnapshot(t, "selected", newTestAPI("/foo"))))
+	version, err := (<-value).GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "selected", version)
+
+	// the statistics and the state dump only cover the nodes of the tenant
+	value = make(chan envoy_cache.Response, 1)
+	other.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "2"}, stream.NewStreamState(false, nil), value)
+	assert.Equal(t, 1, tenant.Stats().Snapshots)
+	assert.Equal(t, 0, tenant.Stats().OpenWatches)
+	assert.Equal(t, 1, other.Stats().OpenWatches)
+	assert.Equal(t, inner.MemoryUsageBytes(), tenant.MemoryUsageBytes()+other.MemoryUsageBytes())
+	assert.Less(t, other.MemoryUsageBytes(), tenant.MemoryUsageBytes())
+	var buf bytes.Buffer
+	assert.Nil(t, other.DumpState(&buf))
+	var dump CacheDump
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &dump))
+	assert.Len(t, dump.Nodes, 1)
+	assert.Equal(t, 1, dump.Nodes[testNode].NumWatches)
+
+	// a tenant can neither clear nor reset the nodes of another tenant
+	assert.Nil(t, other.Reset(ctx))
+	_, open := <-value
+	assert.False(t, open)
+	assert.Empty(t, other.ListNodes())
+	assert.Equal(t, []string{"tenant-a/" + testNode}, inner.ListNodes())
+	assert.Equal(t, []string{"selected"}, tenant.GetStatusKeys())
+	assert.Equal(t, 0, other.GarbageCollectStatus())
+	assert.Equal(t, 1, tenant.GarbageCollectStatus())
+	assert.Nil(t, other.SetSnapshot(ctx, testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	assert.Equal(t, 1, other.EvictLRU(0))
+	assert.Equal(t, []string{"tenant-a/" + testNode}, inner.ListNodes())
+
+	// nor drain them
+	assert.Nil(t, other.Drain(ctx))
+	value = make(chan envoy_cache.Response, 1)
+	other.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), value)
+	_, open = <-value
+	assert.False(t, open)
+	value = make(chan envoy_cache.Response, 1)
+	tenant.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}, stream.NewStreamState(false, nil), value)
+	sotw, _ := tenant.WatchCount(testNode)
+	assert.Equal(t, 1, sotw)
+
+	// the node hash of a tenant only hashes its own nodes
+	inner = NewSnapshotCache(false, IDHash{}, nil)
+	tenant, _ = NamespacedSnapshotCache("tenant-a", inner)
+	other, _ = NamespacedSnapshotCache("tenant-b", inner)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode, Cluster: "cluster"}, TypeUrl: resource.APIType}
+	tenant.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	other.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	tenant.SetNodeHash(clusterHash{})
+	assert.Equal(t, []string{"cluster"}, tenant.GetStatusKeys())
+	assert.Equal(t, []string{testNode}, other.GetStatusKeys())
+	assert.ElementsMatch(t, []string{"tenant-a/cluster", "tenant-b/" + testNode}, inner.GetStatusKeys())
+	sotw, _ = tenant.WatchCount("cluster")
+	assert.Equal(t, 1, sotw)
+}
+
+func TestSnapshotBuilder(t *testing.T) {
+	snapshot, err := NewSnapshotBuilder("1").
+		WithAPIs(newTestAPI("/foo"), newTestAPI("/bar")).
+		WithOptions(WithTypeTTL(resource.APIType, time.Second)).
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+	assert.Len(t, snapshot.GetResourcesAndTTL(resource.APIType), 2)
+	assert.NotNil(t, snapshot.GetResourcesAndTTL(resource.APIType)["localhost/foov1"].TTL)
+
+	// the messages of unsupported types are rejected
+	_, err = AddResources(NewSnapshotBuilder("1"), &structpb.Struct{}).Build()
+	assert.NotNil(t, err)
+}
+
+func TestReset(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	assert.Nil(t, cache.Reset(context.Background()))
+	_, more := <-value
+	assert.False(t, more)
+	_, err := cache.GetSnapshot(testNode)
+	assert.NotNil(t, err)
+	assert.Empty(t, cache.GetStatusKeys())
+
+	// watches created after the reset are kept open
+	value = make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+	sotw, _ := cache.WatchCount(testNode)
+	assert.Equal(t, 1, sotw)
+}
+
+func TestHeartbeatWithTypeTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewSnapshotCacheWithHeartbeating(ctx, false, IDHash{}, nil, 10*time.Millisecond)
+
+	snapshot, err := NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.APIType: {newTestAPI("/foo")},
+	}, WithTypeTTL(resource.APIType, time.Second))
+	assert.Nil(t, err)
+	assert.NotNil(t, snapshot.GetResourcesAndTTL(resource.APIType)["localhost/foov1"].TTL)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, snapshot))
+
+	// an up to date watch is only responded by the heartbeat
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	select {
+	case response := <-value:
+		assert.True(t, response.(*envoy_cache.RawResponse).Heartbeat)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat was not sent")
+	}
+}
+
+func TestHeartbeatIntervalFromTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewSnapshotCacheWithHeartbeating(ctx, false, IDHash{}, nil, time.Hour)
+
+	snapshot, err := NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.APIType: {newTestAPI("/foo")},
+	}, WithTypeTTL(resource.APIType, 100*time.Millisecond))
+	assert.Nil(t, err)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, snapshot))
+
+	// the heartbeat is sent within half of the TTL rather than the interval
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+	assert.Eventually(t, func() bool {
+		return !cache.GetStatusInfo(testNode).GetNextHeartbeatTime().IsZero()
+	}, time.Second, time.Millisecond)
+	assert.WithinDuration(t, time.Now(), cache.GetStatusInfo(testNode).GetNextHeartbeatTime(), 100*time.Millisecond)
+
+	select {
+	case response := <-value:
+		assert.True(t, response.(*envoy_cache.RawResponse).Heartbeat)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat was not sent")
+	}
+}
+
+// blockingListener blocks the listener workers until release is closed.
+type blockingListener struct {
+	release chan struct{}
+}
+
+func (l *blockingListener) OnSet(context.Context, string, Snapshot, Snapshot) { <-l.release }
+func (l *blockingListener) OnClear(context.Context, string)                   { <-l.release }
+
+func TestHeartbeatRescheduledWithBlockedListeners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewSnapshotCacheWithHeartbeating(ctx, false, IDHash{}, nil, time.Hour, WithListenerWorkers(1))
+	listener := &blockingListener{release: make(chan struct{})}
+	defer close(listener.release)
+	cache.RegisterListener(listener)
+
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIListType}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	for i := 0; i <= listenerQueueSize; i++ {
+		assert.Nil(t, cache.SetSnapshot(ctx, fmt.Sprintf("node-%d", i), newTestSnapshot(t, "1")))
+	}
+
+	// the snapshot set is rescheduled although its listener calls are dropped
+	snapshot, err := NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.APIType: {newTestAPI("/foo")},
+	}, WithTypeTTL(resource.APIType, 100*time.Millisecond))
+	assert.Nil(t, err)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, snapshot))
+	assert.Eventually(t, func() bool {
+		return time.Until(cache.GetStatusInfo(testNode).GetNextHeartbeatTime()) < time.Minute
+	}, time.Second, time.Millisecond)
+}
+
+func TestSetHeartbeatInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := NewSnapshotCacheWithHeartbeating(ctx, false, IDHash{}, nil, time.Hour)
+
+	snapshot, err := NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.APIType: {newTestAPI("/foo")},
+	}, WithTypeTTL(resource.APIType, time.Second))
+	assert.Nil(t, err)
+	assert.Nil(t, cache.SetSnapshot(ctx, testNode, snapshot))
+
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}
+	value := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+
+	// the heartbeat is sent within two periods of the new interval
+	interval := 50 * time.Millisecond
+	cache.SetHeartbeatInterval(interval)
+	select {
+	case response := <-value:
+		assert.True(t, response.(*envoy_cache.RawResponse).Heartbeat)
+	case <-time.After(2 * interval):
+		t.Fatal("heartbeat was not sent at the new interval")
+	}
+
+	// the interval is not changed once the heartbeating is cancelled
+	cancel()
+	cache.SetHeartbeatInterval(interval)
+}
+
+func TestSnapshotMerger(t *testing.T) {
+	ctx := context.Background()
+	short, long := time.Second, time.Minute
+	first := NewSnapshotCache(false, IDHash{}, nil)
+	second := NewSnapshotCache(false, IDHash{}, nil)
+	snapshot, err := NewSnapshot("1", map[resource.Type][]types.Resource{
+		resource.APIType: {newTestAPI("/foo"), newTestAPI("/bar")},
+	}, WithTypeTTL(resource.APIType, short))
+	assert.Nil(t, err)
+	assert.Nil(t, first.SetSnapshot(ctx, testNode, snapshot))
+	modified := newTestAPI("/foo")
+	modified.Title = "modified"
+	snapshot, err = NewSnapshot("2", map[resource.Type][]types.Resource{
+		resource.APIType: {modified},
+	}, WithTypeTTL(resource.APIType, long))
+	assert.Nil(t, err)
+	assert.Nil(t, second.SetSnapshot(ctx, testNode, snapshot))
+
+	merged, err := SnapshotMerger{first, second, NewSnapshotCache(false, IDHash{}, nil)}.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1+2", merged.GetVersion(resource.APIType))
+	resources := merged.GetResourcesAndTTL(resource.APIType)
+	assert.Len(t, resources, 2)
+	assert.True(t, proto.Equal(modified, resources["localhost/foov1"].Resource))
+	assert.Equal(t, short, *resources["localhost/foov1"].TTL)
+
+	_, err = SnapshotMerger{first, second}.GetSnapshot("other")
+	assert.NotNil(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	modified := newTestAPI("/bar")
+	modified.Title = "bar"
+	a := newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))
+	b := newTestSnapshot(t, "2", modified, newTestAPI("/baz"))
+
+	diff := Diff(a, b)
+	assert.Equal(t, SnapshotDiff{
+		resource.APIType: {
+			Added:    []string{"localhost/bazv1"},
+			Modified: []string{"localhost/barv1"},
+			Removed:  []string{"localhost/foov1"},
+		},
+	}, diff)
+	assert.True(t, Diff(a, a).Empty())
+	assert.Equal(t, "no changes", Diff(a, a).String())
+}
+
+func TestResourceCounts(t *testing.T) {
+	snapshot := newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"))
+	assert.Equal(t, 2, snapshot.ResourceCount(resource.APIType))
+	assert.Equal(t, 0, snapshot.ResourceCount(resource.ConfigType))
+	assert.Equal(t, map[string]int{resource.APIType: 2}, snapshot.ResourceCounts())
+}
+
+func TestCloneSnapshot(t *testing.T) {
+	snapshot := newTestSnapshot(t, "1", newTestAPI("/foo"))
+	clone := CloneSnapshot(snapshot)
+	assert.True(t, Diff(snapshot, clone).Empty())
+
+	// modifying the clone does not change the original snapshot
+	clone.GetResourcesAndTTL(resource.APIType)["localhost/foov1"].Resource.(*api.Api).BasePath = "/bar"
+	delete(clone.GetResourcesAndTTL(resource.APIType), "localhost/foov1")
+	assert.Equal(t, "/foo", snapshot.GetResourcesAndTTL(resource.APIType)["localhost/foov1"].Resource.(*api.Api).BasePath)
+}
+
+func TestConstructVersionMapOrder(t *testing.T) {
+	first := newTestSnapshot(t, "1", newTestAPI("/foo"), newTestAPI("/bar"), newTestAPI("/baz"))
+	second := newTestSnapshot(t, "1", newTestAPI("/baz"), newTestAPI("/foo"), newTestAPI("/bar"))
+	assert.Nil(t, first.ConstructVersionMap())
+	assert.Nil(t, second.ConstructVersionMap())
+	assert.Equal(t, first.VersionMap, second.VersionMap)
+}
+
+func TestSetSnapshots(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		nodes   []string
+		blocked bool
+		wantErr bool
+	}{
+		{
+			name: "No snapshots",
+			ctx:  context.Background(),
+		},
+		{
+			name:  "Single node",
+			ctx:   context.Background(),
+			nodes: []string{"node-1"},
+		},
+		{
+			name:  "Multiple nodes",
+			ctx:   context.Background(),
+			nodes: []string{"node-1", "node-2", "node-3"},
+		},
+		{
+			name:    "Watch not responded before the context is cancelled",
+			ctx:     cancelled,
+			nodes:   []string{"node-1"},
+			blocked: true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewSnapshotCache(false, IDHash{}, nil)
+			values := make(map[string]chan envoy_cache.Response)
+			snapshots := make(map[string]Snapshot)
+			for _, node := range tt.nodes {
+				values[node] = make(chan envoy_cache.Response, 1)
+				if tt.blocked {
+					values[node] = make(chan envoy_cache.Response)
+				}
+				request := &envoy_cache.Request{Node: &core.Node{Id: node}, TypeUrl: resource.APIType}
+				cache.CreateWatch(request, stream.NewStreamState(false, nil), values[node])
+				snapshots[node] = newTestSnapshot(t, "1", newTestAPI("/"+node))
+			}
+
+			err := cache.SetSnapshots(tt.ctx, snapshots)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if tt.wantErr {
+				return
+			}
+			for _, node := range tt.nodes {
+				response := (<-values[node]).(*envoy_cache.RawResponse)
+				assert.Equal(t, "1", response.Version)
+				snapshot, err := cache.GetSnapshot(node)
+				assert.Nil(t, err)
+				assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+			}
+		})
+	}
+}
+
+func TestSetSnapshotsWithBatchRespondStrategy(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithRespondStrategy(BatchRespondStrategy{Workers: 2}))
+
+	nodes := []string{"node-1", "node-2", "node-3"}
+	values := make(map[string]chan envoy_cache.Response)
+	snapshots := make(map[string]Snapshot)
+	for _, node := range nodes {
+		values[node] = make(chan envoy_cache.Response, 1)
+		request := &envoy_cache.Request{Node: &core.Node{Id: node}, TypeUrl: resource.APIType}
+		cache.CreateWatch(request, stream.NewStreamState(false, nil), values[node])
+		snapshots[node] = newTestSnapshot(t, "1", newTestAPI("/"+node))
+	}
+
+	assert.Nil(t, cache.SetSnapshots(context.Background(), snapshots))
+	for _, node := range nodes {
+		response := (<-values[node]).(*envoy_cache.RawResponse)
+		assert.Equal(t, "1", response.Version)
+		sotw, _ := cache.WatchCount(node)
+		assert.Equal(t, 0, sotw)
+	}
+}
+
+func TestPersistentSnapshotCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := PersistentSnapshotCache(dir, NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the snapshot is loaded into a new cache with stale versions
+	restored, err := PersistentSnapshotCache(dir, NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, err)
+	snapshot, err := restored.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1"+StaleVersionSuffix, snapshot.GetVersion(resource.APIType))
+	assert.True(t, Diff(newTestSnapshot(t, "1", newTestAPI("/foo")), snapshot).Empty())
+
+	// the snapshot is not loaded once cleared
+	restored.ClearSnapshot(testNode)
+	restored, err = PersistentSnapshotCache(dir, NewSnapshotCache(false, IDHash{}, nil), nil)
+	assert.Nil(t, err)
+	_, err = restored.GetSnapshot(testNode)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotTTL(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithSnapshotTTL(40*time.Millisecond))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "stale", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the snapshot refreshed within the TTL is kept while the other one expires
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	}
+	_, err := cache.GetSnapshot("stale")
+	assert.NotNil(t, err)
+	_, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+
+	// and a zero TTL disables the expiry
+	cache = NewSnapshotCache(false, IDHash{}, nil, WithSnapshotTTL(0))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	time.Sleep(50 * time.Millisecond)
+	_, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+}
+
+// clusterHash maps a node to its cluster.
+type clusterHash struct{}
+
+func (clusterHash) ID(node *core.Node) string {
+	return node.GetCluster()
+}
+
+func TestSetNodeHash(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	value := make(chan envoy_cache.Response, 1)
+	request := &envoy_cache.Request{Node: &core.Node{Id: testNode, Cluster: "cluster"}, TypeUrl: resource.APIType}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), value)
+	assert.Equal(t, []string{testNode}, cache.GetStatusKeys())
+
+	// the status of the node, with its open watch, is migrated to the ID of the new hash
+	cache.SetNodeHash(clusterHash{})
+	assert.Equal(t, []string{"cluster"}, cache.GetStatusKeys())
+	sotw, _ := cache.WatchCount("cluster")
+	assert.Equal(t, 1, sotw)
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "cluster", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	response := <-value
+	version, err := response.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", version)
+}
+
+func TestSetNodeHashCollision(t *testing.T) {
+	metrics := newRecordingMetrics()
+	cache := NewSnapshotCache(false, IDHash{}, nil, WithMetrics(metrics))
+	values := make(map[string]chan envoy_cache.Response)
+	for _, node := range []string{"a", "b"} {
+		values[node] = make(chan envoy_cache.Response, 1)
+		request := &envoy_cache.Request{Node: &core.Node{Id: node, Cluster: "cluster"}, TypeUrl: resource.APIType}
+		cache.CreateWatch(request, stream.NewStreamState(false, nil), values[node])
+	}
+
+	// only one of the statuses hashed to the same node ID is kept, and the watch of the other
+	// is closed
+	cache.SetNodeHash(clusterHash{})
+	assert.Equal(t, []string{"cluster"}, cache.GetStatusKeys())
+	sotw, _ := cache.WatchCount("cluster")
+	assert.Equal(t, 1, sotw)
+	assert.Equal(t, 1, metrics.count("WatchCancelled"))
+	assert.Equal(t, 1, metrics.count("WatchClosed"))
+
+	closed := 0
+	for _, value := range values {
+		select {
+		case _, open := <-value:
+			assert.False(t, open)
+			closed++
+		default:
+		}
+	}
+	assert.Equal(t, 1, closed)
+}
+
+func TestGetOrCreateSnapshot(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	var calls int32
+	factory := func() Snapshot {
+		atomic.AddInt32(&calls, 1)
+		return newTestSnapshot(t, "1", newTestAPI("/foo"))
+	}
+
+	// concurrent callers create the snapshot once and all get it
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot, err := cache.GetOrCreateSnapshot(context.Background(), testNode, factory)
+			assert.Nil(t, err)
+			assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// and an existing snapshot is returned unchanged
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/bar"))))
+	snapshot, err := cache.GetOrCreateSnapshot(context.Background(), testNode, factory)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestAutoVersioning(t *testing.T) {
+	cache := NewSnapshotCacheWithAutoVersioning(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "", newTestAPI("/foo"))))
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	// the counter of each node increases with every snapshot set without a version
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "", newTestAPI("/bar"))))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "other", newTestSnapshot(t, "", newTestAPI("/bar"))))
+	snapshot, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+	snapshot, err = cache.GetSnapshot("other")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	// while a version set by the caller is kept
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "custom", newTestAPI("/baz"))))
+	snapshot, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "custom", snapshot.GetVersion(resource.APIType))
+}
+
+func TestReady(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.False(t, cache.Ready())
+
+	// an empty snapshot does not make the cache ready
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "empty", newTestSnapshot(t, "1")))
+	assert.False(t, cache.Ready())
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.True(t, cache.Ready())
+	cache.ClearSnapshot(testNode)
+	assert.False(t, cache.Ready())
+}
+
+func TestCompareAndSwapSnapshot(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+
+	// the swap fails if the current version differs from the expected one
+	swapped, err := cache.CompareAndSwapSnapshot(context.Background(), testNode, newTestSnapshot(t, "0"), newTestSnapshot(t, "2", newTestAPI("/bar")))
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", snapshot.GetVersion(resource.APIType))
+
+	swapped, err = cache.CompareAndSwapSnapshot(context.Background(), testNode, snapshot, newTestSnapshot(t, "2", newTestAPI("/bar")))
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	snapshot, err = cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+}
+
+func TestListNodes(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Empty(t, cache.ListNodes())
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-1", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Nil(t, cache.SetSnapshot(context.Background(), "node-2", newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	request := &envoy_cache.Request{Node: &core.Node{Id: "node-3"}, TypeUrl: resource.APIType}
+	cache.CreateWatch(request, stream.NewStreamState(false, nil), make(chan envoy_cache.Response, 1))
+	assert.ElementsMatch(t, []string{"node-1", "node-2"}, cache.ListNodes())
+
+	// a node with a status but a cleared snapshot is not listed
+	cache.ClearSnapshot("node-2")
+	assert.Equal(t, []string{"node-1"}, cache.ListNodes())
+}
+
+func TestSnapshotAge(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	_, err := cache.SnapshotAge(testNode)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	time.Sleep(20 * time.Millisecond)
+	age, err := cache.SnapshotAge(testNode)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, age, 20*time.Millisecond)
+
+	// setting a new snapshot resets the age
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "2", newTestAPI("/foo"))))
+	age, err = cache.SnapshotAge(testNode)
+	assert.Nil(t, err)
+	assert.Less(t, age, 20*time.Millisecond)
+
+	cache.ClearSnapshot(testNode)
+	_, err = cache.SnapshotAge(testNode)
+	assert.NotNil(t, err)
+}
+
+func TestPatchSnapshot(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	apis := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.APIType, VersionInfo: "1"}, stream.NewStreamState(false, nil), apis)
+	configs := make(chan envoy_cache.Response, 1)
+	cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: testNode}, TypeUrl: resource.ConfigType}, stream.NewStreamState(false, nil), configs)
+
+	// the patched resources are merged into the snapshot and only the watch of the type is responded
+	api := newTestAPI("/bar")
+	assert.Nil(t, cache.PatchSnapshot(context.Background(), testNode, resource.APIType, map[string]types.ResourceWithTTL{GetResourceName(api): {Resource: api}}, "2"))
+	response := <-apis
+	version, err := response.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "2", version)
+	assert.Len(t, response.(*envoy_cache.RawResponse).Resources, 2)
+	assert.Empty(t, configs)
+
+	snapshot, err := cache.GetSnapshot(testNode)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", snapshot.GetVersion(resource.APIType))
+	assert.Equal(t, "", snapshot.GetVersion(resource.ConfigType))
+	assert.Len(t, snapshot.GetResources(resource.APIType), 2)
+
+	assert.NotNil(t, cache.PatchSnapshot(context.Background(), testNode, "unknown", nil, "3"))
+}
+
+// tagMiddleware records its tag whenever a snapshot is set through it.
+func tagMiddleware(tag string, calls *[]string) Middleware {
+	return func(next SnapshotCache) SnapshotCache {
+		return &taggedCache{SnapshotCache: next, tag: tag, calls: calls}
+	}
+}
+
+type taggedCache struct {
+	SnapshotCache
+	tag   string
+	calls *[]string
+}
+
+func (c *taggedCache) SetSnapshot(ctx context.Context, node string, snapshot Snapshot) error {
+	*c.calls = append(*c.calls, c.tag)
+	return c.SnapshotCache.SetSnapshot(ctx, node, snapshot)
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMiddleware("test", registry)
+	assert.Nil(t, err)
+	var calls []string
+	cache := NewMiddlewareChain(NewSnapshotCache(false, IDHash{}, nil), tagMiddleware("outer", &calls), PassthroughMiddleware, metrics, tagMiddleware("inner", &calls))
+
+	// the first middleware is the outermost
+	assert.Nil(t, cache.SetSnapshot(context.Background(), testNode, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	assert.Equal(t, []string{"outer", "inner"}, calls)
+	_, err = cache.GetSnapshot("unknown")
+	assert.NotNil(t, err)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	assert.Len(t, families, 1)
+	observed := map[string]uint64{}
+	for _, m := range families[0].GetMetric() {
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		observed[labels["operation"]+"/"+labels["success"]] = m.GetHistogram().GetSampleCount()
+	}
+	assert.Equal(t, map[string]uint64{"SetSnapshot/true": 1, "GetSnapshot/false": 1}, observed)
+}
+
+func TestForeachSnapshot(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	for _, node := range []string{"a", "b", "c"} {
+		assert.Nil(t, cache.SetSnapshot(context.Background(), node, newTestSnapshot(t, "1", newTestAPI("/"+node))))
+	}
+
+	visited := map[string]string{}
+	cache.ForeachSnapshot(func(node string, snapshot Snapshot) bool {
+		visited[node] = snapshot.GetVersion(resource.APIType)
+		return true
+	})
+	assert.Equal(t, map[string]string{"a": "1", "b": "1", "c": "1"}, visited)
+
+	// the iteration stops once the function returns false
+	count := 0
+	cache.ForeachSnapshot(func(string, Snapshot) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestSetSnapshotForNodes(t *testing.T) {
+	cache := NewSnapshotCache(false, IDHash{}, nil)
+	var values []chan envoy_cache.Response
+	for _, node := range []string{"a", "b"} {
+		value := make(chan envoy_cache.Response, 1)
+		cache.CreateWatch(&envoy_cache.Request{Node: &core.Node{Id: node}, TypeUrl: resource.APIType}, stream.NewStreamState(false, nil), value)
+		values = append(values, value)
+	}
+
+	// the watches of all the nodes are responded with the same snapshot
+	assert.Nil(t, cache.SetSnapshotForNodes(context.Background(), []string{"a", "b"}, newTestSnapshot(t, "1", newTestAPI("/foo"))))
+	for _, value := range values {
+		response := <-value
+		version, err := response.GetVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, "1", version)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, cache.ListNodes())
+
+	// and the error of each node is returned
+	invalid := newTestSnapshot(t, "2")
+	invalid.Resources[GetResponseType(resource.APIType)].Items["wrong-name"] = types.ResourceWithTTL{Resource: newTestAPI("/foo")}
+	err := cache.SetSnapshotForNodes(context.Background(), []string{"a", "b"}, invalid)
+	joined, ok := err.(interface{ Unwrap() []error })
+	assert.True(t, ok)
+	assert.Len(t, joined.Unwrap(), 2)
+}
@@ -16,11 +16,14 @@ package cache
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
 	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+	"google.golang.org/protobuf/proto"
 )
 
 // Snapshot is an internally consistent snapshot of xDS resources.
@@ -29,13 +32,66 @@ import (
 type Snapshot struct {
 	envoy_cache.Snapshot
 	Resources [wso2_types.UnknownType]envoy_cache.Resources
-	// Only used for delta XDS. Hence it remains unused for adapter implementation.
+	// VersionMap holds the resource hashes of the snapshot, indexed by type URL.
+	// It remains nil until ConstructVersionMap is called and is only used for delta xDS.
 	VersionMap map[string]map[string]string
 }
 
+// SnapshotOption configures a snapshot at construction time.
+type SnapshotOption func(*Snapshot) error
+
+// WithTypeTTL sets the TTL of every resource of a type in the snapshot. The resources
+// with a TTL are included in the heartbeats of a heartbeating cache.
+func WithTypeTTL(typeURL resource.Type, ttl time.Duration) SnapshotOption {
+	return func(s *Snapshot) error {
+		index := GetResponseType(typeURL)
+		if index == wso2_types.UnknownType {
+			return errors.New("unknown resource type: " + typeURL)
+		}
+
+		for name, item := range s.Resources[index].Items {
+			item.TTL = &ttl
+			s.Resources[index].Items[name] = item
+		}
+		return nil
+	}
+}
+
+// CloneSnapshot returns a deep copy of a snapshot. The resources are copied with proto.Clone,
+// so the copy can be modified without changing the snapshot it was cloned from.
+func CloneSnapshot(s Snapshot) Snapshot {
+	out := Snapshot{Snapshot: s.Snapshot}
+	for i, resources := range s.Resources {
+		if resources.Items == nil {
+			out.Resources[i].Version = resources.Version
+			continue
+		}
+		items := make(map[string]types.ResourceWithTTL, len(resources.Items))
+		for name, item := range resources.Items {
+			clone := types.ResourceWithTTL{Resource: proto.Clone(item.Resource)}
+			if item.TTL != nil {
+				ttl := *item.TTL
+				clone.TTL = &ttl
+			}
+			items[name] = clone
+		}
+		out.Resources[i] = envoy_cache.Resources{Version: resources.Version, Items: items}
+	}
+	if s.VersionMap != nil {
+		out.VersionMap = make(map[string]map[string]string, len(s.VersionMap))
+		for typeURL, versions := range s.VersionMap {
+			out.VersionMap[typeURL] = make(map[string]string, len(versions))
+			for name, version := range versions {
+				out.VersionMap[typeURL][name] = version
+			}
+		}
+	}
+	return out
+}
+
 // NewSnapshot creates a snapshot from response types and a version.
 // The resources map is keyed off the type URL of a resource, followed by the slice of resource objects.
-func NewSnapshot(version string, resources map[resource.Type][]types.Resource) (Snapshot, error) {
+func NewSnapshot(version string, resources map[resource.Type][]types.Resource, opts ...SnapshotOption) (Snapshot, error) {
 	out := Snapshot{}
 
 	for typ, resource := range resources {
@@ -47,26 +103,34 @@ func NewSnapshot(version string, resources map[resource.Type][]types.Resource) (
 		out.Resources[index] = NewResources(version, resource)
 	}
 
+	for _, opt := range opts {
+		if err := opt(&out); err != nil {
+			return out, err
+		}
+	}
+
 	return out, nil
 }
 
-// // GetResources selects snapshot resources by type, returning the map of resources.
-// func (s *Snapshot) GetResources(typeURL resource.Type) map[string]types.Resource {
-// 	resources := s.GetResourcesAndTTL(typeURL)
-// 	if resources == nil {
-// 		return nil
-// 	}
+// GetResources selects snapshot resources by type, returning the map of resources.
+func (s *Snapshot) GetResources(typeURL resource.Type) map[string]types.Resource {
+	resources := s.GetResourcesAndTTL(typeURL)
+	if resources == nil {
+		return nil
+	}
 
-// 	withoutTTL := make(map[string]types.Resource, len(resources))
+	withoutTTL := make(map[string]types.Resource, len(resources))
 
-// 	for k, v := range resources {
-// 		withoutTTL[k] = v.Resource
-// 	}
+	for k, v := range resources {
+		withoutTTL[k] = v.Resource
+	}
 
-// 	return withoutTTL
-// }
+	return withoutTTL
+}
 
 // GetResourcesAndTTL selects snapshot resources by type, returning the map of resources and the associated TTL.
+// The map has no iteration order; the order of the resources in the responses is set with
+// WithResourceOrdering.
 func (s *Snapshot) GetResourcesAndTTL(typeURL resource.Type) map[string]types.ResourceWithTTL {
 	if s == nil {
 		return nil
@@ -90,6 +154,102 @@ func (s *Snapshot) GetVersion(typeURL string) string {
 	return s.Resources[typ].Version
 }
 
+// ResourceCount returns the number of resources of a type.
+func (s *Snapshot) ResourceCount(typeURL resource.Type) int {
+	return len(s.GetResourcesAndTTL(typeURL))
+}
+
+// ResourceCounts returns the number of resources of each type, indexed by type URL.
+// Types without resources are not included.
+func (s *Snapshot) ResourceCounts() map[string]int {
+	counts := make(map[string]int)
+	if s == nil {
+		return counts
+	}
+	for i, resources := range s.Resources {
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil || len(resources.Items) == 0 {
+			continue
+		}
+		counts[typeURL] = len(resources.Items)
+	}
+	return counts
+}
+
+// Validate checks that the snapshot is internally consistent. Each resource must be set,
+// indexed under its resource name, and belong to a resource type with a known type URL.
+//
+// The WSO2 resource types do not reference each other by name, unlike the Envoy
+// LDS/RDS and CDS/EDS resources, so there are no cross references to check.
+func (s *Snapshot) Validate() error {
+	if s == nil {
+		return errors.New("missing snapshot")
+	}
+
+	for i, resources := range s.Resources {
+		if len(resources.Items) == 0 {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			return err
+		}
+		for name, item := range resources.Items {
+			if item.Resource == nil {
+				return fmt.Errorf("resource %q of type %s is nil", name, typeURL)
+			}
+			if resourceName := GetResourceName(item.Resource); resourceName != name {
+				return fmt.Errorf("resource %q of type %s is indexed under name %q", resourceName, typeURL, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetVersionMap returns the resource version map of a type, constructed with ConstructVersionMap.
+func (s *Snapshot) GetVersionMap(typeURL string) map[string]string {
+	return s.VersionMap[typeURL]
+}
+
+// ConstructVersionMap computes a hash of every resource in the snapshot to be used as the
+// resource version in delta xDS. The map is only constructed once per snapshot.
+//
+// Each hash depends only on the deterministic serialization of its resource, so the versions
+// are the same whatever order the resources were added to the snapshot in.
+func (s *Snapshot) ConstructVersionMap() error {
+	if s == nil {
+		return errors.New("missing snapshot")
+	}
+
+	// The snapshot resources never change, so we can ignore the call if the map is already built.
+	if s.VersionMap != nil {
+		return nil
+	}
+
+	versionMap := make(map[string]map[string]string)
+	for i, resources := range s.Resources {
+		if len(resources.Items) == 0 {
+			continue
+		}
+		typeURL, err := GetResponseTypeURL(wso2_types.ResponseType(i))
+		if err != nil {
+			return err
+		}
+		versionMap[typeURL] = make(map[string]string, len(resources.Items))
+		for name, r := range resources.Items {
+			marshaled, err := envoy_cache.MarshalResource(r.Resource)
+			if err != nil {
+				return err
+			}
+			versionMap[typeURL][name] = envoy_cache.HashResource(marshaled)
+		}
+	}
+	s.VersionMap = versionMap
+
+	return nil
+}
+
 // IndexResourcesByName creates a map from the resource name to the resource.
 func IndexResourcesByName(items []types.ResourceWithTTL) map[string]types.ResourceWithTTL {
 	indexed := make(map[string]types.ResourceWithTTL)
@@ -0,0 +1,314 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"math/bits"
+)
+
+const (
+	// hamtBits is the number of hash bits consumed at each level of the trie
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+)
+
+// snapshotMap is an immutable map of the snapshots indexed by node IDs, implemented as a hash
+// array mapped trie. Setting or deleting a node returns a new map which shares all the other
+// nodes with the previous one, so that a map loaded by a reader is never modified. The nil map
+// is the empty map.
+type snapshotMap struct {
+	root *hamtNode
+	size int
+}
+
+// hamtNode is a level of the trie. The bitmap has a bit set for each present child, which are
+// stored in the order of their bits.
+type hamtNode struct {
+	bitmap   uint32
+	children []hamtChild
+}
+
+// hamtChild is either a sub-level or a leaf.
+type hamtChild struct {
+	node *hamtNode
+	leaf *hamtLeaf
+}
+
+// hamtLeaf holds the entries of the nodes with the same hash.
+type hamtLeaf struct {
+	hash    uint32
+	entries []snapshotEntry
+}
+
+type snapshotEntry struct {
+	node     string
+	snapshot Snapshot
+}
+
+// hashNode is the 32-bit FNV-1a hash of a node ID.
+func hashNode(node string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(node); i++ {
+		h ^= uint32(node[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// position returns the bit of the child of a hash at a level, and its index in the children.
+func (n *hamtNode) position(hash uint32, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// len returns the number of nodes in the map.
+func (m *snapshotMap) len() int {
+	if m == nil {
+		return 0
+	}
+	return m.size
+}
+
+// get returns the snapshot of a node, and whether the node is in the map.
+func (m *snapshotMap) get(node string) (Snapshot, bool) {
+	if m == nil {
+		return Snapshot{}, false
+	}
+	hash := hashNode(node)
+	n := m.root
+	for shift := uint(0); n != nil; shift += hamtBits {
+		bit, i := n.position(hash, shift)
+		if n.bitmap&bit == 0 {
+			break
+		}
+		child := n.children[i]
+		if child.leaf == nil {
+			n = child.node
+			continue
+		}
+		if child.leaf.hash == hash {
+			for _, entry := range child.leaf.entries {
+				if entry.node == node {
+					return entry.snapshot, true
+				}
+			}
+		}
+		break
+	}
+	return Snapshot{}, false
+}
+
+// set returns a map with the snapshot of a node set.
+func (m *snapshotMap) set(node string, snapshot Snapshot) *snapshotMap {
+	entry := snapshotEntry{node: node, snapshot: snapshot}
+	if m == nil || m.root == nil {
+		leaf := &hamtLeaf{hash: hashNode(node), entries: []snapshotEntry{entry}}
+		return &snapshotMap{root: newLeafNode(leaf, 0), size: 1}
+	}
+	root, added := m.root.set(hashNode(node), 0, entry)
+	size := m.size
+	if added {
+		size++
+	}
+	return &snapshotMap{root: root, size: size}
+}
+
+// delete returns a map without the snapshot of a node.
+func (m *snapshotMap) delete(node string) *snapshotMap {
+	if m == nil || m.root == nil {
+		return m
+	}
+	root, removed := m.root.delete(hashNode(node), 0, node)
+	if !removed {
+		return m
+	}
+	return &snapshotMap{root: root, size: m.size - 1}
+}
+
+// forEach calls fn for the snapshot of each node in no particular order, until fn returns
+// false. It reports whether all nodes were visited.
+func (m *snapshotMap) forEach(fn func(node string, snapshot Snapshot) bool) bool {
+	if m == nil || m.root == nil {
+		return true
+	}
+	return m.root.forEach(fn)
+}
+
+func newLeafNode(leaf *hamtLeaf, shift uint) *hamtNode {
+	return &hamtNode{
+		bitmap:   uint32(1) << ((leaf.hash >> shift) & hamtMask),
+		children: []hamtChild{{leaf: leaf}},
+	}
+}
+
+// set returns a copy of the level with the entry set, and whether the entry was added rather
+// than replaced.
+func (n *hamtNode) set(hash uint32, shift uint, entry snapshotEntry) (*hamtNode, bool) {
+	bit, i := n.position(hash, shift)
+	if n.bitmap&bit == 0 {
+		children := make([]hamtChild, len(n.children)+1)
+		copy(children, n.children[:i])
+		children[i] = hamtChild{leaf: &hamtLeaf{hash: hash, entries: []snapshotEntry{entry}}}
+		copy(children[i+1:], n.children[i:])
+		return &hamtNode{bitmap: n.bitmap | bit, children: children}, true
+	}
+
+	var child hamtChild
+	added := true
+	switch current := n.children[i]; {
+	case current.node != nil:
+		var sub *hamtNode
+		sub, added = current.node.set(hash, shift+hamtBits, entry)
+		child = hamtChild{node: sub}
+	case current.leaf.hash == hash:
+		var leaf *hamtLeaf
+		leaf, added = current.leaf.set(entry)
+		child = hamtChild{leaf: leaf}
+	default:
+		leaf := &hamtLeaf{hash: hash, entries: []snapshotEntry{entry}}
+		child = hamtChild{node: mergeLeaves(current.leaf, leaf, shift+hamtBits)}
+	}
+
+	children := make([]hamtChild, len(n.children))
+	copy(children, n.children)
+	children[i] = child
+	return &hamtNode{bitmap: n.bitmap, children: children}, added
+}
+
+// mergeLeaves returns the levels which hold two leaves of different hashes.
+func mergeLeaves(a, b *hamtLeaf, shift uint) *hamtNode {
+	ia, ib := (a.hash>>shift)&hamtMask, (b.hash>>shift)&hamtMask
+	if ia == ib {
+		return &hamtNode{
+			bitmap:   uint32(1) << ia,
+			children: []hamtChild{{node: mergeLeaves(a, b, shift+hamtBits)}},
+		}
+	}
+	if ia > ib {
+		a, b = b, a
+	}
+	return &hamtNode{
+		bitmap:   uint32(1)<<ia | uint32(1)<<ib,
+		children: []hamtChild{{leaf: a}, {leaf: b}},
+	}
+}
+
+// delete returns a copy of the level without the node, or nil if the level is left empty,
+// and whether the node was found.
+func (n *hamtNode) delete(hash uint32, shift uint, node string) (*hamtNode, bool) {
+	bit, i := n.position(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+
+	var child hamtChild
+	switch current := n.children[i]; {
+	case current.node != nil:
+		sub, removed := current.node.delete(hash, shift+hamtBits, node)
+		if !removed {
+			return n, false
+		}
+		if sub != nil && len(sub.children) == 1 && sub.children[0].leaf != nil {
+			// a level left with a single leaf is collapsed into the leaf
+			child = sub.children[0]
+		} else if sub != nil {
+			child = hamtChild{node: sub}
+		}
+	case current.leaf.hash == hash:
+		leaf, removed := current.leaf.delete(node)
+		if !removed {
+			return n, false
+		}
+		if leaf != nil {
+			child = hamtChild{leaf: leaf}
+		}
+	default:
+		return n, false
+	}
+
+	if child.node == nil && child.leaf == nil {
+		if len(n.children) == 1 {
+			return nil, true
+		}
+		children := make([]hamtChild, 0, len(n.children)-1)
+		children = append(children, n.children[:i]...)
+		children = append(children, n.children[i+1:]...)
+		return &hamtNode{bitmap: n.bitmap &^ bit, children: children}, true
+	}
+	children := make([]hamtChild, len(n.children))
+	copy(children, n.children)
+	children[i] = child
+	return &hamtNode{bitmap: n.bitmap, children: children}, true
+}
+
+func (n *hamtNode) forEach(fn func(node string, snapshot Snapshot) bool) bool {
+	for _, child := range n.children {
+		if child.node != nil {
+			if !child.node.forEach(fn) {
+				return false
+			}
+			continue
+		}
+		for _, entry := range child.leaf.entries {
+			if !fn(entry.node, entry.snapshot) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// set returns a copy of the leaf with the entry set, and whether the entry was added.
+func (l *hamtLeaf) set(entry snapshotEntry) (*hamtLeaf, bool) {
+	entries := make([]snapshotEntry, len(l.entries), len(l.entries)+1)
+	copy(entries, l.entries)
+	for i := range entries {
+		if entries[i].node == entry.node {
+			entries[i] = entry
+			return &hamtLeaf{hash: l.hash, entries: entries}, false
+		}
+	}
+	return &hamtLeaf{hash: l.hash, entries: append(entries, entry)}, true
+}
+
+// delete returns a copy of the leaf without the node, or nil if the leaf is left empty, and
+// whether the node was found.
+func (l *hamtLeaf) delete(node string) (*hamtLeaf, bool) {
+	for i, entry := range l.entries {
+		if entry.node != node {
+			continue
+		}
+		if len(l.entries) == 1 {
+			return nil, true
+		}
+		entries := make([]snapshotEntry, 0, len(l.entries)-1)
+		entries = append(entries, l.entries[:i]...)
+		entries = append(entries, l.entries[i+1:]...)
+		return &hamtLeaf{hash: l.hash, entries: entries}, true
+	}
+	return l, false
+}
+
+// putSnapshot replaces the snapshot map with one where the snapshot of a node is set.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) putSnapshot(node string, snapshot Snapshot) {
+	cache.snapshots.Store(cache.snapshots.Load().set(node, snapshot))
+}
+
+// removeSnapshot replaces the snapshot map with one without the snapshot of a node.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) removeSnapshot(node string) {
+	cache.snapshots.Store(cache.snapshots.Load().delete(node))
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// SnapshotCacheStats are the statistics of a snapshot cache returned by Stats.
+type SnapshotCacheStats struct {
+	// Nodes is the number of nodes with a snapshot or a status
+	Nodes int `json:"nodes"`
+	// Snapshots is the number of nodes with a snapshot
+	Snapshots int `json:"snapshots"`
+	// OpenWatches and OpenDeltaWatches are the numbers of currently open sotw and delta watches
+	OpenWatches      int `json:"openWatches"`
+	OpenDeltaWatches int `json:"openDeltaWatches"`
+	// WatchesCreated is the number of sotw and delta watch requests since the cache was created
+	WatchesCreated int64 `json:"watchesCreated"`
+	// WatchesResponded is the number of responses sent to the sotw and delta watches since the
+	// cache was created
+	WatchesResponded int64 `json:"watchesResponded"`
+	// MemoryUsageBytes is the sum of the sizes of the snapshots, as returned by MemoryUsageBytes
+	MemoryUsageBytes int64 `json:"memoryUsageBytes"`
+}
+
+// Stats returns the statistics of the cache under a single read lock.
+func (cache *snapshotCache) Stats() SnapshotCacheStats {
+	stats := cache.stats("")
+	stats.WatchesCreated = atomic.LoadInt64(&cache.watchesCreated)
+	stats.WatchesResponded = atomic.LoadInt64(&cache.watchesResponded)
+	return stats
+}
+
+// stats returns the statistics of the nodes with the node ID prefix. The counters of the
+// watches created and responded are only kept for the whole cache, and are left at zero.
+func (cache *snapshotCache) stats(prefix string) SnapshotCacheStats {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	var stats SnapshotCacheStats
+	cache.snapshots.Load().forEach(func(node string, _ Snapshot) bool {
+		if !strings.HasPrefix(node, prefix) {
+			return true
+		}
+		stats.Snapshots++
+		stats.MemoryUsageBytes += cache.snapshotSizes[node]
+		if _, ok := cache.status[node]; !ok {
+			stats.Nodes++
+		}
+		return true
+	})
+	for node, info := range cache.status {
+		if !strings.HasPrefix(node, prefix) {
+			continue
+		}
+		stats.Nodes++
+		sotw, delta := info.WatchCount()
+		stats.OpenWatches += sotw
+		stats.OpenDeltaWatches += delta
+	}
+	return stats
+}
+
+// add adds the statistics of another cache.
+func (s *SnapshotCacheStats) add(other SnapshotCacheStats) {
+	s.Nodes += other.Nodes
+	s.Snapshots += other.Snapshots
+	s.OpenWatches += other.OpenWatches
+	s.OpenDeltaWatches += other.OpenDeltaWatches
+	s.WatchesCreated += other.WatchesCreated
+	s.WatchesResponded += other.WatchesResponded
+	s.MemoryUsageBytes += other.MemoryUsageBytes
+}
@@ -29,6 +29,17 @@ type NodeHash interface {
 	ID(node *core.Node) string
 }
 
+// NodeMultiHash is a node hash which maps a node to several node IDs, such as one for each
+// tenant whose APIs the node proxies. The watches and fetches of the node are served the
+// snapshot of the first of its IDs which has one, while ID remains the ID of the node when
+// none has a snapshot.
+type NodeMultiHash interface {
+	NodeHash
+
+	// IDs returns the node IDs of the node in the order of preference.
+	IDs(node *core.Node) []string
+}
+
 // IDHash uses ID field as the node hash.
 type IDHash struct{}
 
@@ -54,6 +65,9 @@ type StatusInfo interface {
 	// GetNumDeltaWatches returns the number of open delta watches.
 	GetNumDeltaWatches() int
 
+	// WatchCount returns the number of open sotw and delta watches.
+	WatchCount() (sotw int, delta int)
+
 	// GetLastWatchRequestTime returns the timestamp of the last discovery watch request.
 	GetLastWatchRequestTime() time.Time
 
@@ -65,6 +79,59 @@ type StatusInfo interface {
 
 	// SetDeltaResponseWatch will set the provided delta response watch to the associate watch ID
 	SetDeltaResponseWatch(int64, envoy_cache.DeltaResponseWatch)
+
+	// GetLastAccessTime returns the timestamp of the last read of the snapshot of the node,
+	// or of the last watch request.
+	GetLastAccessTime() time.Time
+
+	// LastNACKedVersion returns the version of a type last rejected by the node, or an empty
+	// string if the node has not rejected any version of the type.
+	LastNACKedVersion(typeURL string) string
+
+	// LastNACKError returns the error message of the last rejection of a type by the node.
+	LastNACKError(typeURL string) string
+
+	// NACKCount returns the number of times the node rejected a version of a type.
+	NACKCount(typeURL string) int
+
+	// GetNextHeartbeatTime returns the time the next heartbeat of the node is scheduled at,
+	// or zero if the cache does not send heartbeats or has not scheduled one for the node.
+	GetNextHeartbeatTime() time.Time
+
+	// WatchSummary returns a summary of the watches and requests of the node per type URL.
+	WatchSummary() WatchSummaryInfo
+
+	// IsWarmedUp reports whether the snapshot of the node was set with WarmupSnapshot and
+	// has not been set with SetSnapshot since.
+	IsWarmedUp() bool
+}
+
+// WatchSummaryInfo summarizes the watches of a node per type URL, without the response
+// channels of the watches, so that it can be marshalled, for instance by the admin server.
+type WatchSummaryInfo struct {
+	// Watches is the number of open sotw watches per type URL.
+	Watches map[string]int `json:"watches,omitempty"`
+	// DeltaWatches is the number of open delta watches per type URL.
+	DeltaWatches map[string]int `json:"deltaWatches,omitempty"`
+	// LastRequestTimes is the time of the last sotw or delta request per type URL.
+	LastRequestTimes map[string]time.Time `json:"lastRequestTimes,omitempty"`
+	// ACKedVersions is the last version acknowledged per type URL since the node connected.
+	ACKedVersions map[string]string `json:"ackedVersions,omitempty"`
+}
+
+// nackInfo is the last rejection of the responses of a type by a node.
+type nackInfo struct {
+	version string
+	message string
+	count   int
+}
+
+// responseWatch is an open sotw watch with the time it was opened.
+type responseWatch struct {
+	envoy_cache.ResponseWatch
+
+	// openedAt is the time the watch was created
+	openedAt time.Time
 }
 
 type statusInfo struct {
@@ -72,7 +139,7 @@ type statusInfo struct {
 	node *core.Node
 
 	// watches are indexed channels for the response watches and the original requests.
-	watches map[int64]envoy_cache.ResponseWatch
+	watches map[int64]responseWatch
 
 	// deltaWatches are indexed channels for the delta response watches and the original requests
 	deltaWatches map[int64]envoy_cache.DeltaResponseWatch
@@ -83,6 +150,34 @@ type statusInfo struct {
 	// the timestamp of the last delta watch request
 	lastDeltaWatchRequestTime time.Time
 
+	// the timestamps of the last sotw or delta request per type URL
+	lastRequestTimes map[string]time.Time
+
+	// the timestamp of the last access of the node, used to evict the least recently accessed node
+	lastAccessTime time.Time
+
+	// nacks are the rejections of the responses by the node, indexed by type URLs
+	nacks map[string]*nackInfo
+
+	// nextHeartbeat is the time the next heartbeat of the node is due
+	nextHeartbeat time.Time
+
+	// state is the connection state of the node
+	state NodeState
+
+	// acked are the versions acknowledged by the node since it connected, indexed by type URLs
+	acked map[string]string
+
+	// warmedUp is set while the snapshot of the node is a warmed up snapshot
+	warmedUp bool
+
+	// sent are the versions last responded to the node, indexed by type URLs. The server ignores
+	// the requests with the nonce of an older response, so a NACK rejects the last sent version.
+	sent map[string]string
+
+	// mutex to protect the sent versions, as the responses are sent while mu may be held.
+	sentMu sync.Mutex
+
 	// mutex to protect the status fields.
 	// should not acquire mutex of the parent cache after acquiring this mutex.
 	mu sync.RWMutex
@@ -92,7 +187,7 @@ type statusInfo struct {
 func newStatusInfo(node *core.Node) *statusInfo {
 	out := statusInfo{
 		node:         node,
-		watches:      make(map[int64]envoy_cache.ResponseWatch),
+		watches:      make(map[int64]responseWatch),
 		deltaWatches: make(map[int64]envoy_cache.DeltaResponseWatch),
 	}
 	return &out
@@ -116,12 +211,144 @@ func (info *statusInfo) GetNumDeltaWatches() int {
 	return len(info.deltaWatches)
 }
 
+func (info *statusInfo) WatchCount() (sotw int, delta int) {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return len(info.watches), len(info.deltaWatches)
+}
+
+// watchDuration returns the time elapsed since the sotw watch was opened, or zero if the
+// watch is not open.
+func (info *statusInfo) watchDuration(watchID int64) time.Duration {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	watch, exists := info.watches[watchID]
+	if !exists {
+		return 0
+	}
+	return time.Since(watch.openedAt)
+}
+
+func (info *statusInfo) IsWarmedUp() bool {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return info.warmedUp
+}
+
+func (info *statusInfo) WatchSummary() WatchSummaryInfo {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+
+	summary := WatchSummaryInfo{
+		Watches:          make(map[string]int),
+		DeltaWatches:     make(map[string]int),
+		LastRequestTimes: make(map[string]time.Time, len(info.lastRequestTimes)),
+		ACKedVersions:    make(map[string]string, len(info.acked)),
+	}
+	for _, watch := range info.watches {
+		summary.Watches[watch.Request.GetTypeUrl()]++
+	}
+	for _, watch := range info.deltaWatches {
+		summary.DeltaWatches[watch.Request.GetTypeUrl()]++
+	}
+	for typeURL, t := range info.lastRequestTimes {
+		summary.LastRequestTimes[typeURL] = t
+	}
+	for typeURL, version := range info.acked {
+		summary.ACKedVersions[typeURL] = version
+	}
+	return summary
+}
+
+// recordRequest records the time of a request of the node for a type. The status info mutex
+// must be held by the caller.
+func (info *statusInfo) recordRequest(typeURL string, t time.Time) {
+	if info.lastRequestTimes == nil {
+		info.lastRequestTimes = make(map[string]time.Time)
+	}
+	info.lastRequestTimes[typeURL] = t
+}
+
 func (info *statusInfo) GetLastWatchRequestTime() time.Time {
 	info.mu.RLock()
 	defer info.mu.RUnlock()
 	return info.lastWatchRequestTime
 }
 
+func (info *statusInfo) GetNextHeartbeatTime() time.Time {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return info.nextHeartbeat
+}
+
+func (info *statusInfo) GetLastAccessTime() time.Time {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	return info.lastAccessTime
+}
+
+func (info *statusInfo) LastNACKedVersion(typeURL string) string {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	if nack, ok := info.nacks[typeURL]; ok {
+		return nack.version
+	}
+	return ""
+}
+
+func (info *statusInfo) LastNACKError(typeURL string) string {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	if nack, ok := info.nacks[typeURL]; ok {
+		return nack.message
+	}
+	return ""
+}
+
+func (info *statusInfo) NACKCount(typeURL string) int {
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+	if nack, ok := info.nacks[typeURL]; ok {
+		return nack.count
+	}
+	return 0
+}
+
+// recordNACK records the rejection of a version of a type by the node.
+func (info *statusInfo) recordNACK(typeURL string, version string, message string) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.nacks == nil {
+		info.nacks = make(map[string]*nackInfo)
+	}
+	nack, ok := info.nacks[typeURL]
+	if !ok {
+		nack = &nackInfo{}
+		info.nacks[typeURL] = nack
+	}
+	nack.version = version
+	nack.message = message
+	nack.count++
+}
+
+// recordSent records the version of the last response of a type sent to the node.
+func (info *statusInfo) recordSent(typeURL string, version string) {
+	info.sentMu.Lock()
+	defer info.sentMu.Unlock()
+	if info.sent == nil {
+		info.sent = make(map[string]string)
+	}
+	info.sent[typeURL] = version
+}
+
+// lastSent returns the version of the last response of a type sent to the node, or an empty
+// string if no response of the type was sent.
+func (info *statusInfo) lastSent(typeURL string) string {
+	info.sentMu.Lock()
+	defer info.sentMu.Unlock()
+	return info.sent[typeURL]
+}
+
 func (info *statusInfo) GetLastDeltaWatchRequestTime() time.Time {
 	info.mu.RLock()
 	defer info.mu.RUnlock()
@@ -0,0 +1,99 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	wso2_types "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/types"
+	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
+)
+
+// SnapshotTransaction buffers the changes to the snapshot of a node and applies them to
+// the cache with a single SetSnapshot call on commit.
+type SnapshotTransaction struct {
+	cache   SnapshotCache
+	node    string
+	version string
+
+	// base is the snapshot of the node when the transaction began
+	base       Snapshot
+	baseExists bool
+
+	changes   map[wso2_types.ResponseType]envoy_cache.Resources
+	committed bool
+}
+
+// BeginTransaction starts a transaction on the snapshot of a node. The resource types set in
+// the transaction are replaced with the given version on commit, and the other resource
+// types are kept as they were when the transaction began.
+func BeginTransaction(cache SnapshotCache, node string, version string) *SnapshotTransaction {
+	base, err := cache.GetSnapshot(node)
+	return &SnapshotTransaction{
+		cache:      cache,
+		node:       node,
+		version:    version,
+		base:       base,
+		baseExists: err == nil,
+		changes:    make(map[wso2_types.ResponseType]envoy_cache.Resources),
+	}
+}
+
+// Set replaces the resources of a type in the transaction.
+func (tx *SnapshotTransaction) Set(typeURL resource.Type, resources []types.Resource) error {
+	if tx.committed {
+		return errors.New("transaction is already committed")
+	}
+	index := GetResponseType(typeURL)
+	if index == wso2_types.UnknownType {
+		return errors.New("unknown resource type: " + typeURL)
+	}
+	tx.changes[index] = NewResources(tx.version, resources)
+	return nil
+}
+
+// Commit applies the buffered changes to the cache.
+func (tx *SnapshotTransaction) Commit(ctx context.Context) error {
+	if tx.committed {
+		return errors.New("transaction is already committed")
+	}
+	tx.committed = true
+
+	snapshot := tx.base
+	snapshot.VersionMap = nil
+	for index, resources := range tx.changes {
+		snapshot.Resources[index] = resources
+	}
+	return tx.cache.SetSnapshot(ctx, tx.node, snapshot)
+}
+
+// Rollback discards the buffered changes. If the transaction was already committed, the
+// snapshot of the node is restored to the snapshot it had when the transaction began.
+func (tx *SnapshotTransaction) Rollback(ctx context.Context) error {
+	tx.changes = make(map[wso2_types.ResponseType]envoy_cache.Resources)
+	if !tx.committed {
+		return nil
+	}
+	tx.committed = false
+
+	if !tx.baseExists {
+		tx.cache.ClearSnapshot(tx.node)
+		return nil
+	}
+	return tx.cache.SetSnapshot(ctx, tx.node, tx.base)
+}
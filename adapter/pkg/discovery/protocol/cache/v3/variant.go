@@ -0,0 +1,120 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// NodeVariantSelector selects the snapshot variant served to a node from the node metadata,
+// such as "stable" or "canary". The empty variant is the snapshot set with SetSnapshot.
+type NodeVariantSelector interface {
+	Variant(node *core.Node) string
+}
+
+// MetadataVariantSelector selects the variant named by the string field of the node metadata
+// with the key.
+type MetadataVariantSelector string
+
+// Variant returns the value of the metadata field of the node.
+func (key MetadataVariantSelector) Variant(node *core.Node) string {
+	return node.GetMetadata().GetFields()[string(key)].GetStringValue()
+}
+
+// WithNodeVariantSelector sets the selector of the snapshot variants served to the nodes.
+// Without a selector, the nodes are served the snapshots set with SetSnapshot only.
+func WithNodeVariantSelector(selector NodeVariantSelector) SnapshotCacheOption {
+	return func(cache *snapshotCache) {
+		cache.variantSelector = selector
+	}
+}
+
+// SetSnapshotVariant sets a named variant of the snapshot of a node, and responds to the open
+// watches of the node which select the variant. The empty variant is the snapshot of the node,
+// set with SetSnapshot.
+//
+// The variants are served to the state-of-the-world watches and fetches of the node whose
+// metadata selects them. A node selecting a variant it has none of is served its snapshot,
+// and the delta watches are always served the snapshot of the node.
+func (cache *snapshotCache) SetSnapshotVariant(ctx context.Context, node string, variant string, snapshot Snapshot) error {
+	if variant == "" {
+		return cache.SetSnapshot(ctx, node, snapshot)
+	}
+	if err := snapshot.Validate(); err != nil {
+		return fmt.Errorf("invalid snapshot for node %s variant %s: %w", node, variant, err)
+	}
+	if cache.schemas != nil {
+		if err := cache.schemas.Validate(snapshot); err != nil {
+			return fmt.Errorf("invalid snapshot for node %s variant %s: %w", node, variant, err)
+		}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.variants == nil {
+		cache.variants = make(map[string]map[string]Snapshot)
+	}
+	if cache.variants[node] == nil {
+		cache.variants[node] = make(map[string]Snapshot)
+	}
+	cache.variants[node][variant] = snapshot
+	cache.log.Debug("set snapshot variant", nodeField(node), Field{Key: "variant", Value: variant})
+
+	return cache.respondWatches(ctx, cache.pendingVariantResponses(node, variant, snapshot))
+}
+
+// GetSnapshotVariant gets a named variant of the snapshot of a node, and returns an error if
+// not found. The empty variant is the snapshot of the node.
+func (cache *snapshotCache) GetSnapshotVariant(node string, variant string) (Snapshot, error) {
+	if variant == "" {
+		return cache.GetSnapshot(node)
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	snapshot, ok := cache.variants[node][variant]
+	if !ok {
+		return Snapshot{}, fmt.Errorf("no snapshot found for node %s variant %s", node, variant)
+	}
+	return snapshot, nil
+}
+
+// servedVariant returns the variant served to a node, or the empty variant if the node
+// selects none or a variant which is not set. The cache mutex must be held by the caller.
+func (cache *snapshotCache) servedVariant(nodeID string, node *core.Node) string {
+	if cache.variantSelector == nil || len(cache.variants[nodeID]) == 0 {
+		return ""
+	}
+	variant := cache.variantSelector.Variant(node)
+	if _, ok := cache.variants[nodeID][variant]; !ok {
+		return ""
+	}
+	return variant
+}
+
+// servedSnapshot returns the snapshot served to the state-of-the-world requests of a node,
+// which is the variant selected by the node, or else the snapshot returned by snapshotFor.
+// The cache mutex must be held by the caller.
+func (cache *snapshotCache) servedSnapshot(nodeID string, node *core.Node) (Snapshot, bool) {
+	if variant := cache.servedVariant(nodeID, node); variant != "" {
+		return cache.variants[nodeID][variant], true
+	}
+	return cache.snapshotFor(nodeID, node)
+}
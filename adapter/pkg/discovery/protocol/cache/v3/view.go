@@ -0,0 +1,46 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+// CacheReadView is the snapshots of all nodes in a cache at a single instant, returned by
+// ReadView. It is not changed by the later updates of the cache. As with GetSnapshot, the
+// snapshots share their resource maps with the cache and must not be modified.
+type CacheReadView struct {
+	snapshots *snapshotMap
+}
+
+// Get returns the snapshot of a node, and whether the node had a snapshot.
+func (v CacheReadView) Get(node string) (Snapshot, bool) {
+	return v.snapshots.get(node)
+}
+
+// ForEach calls fn for the snapshot of each node.
+func (v CacheReadView) ForEach(fn func(node string, snapshot Snapshot)) {
+	v.snapshots.forEach(func(node string, snapshot Snapshot) bool {
+		fn(node, snapshot)
+		return true
+	})
+}
+
+// Len returns the number of nodes in the view.
+func (v CacheReadView) Len() int {
+	return v.snapshots.len()
+}
+
+// ReadView returns the snapshots of all nodes as they are set. The view shares the immutable
+// snapshot map of the cache, so it is taken without copying the snapshots or locking the cache.
+func (cache *snapshotCache) ReadView() CacheReadView {
+	return CacheReadView{snapshots: cache.snapshots.Load()}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+// WatchGroup is a set of watches of a node which are cancelled together, returned by
+// SnapshotCache.WatchGroup.
+type WatchGroup struct {
+	cache *snapshotCache
+	node  *core.Node
+	// nodeID is the ID the group was created for, which is used instead of hashing the node
+	nodeID string
+
+	watches   []int64
+	cancelled bool
+	// done is closed when the group is cancelled
+	done chan struct{}
+	mu   sync.Mutex
+}
+
+// WatchGroup creates an empty watch group for a node. The group is cancelled when the
+// context is done, or when Cancel is called.
+func (cache *snapshotCache) WatchGroup(ctx context.Context, node string) *WatchGroup {
+	cache.mu.RLock()
+	metadata := &core.Node{Id: node}
+	if info, ok := cache.status[node]; ok && info.GetNode() != nil {
+		metadata = info.GetNode()
+	}
+	cache.mu.RUnlock()
+
+	group := &WatchGroup{
+		cache:  cache,
+		node:   metadata,
+		nodeID: node,
+		done:   make(chan struct{}),
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				group.Cancel()
+			case <-group.done:
+			}
+		}()
+	}
+	return group
+}
+
+// Add creates a watch on a type for the node of the group, as CreateWatch does for a request
+// without a version. A watch responded right away is not added to the group. Add has no
+// effect once the group is cancelled.
+func (g *WatchGroup) Add(typeURL string, value chan envoy_cache.Response) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancelled {
+		return
+	}
+
+	request := &envoy_cache.Request{Node: g.node, TypeUrl: typeURL}
+	g.cache.mu.Lock()
+	defer g.cache.mu.Unlock()
+	if watchID := g.cache.openWatch(g.nodeID, request, stream.NewStreamState(false, nil), value); watchID != 0 {
+		g.watches = append(g.watches, watchID)
+	}
+}
+
+// Cancel cancels all watches of the group under a single lock of the cache, so that no
+// snapshot update responds to some of the watches but not the others.
+func (g *WatchGroup) Cancel() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancelled {
+		return
+	}
+	g.cancelled = true
+	close(g.done)
+
+	g.cache.mu.Lock()
+	defer g.cache.mu.Unlock()
+	for _, watchID := range g.watches {
+		g.cache.removeWatch(g.nodeID, watchID)
+	}
+	g.watches = nil
+}
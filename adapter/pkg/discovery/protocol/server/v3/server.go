@@ -16,6 +16,7 @@ package server
 
 import (
 	"context"
+	"errors"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
@@ -27,6 +28,7 @@ import (
 	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/service/api"
 	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/service/config"
 	"github.com/wso2/apk/adapter/pkg/discovery/api/wso2/discovery/service/subscription"
+	wso2_cache "github.com/wso2/apk/adapter/pkg/discovery/protocol/cache/v3"
 	"github.com/wso2/apk/adapter/pkg/discovery/protocol/resource/v3"
 	"github.com/wso2/apk/adapter/pkg/discovery/protocol/server/sotw/v3"
 	"google.golang.org/grpc/codes"
@@ -79,9 +81,14 @@ func (s *server) StreamApis(stream api.ApiDiscoveryService_StreamApisServer) err
 	return s.StreamHandler(stream, resource.JWTIssuerListType)
 }
 
-// Fetch is the universal fetch method.
+// Fetch is the universal fetch method. A request for a node without a snapshot fails with
+// the NotFound status code.
 func (s *server) Fetch(ctx context.Context, req *discovery.DiscoveryRequest) (*discovery.DiscoveryResponse, error) {
-	return s.rest.Fetch(ctx, req)
+	resp, err := s.rest.Fetch(ctx, req)
+	if errors.Is(err, wso2_cache.ErrNodeNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return resp, err
 }
 
 func (s *server) FetchConfigs(ctx context.Context, req *discovery.DiscoveryRequest) (*discovery.DiscoveryResponse, error) {
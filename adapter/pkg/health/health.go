@@ -23,6 +23,7 @@ import (
 
 	healthservice "github.com/wso2/apk/adapter/pkg/health/api/wso2/health/service"
 	logger "github.com/wso2/apk/adapter/pkg/loggers"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
@@ -39,6 +40,9 @@ const (
 	RestService               service = "adapter.internal.RestService"
 	RateLimiterGrpcService    service = "adapter.internal.RateLimiterGrpcService"
 	CommonEnforcerGrpcService service = "adapter.internal.CommonEnforcerGrpcService"
+	// EnforcerXdsCacheService is the health of the enforcer xds snapshot cache, reported by
+	// the Checker of the StandardServer
+	EnforcerXdsCacheService service = "adapter.internal.EnforcerXdsCacheService"
 )
 
 type service string
@@ -90,3 +94,34 @@ func (s Server) Check(ctx context.Context, request *healthservice.HealthCheckReq
 	logger.LoggerHealth.Debugf("Responding health state of Adapter service \"%s\" as UNKNOWN", request.Service)
 	return &healthservice.HealthCheckResponse{Status: healthservice.HealthCheckResponse_UNKNOWN}, nil
 }
+
+// StandardServer represents the Health GRPC server registered with the grpc_health_v1 package.
+// It reports the health of the EnforcerXdsCacheService with the Checker, and the health of the
+// Adapter and its other services as the Server does.
+type StandardServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+
+	// Checker reports the health of the EnforcerXdsCacheService
+	Checker grpc_health_v1.HealthServer
+}
+
+// Check responds the health check client with health status of the Adapter
+func (s StandardServer) Check(ctx context.Context, request *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if request.Service == string(EnforcerXdsCacheService) && s.Checker != nil {
+		return s.Checker.Check(ctx, request)
+	}
+	response, err := Server{}.Check(ctx, &healthservice.HealthCheckRequest{Service: request.Service})
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_ServingStatus(response.Status)}, nil
+}
+
+// Watch streams the health status of the EnforcerXdsCacheService. Watching the other services
+// is not implemented.
+func (s StandardServer) Watch(request *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	if request.Service == string(EnforcerXdsCacheService) && s.Checker != nil {
+		return s.Checker.Watch(request, stream)
+	}
+	return s.UnimplementedHealthServer.Watch(request, stream)
+}